@@ -0,0 +1,6 @@
+ALTER TABLE "categories"
+  DROP COLUMN IF EXISTS "description",
+  DROP COLUMN IF EXISTS "color",
+  DROP COLUMN IF EXISTS "icon",
+  DROP COLUMN IF EXISTS "position",
+  DROP COLUMN IF EXISTS "archived";
@@ -0,0 +1,13 @@
+ALTER TABLE "categories"
+  ADD COLUMN "description" varchar NOT NULL DEFAULT '',
+  ADD COLUMN "color" varchar(7) NOT NULL DEFAULT '',
+  ADD COLUMN "icon" varchar NOT NULL DEFAULT '',
+  ADD COLUMN "position" INT NOT NULL DEFAULT 0,
+  ADD COLUMN "archived" boolean NOT NULL DEFAULT false;
+
+UPDATE "categories" c
+SET "position" = ordered.rn
+FROM (SELECT id, ROW_NUMBER() OVER (ORDER BY id) AS rn FROM "categories") ordered
+WHERE c.id = ordered.id;
+
+CREATE INDEX ON "categories" ("archived", "position");
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS "contacts_category_id_created_at_idx";
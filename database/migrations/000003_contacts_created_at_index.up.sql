@@ -0,0 +1 @@
+CREATE INDEX ON "contacts" ("category_id", "created_at");
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS "categories_label_key_idx";
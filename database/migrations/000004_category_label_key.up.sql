@@ -0,0 +1,3 @@
+UPDATE "categories" SET "label" = regexp_replace(btrim("label"), '\s+', ' ', 'g');
+
+CREATE UNIQUE INDEX "categories_label_key_idx" ON "categories" (lower(regexp_replace(btrim("label"), '\s+', ' ', 'g')));
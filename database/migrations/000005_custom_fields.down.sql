@@ -0,0 +1,2 @@
+ALTER TABLE "contacts" DROP COLUMN IF EXISTS "custom_fields";
+DROP TABLE IF EXISTS "category_fields";
@@ -0,0 +1,16 @@
+CREATE TABLE "category_fields" (
+  "id" BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+  "category_id" BIGINT NOT NULL,
+  "key" varchar NOT NULL,
+  "label" varchar NOT NULL,
+  "type" varchar NOT NULL CHECK ("type" IN ('text', 'number', 'date', 'enum', 'boolean')),
+  "options" text[] NOT NULL DEFAULT '{}',
+  "required" boolean NOT NULL DEFAULT false,
+  "created_at" timestamp DEFAULT (now()),
+  FOREIGN KEY ("category_id") REFERENCES "categories" ("id") ON DELETE CASCADE,
+  UNIQUE ("category_id", "key")
+);
+
+ALTER TABLE "contacts" ADD COLUMN "custom_fields" jsonb NOT NULL DEFAULT '{}';
+
+CREATE INDEX ON "contacts" USING GIN ("custom_fields");
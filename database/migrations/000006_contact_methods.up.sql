@@ -0,0 +1,51 @@
+CREATE TABLE "contact_phones" (
+  "id" BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+  "contact_id" BIGINT NOT NULL,
+  "type" varchar NOT NULL DEFAULT 'work',
+  "value" varchar NOT NULL,
+  "is_primary" boolean NOT NULL DEFAULT false,
+  "position" INT NOT NULL DEFAULT 0,
+  "created_at" timestamp DEFAULT (now()),
+  FOREIGN KEY ("contact_id") REFERENCES "contacts" ("id") ON DELETE CASCADE
+);
+
+CREATE TABLE "contact_emails" (
+  "id" BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+  "contact_id" BIGINT NOT NULL,
+  "type" varchar NOT NULL DEFAULT 'work',
+  "value" varchar NOT NULL,
+  "is_primary" boolean NOT NULL DEFAULT false,
+  "position" INT NOT NULL DEFAULT 0,
+  "created_at" timestamp DEFAULT (now()),
+  FOREIGN KEY ("contact_id") REFERENCES "contacts" ("id") ON DELETE CASCADE
+);
+
+CREATE TABLE "contact_addresses" (
+  "id" BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+  "contact_id" BIGINT NOT NULL,
+  "type" varchar NOT NULL DEFAULT 'work',
+  "value" varchar NOT NULL,
+  "is_primary" boolean NOT NULL DEFAULT false,
+  "position" INT NOT NULL DEFAULT 0,
+  "created_at" timestamp DEFAULT (now()),
+  FOREIGN KEY ("contact_id") REFERENCES "contacts" ("id") ON DELETE CASCADE
+);
+
+CREATE INDEX ON "contact_phones" ("contact_id");
+CREATE INDEX ON "contact_emails" ("contact_id");
+CREATE INDEX ON "contact_addresses" ("contact_id");
+
+CREATE UNIQUE INDEX ON "contact_phones" ("contact_id") WHERE "is_primary";
+CREATE UNIQUE INDEX ON "contact_emails" ("contact_id") WHERE "is_primary";
+CREATE UNIQUE INDEX ON "contact_addresses" ("contact_id") WHERE "is_primary";
+
+INSERT INTO "contact_phones" ("contact_id", "value", "is_primary")
+SELECT "id", "phone", true FROM "contacts" WHERE "phone" <> '';
+
+INSERT INTO "contact_emails" ("contact_id", "value", "is_primary")
+SELECT DISTINCT ON (lower("email")) "id", "email", true FROM "contacts" WHERE "email" <> '' ORDER BY lower("email"), "id";
+
+INSERT INTO "contact_addresses" ("contact_id", "value", "is_primary")
+SELECT "id", "address", true FROM "contacts" WHERE "address" <> '';
+
+CREATE UNIQUE INDEX "contact_emails_value_key" ON "contact_emails" (lower("value"));
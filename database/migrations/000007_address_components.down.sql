@@ -0,0 +1,8 @@
+ALTER TABLE "contact_addresses"
+  DROP COLUMN IF EXISTS "street",
+  DROP COLUMN IF EXISTS "building",
+  DROP COLUMN IF EXISTS "city",
+  DROP COLUMN IF EXISTS "region",
+  DROP COLUMN IF EXISTS "postal_code",
+  DROP COLUMN IF EXISTS "country",
+  DROP COLUMN IF EXISTS "needs_review";
@@ -0,0 +1,34 @@
+ALTER TABLE "contact_addresses"
+  ADD COLUMN "street" varchar NOT NULL DEFAULT '',
+  ADD COLUMN "building" varchar NOT NULL DEFAULT '',
+  ADD COLUMN "city" varchar NOT NULL DEFAULT '',
+  ADD COLUMN "region" varchar NOT NULL DEFAULT '',
+  ADD COLUMN "postal_code" varchar NOT NULL DEFAULT '',
+  ADD COLUMN "country" varchar NOT NULL DEFAULT '',
+  ADD COLUMN "needs_review" boolean NOT NULL DEFAULT false;
+
+-- Best effort split of the legacy free text: "street building, city[, region], [postal code] country".
+-- Every parsed address is flagged for review.
+WITH parsed AS (
+  SELECT "id", parts, array_length(parts, 1) AS n, substring("value" FROM '\m(\d{4,6})\M') AS postal_code
+  FROM (
+    SELECT "id", "value", regexp_split_to_array(btrim("value"), '\s*,\s*') AS parts
+    FROM "contact_addresses"
+    WHERE "value" <> ''
+  ) split
+)
+UPDATE "contact_addresses" a
+SET
+  "street" = btrim(regexp_replace(p.parts[1], '\s+\d+[A-Za-z]?(/\d+)?$', '')),
+  "building" = COALESCE(substring(p.parts[1] FROM '\s(\d+[A-Za-z]?(/\d+)?)$'), ''),
+  "city" = CASE WHEN p.n >= 2 THEN btrim(regexp_replace(p.parts[2], '\m\d{4,6}\M', '')) ELSE '' END,
+  "region" = CASE WHEN p.n >= 4 THEN btrim(regexp_replace(p.parts[3], '\m\d{4,6}\M', '')) ELSE '' END,
+  "country" = CASE WHEN p.n >= 3 THEN btrim(regexp_replace(p.parts[p.n], '\m\d{4,6}\M', '')) ELSE '' END,
+  "postal_code" = COALESCE(p.postal_code, ''),
+  "needs_review" = true
+FROM parsed p
+WHERE a."id" = p."id";
+
+CREATE INDEX ON "contact_addresses" (lower("city"));
+CREATE INDEX ON "contact_addresses" (lower("region"));
+CREATE INDEX ON "contact_addresses" (lower("country"));
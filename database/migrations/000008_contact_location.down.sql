@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS "contacts_location_idx";
+
+ALTER TABLE "contacts"
+  DROP COLUMN IF EXISTS "latitude",
+  DROP COLUMN IF EXISTS "longitude",
+  DROP COLUMN IF EXISTS "location_source";
@@ -0,0 +1,10 @@
+CREATE EXTENSION IF NOT EXISTS cube;
+CREATE EXTENSION IF NOT EXISTS earthdistance;
+
+ALTER TABLE "contacts"
+  ADD COLUMN "latitude" double precision,
+  ADD COLUMN "longitude" double precision,
+  ADD COLUMN "location_source" varchar NOT NULL DEFAULT '' CHECK ("location_source" IN ('', 'geocoded', 'manual'));
+
+CREATE INDEX "contacts_location_idx" ON "contacts" USING gist (ll_to_earth("latitude", "longitude"))
+  WHERE "latitude" IS NOT NULL AND "longitude" IS NOT NULL;
@@ -0,0 +1,5 @@
+ALTER TABLE "contacts"
+  DROP COLUMN IF EXISTS "organization_id",
+  DROP COLUMN IF EXISTS "job_title";
+
+DROP TABLE IF EXISTS "organizations";
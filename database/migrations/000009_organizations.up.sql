@@ -0,0 +1,17 @@
+CREATE TABLE "organizations" (
+  "id" BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+  "name" varchar NOT NULL,
+  "tax_id" varchar NOT NULL DEFAULT '',
+  "website" varchar NOT NULL DEFAULT '',
+  "address" varchar NOT NULL DEFAULT '',
+  "created_at" timestamp DEFAULT (now())
+);
+
+CREATE INDEX ON "organizations" ("name");
+CREATE UNIQUE INDEX "organizations_tax_id_key" ON "organizations" ("tax_id") WHERE "tax_id" <> '';
+
+ALTER TABLE "contacts"
+  ADD COLUMN "organization_id" BIGINT REFERENCES "organizations" ("id") ON DELETE SET NULL,
+  ADD COLUMN "job_title" varchar NOT NULL DEFAULT '';
+
+CREATE INDEX ON "contacts" ("organization_id");
@@ -0,0 +1,14 @@
+CREATE TABLE "contact_relationships" (
+  "id" BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+  "contact_id" BIGINT NOT NULL,
+  "related_contact_id" BIGINT NOT NULL,
+  "type" varchar NOT NULL CHECK ("type" IN ('manager', 'assistant', 'spouse', 'referrer')),
+  "bidirectional" boolean NOT NULL DEFAULT false,
+  "created_at" timestamp DEFAULT (now()),
+  FOREIGN KEY ("contact_id") REFERENCES "contacts" ("id") ON DELETE CASCADE,
+  FOREIGN KEY ("related_contact_id") REFERENCES "contacts" ("id") ON DELETE CASCADE,
+  UNIQUE ("contact_id", "related_contact_id", "type"),
+  CHECK ("contact_id" <> "related_contact_id")
+);
+
+CREATE INDEX ON "contact_relationships" ("related_contact_id");
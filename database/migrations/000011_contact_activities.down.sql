@@ -0,0 +1,2 @@
+ALTER TABLE "contacts" DROP COLUMN IF EXISTS "last_contacted_at";
+DROP TABLE IF EXISTS "contact_activities";
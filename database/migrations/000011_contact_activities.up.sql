@@ -0,0 +1,17 @@
+CREATE TABLE "contact_activities" (
+  "id" BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+  "contact_id" BIGINT NOT NULL,
+  "type" varchar NOT NULL CHECK ("type" IN ('note', 'call', 'meeting', 'email')),
+  "occurred_at" timestamp NOT NULL DEFAULT (now()),
+  "author" varchar NOT NULL DEFAULT '',
+  "body" text NOT NULL DEFAULT '',
+  "created_at" timestamp DEFAULT (now()),
+  "updated_at" timestamp DEFAULT (now()),
+  FOREIGN KEY ("contact_id") REFERENCES "contacts" ("id") ON DELETE CASCADE
+);
+
+CREATE INDEX ON "contact_activities" ("contact_id", "occurred_at");
+
+ALTER TABLE "contacts" ADD COLUMN "last_contacted_at" timestamp;
+
+CREATE INDEX ON "contacts" ("last_contacted_at");
@@ -0,0 +1,17 @@
+CREATE TABLE "contact_tasks" (
+  "id" BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+  "contact_id" BIGINT NOT NULL,
+  "title" varchar NOT NULL,
+  "description" text NOT NULL DEFAULT '',
+  "due_at" timestamptz NOT NULL,
+  "assignee" varchar NOT NULL DEFAULT '',
+  "status" varchar NOT NULL DEFAULT 'open' CHECK ("status" IN ('open', 'in_progress', 'done', 'cancelled')),
+  "reminded_at" timestamptz,
+  "created_at" timestamp DEFAULT (now()),
+  "updated_at" timestamp DEFAULT (now()),
+  FOREIGN KEY ("contact_id") REFERENCES "contacts" ("id") ON DELETE CASCADE
+);
+
+CREATE INDEX ON "contact_tasks" ("contact_id");
+CREATE INDEX ON "contact_tasks" ("assignee", "due_at");
+CREATE INDEX "contact_tasks_pending_idx" ON "contact_tasks" ("due_at") WHERE "status" IN ('open', 'in_progress');
@@ -0,0 +1,3 @@
+DROP FUNCTION IF EXISTS next_anniversary(integer, integer, date);
+DROP FUNCTION IF EXISTS anniversary_in(integer, integer, integer);
+DROP TABLE IF EXISTS "contact_dates";
@@ -0,0 +1,31 @@
+CREATE TABLE "contact_dates" (
+  "id" BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+  "contact_id" BIGINT NOT NULL,
+  "type" varchar NOT NULL CHECK ("type" IN ('birthday', 'anniversary', 'contract_renewal')),
+  "label" varchar NOT NULL DEFAULT '',
+  "month" smallint NOT NULL CHECK ("month" BETWEEN 1 AND 12),
+  "day" smallint NOT NULL CHECK ("day" BETWEEN 1 AND 31),
+  "year" integer,
+  "created_at" timestamp DEFAULT (now()),
+  FOREIGN KEY ("contact_id") REFERENCES "contacts" ("id") ON DELETE CASCADE
+);
+
+CREATE INDEX ON "contact_dates" ("contact_id");
+
+-- anniversary_in returns the day a recurring date falls on in the given year.
+-- February 29 is observed on February 28 in common years.
+CREATE FUNCTION anniversary_in(m integer, d integer, y integer) RETURNS date AS $$
+  SELECT make_date(y, m, CASE
+    WHEN m = 2 AND d = 29 AND NOT (y % 4 = 0 AND (y % 100 <> 0 OR y % 400 = 0)) THEN 28
+    ELSE d
+  END)
+$$ LANGUAGE sql IMMUTABLE;
+
+-- next_anniversary returns the first occurrence of a recurring date on or after from_date.
+CREATE FUNCTION next_anniversary(m integer, d integer, from_date date) RETURNS date AS $$
+  SELECT CASE
+    WHEN anniversary_in(m, d, extract(year FROM from_date)::integer) >= from_date
+      THEN anniversary_in(m, d, extract(year FROM from_date)::integer)
+    ELSE anniversary_in(m, d, extract(year FROM from_date)::integer + 1)
+  END
+$$ LANGUAGE sql IMMUTABLE;
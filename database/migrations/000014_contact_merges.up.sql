@@ -0,0 +1,12 @@
+CREATE TABLE "contact_merges" (
+  "id" BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+  "contact_id" BIGINT NOT NULL,
+  "merged_contact_id" BIGINT NOT NULL,
+  "merged_name" varchar NOT NULL,
+  "snapshot" jsonb NOT NULL,
+  "fields" jsonb NOT NULL DEFAULT '{}',
+  "created_at" timestamp DEFAULT (now()),
+  FOREIGN KEY ("contact_id") REFERENCES "contacts" ("id") ON DELETE CASCADE
+);
+
+CREATE INDEX ON "contact_merges" ("contact_id");
@@ -0,0 +1,15 @@
+DROP TRIGGER IF EXISTS "organizations_touch" ON "organizations";
+DROP FUNCTION IF EXISTS touch_organization_contacts();
+DROP TRIGGER IF EXISTS "categories_touch" ON "categories";
+DROP FUNCTION IF EXISTS touch_category_contacts();
+DROP TRIGGER IF EXISTS "contact_addresses_touch" ON "contact_addresses";
+DROP TRIGGER IF EXISTS "contact_emails_touch" ON "contact_emails";
+DROP TRIGGER IF EXISTS "contact_phones_touch" ON "contact_phones";
+DROP FUNCTION IF EXISTS touch_contact_method();
+DROP TRIGGER IF EXISTS "contacts_bury" ON "contacts";
+DROP FUNCTION IF EXISTS bury_contact();
+DROP TRIGGER IF EXISTS "contacts_touch" ON "contacts";
+DROP FUNCTION IF EXISTS touch_contact();
+DROP TABLE IF EXISTS "contact_tombstones";
+ALTER TABLE "contacts" DROP COLUMN IF EXISTS "dav_uid", DROP COLUMN IF EXISTS "dav_name", DROP COLUMN IF EXISTS "sync_version";
+DROP SEQUENCE IF EXISTS "contact_sync_seq";
@@ -0,0 +1,97 @@
+-- contact_sync_seq orders every change visible to CardDAV clients; a sync
+-- token is the highest version a client has seen.
+CREATE SEQUENCE "contact_sync_seq";
+
+ALTER TABLE "contacts"
+  ADD COLUMN "sync_version" BIGINT NOT NULL DEFAULT nextval('contact_sync_seq'),
+  ADD COLUMN "dav_name" varchar,
+  ADD COLUMN "dav_uid" varchar;
+
+CREATE INDEX ON "contacts" ("sync_version");
+CREATE UNIQUE INDEX ON "contacts" ("dav_name");
+
+-- contact_tombstones records contacts leaving an address book, either deleted
+-- or moved to another category, so incremental syncs can report them.
+CREATE TABLE "contact_tombstones" (
+  "id" BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+  "contact_id" BIGINT NOT NULL,
+  "category_id" BIGINT NOT NULL,
+  "dav_name" varchar NOT NULL,
+  "sync_version" BIGINT NOT NULL DEFAULT nextval('contact_sync_seq'),
+  "created_at" timestamp DEFAULT (now())
+);
+
+CREATE INDEX ON "contact_tombstones" ("sync_version");
+
+-- touch_contact bumps the version of a changed contact. Changes to columns a
+-- vCard does not carry, such as last_contacted_at, are ignored.
+CREATE FUNCTION touch_contact() RETURNS trigger AS $$
+BEGIN
+  IF to_jsonb(NEW) - 'sync_version' - 'last_contacted_at' = to_jsonb(OLD) - 'sync_version' - 'last_contacted_at'
+    AND NEW.sync_version = OLD.sync_version THEN
+    RETURN NEW;
+  END IF;
+  IF NEW.category_id <> OLD.category_id THEN
+    INSERT INTO contact_tombstones (contact_id, category_id, dav_name)
+    VALUES (OLD.id, OLD.category_id, COALESCE(OLD.dav_name, 'contact-' || OLD.id || '.vcf'));
+  END IF;
+  NEW.sync_version := nextval('contact_sync_seq');
+  RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER "contacts_touch" BEFORE UPDATE ON "contacts"
+  FOR EACH ROW EXECUTE FUNCTION touch_contact();
+
+CREATE FUNCTION bury_contact() RETURNS trigger AS $$
+BEGIN
+  INSERT INTO contact_tombstones (contact_id, category_id, dav_name)
+  VALUES (OLD.id, OLD.category_id, COALESCE(OLD.dav_name, 'contact-' || OLD.id || '.vcf'));
+  RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER "contacts_bury" AFTER DELETE ON "contacts"
+  FOR EACH ROW EXECUTE FUNCTION bury_contact();
+
+-- touch_contact_method bumps the version of the contacts owning a changed
+-- phone, email or address.
+CREATE FUNCTION touch_contact_method() RETURNS trigger AS $$
+BEGIN
+  IF TG_OP IN ('UPDATE', 'DELETE') THEN
+    UPDATE contacts SET sync_version = nextval('contact_sync_seq') WHERE id = OLD.contact_id;
+  END IF;
+  IF TG_OP IN ('INSERT', 'UPDATE') THEN
+    UPDATE contacts SET sync_version = nextval('contact_sync_seq') WHERE id = NEW.contact_id;
+  END IF;
+  RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER "contact_phones_touch" AFTER INSERT OR UPDATE OR DELETE ON "contact_phones"
+  FOR EACH ROW EXECUTE FUNCTION touch_contact_method();
+CREATE TRIGGER "contact_emails_touch" AFTER INSERT OR UPDATE OR DELETE ON "contact_emails"
+  FOR EACH ROW EXECUTE FUNCTION touch_contact_method();
+CREATE TRIGGER "contact_addresses_touch" AFTER INSERT OR UPDATE OR DELETE ON "contact_addresses"
+  FOR EACH ROW EXECUTE FUNCTION touch_contact_method();
+
+-- Category labels and organization names are part of the vCards.
+CREATE FUNCTION touch_category_contacts() RETURNS trigger AS $$
+BEGIN
+  UPDATE contacts SET sync_version = nextval('contact_sync_seq') WHERE category_id = NEW.id;
+  RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER "categories_touch" AFTER UPDATE OF "label" ON "categories"
+  FOR EACH ROW WHEN (OLD.label IS DISTINCT FROM NEW.label) EXECUTE FUNCTION touch_category_contacts();
+
+CREATE FUNCTION touch_organization_contacts() RETURNS trigger AS $$
+BEGIN
+  UPDATE contacts SET sync_version = nextval('contact_sync_seq') WHERE organization_id = NEW.id;
+  RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER "organizations_touch" AFTER UPDATE OF "name" ON "organizations"
+  FOR EACH ROW WHEN (OLD.name IS DISTINCT FROM NEW.name) EXECUTE FUNCTION touch_organization_contacts();
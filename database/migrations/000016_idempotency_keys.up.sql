@@ -0,0 +1,15 @@
+CREATE TABLE "idempotency_keys" (
+  "principal" varchar NOT NULL,
+  "key" varchar NOT NULL,
+  "fingerprint" varchar NOT NULL,
+  "completed" boolean NOT NULL DEFAULT false,
+  "status" integer NOT NULL DEFAULT 0,
+  "content_type" varchar NOT NULL DEFAULT '',
+  "headers" jsonb NOT NULL DEFAULT '{}',
+  "body" bytea,
+  "created_at" timestamp NOT NULL DEFAULT (now()),
+  "expires_at" timestamp NOT NULL,
+  PRIMARY KEY ("principal", "key")
+);
+
+CREATE INDEX ON "idempotency_keys" ("expires_at");
@@ -0,0 +1,15 @@
+CREATE TABLE "saved_searches" (
+  "id" BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+  "owner" varchar NOT NULL,
+  "name" varchar NOT NULL,
+  "shared" boolean NOT NULL DEFAULT false,
+  "query" jsonb NOT NULL DEFAULT '{}',
+  "sort_by" varchar NOT NULL DEFAULT 'created_at',
+  "sort_dir" varchar NOT NULL DEFAULT 'ASC',
+  "created_at" timestamp NOT NULL DEFAULT (now()),
+  "updated_at" timestamp NOT NULL DEFAULT (now())
+);
+
+CREATE UNIQUE INDEX "saved_searches_owner_name_idx" ON "saved_searches" ("owner", lower("name"));
+
+CREATE INDEX ON "saved_searches" ("shared");
@@ -0,0 +1,17 @@
+CREATE OR REPLACE FUNCTION touch_contact() RETURNS trigger AS $$
+BEGIN
+  IF to_jsonb(NEW) - 'sync_version' - 'last_contacted_at' = to_jsonb(OLD) - 'sync_version' - 'last_contacted_at'
+    AND NEW.sync_version = OLD.sync_version THEN
+    RETURN NEW;
+  END IF;
+  IF NEW.category_id <> OLD.category_id THEN
+    INSERT INTO contact_tombstones (contact_id, category_id, dav_name)
+    VALUES (OLD.id, OLD.category_id, COALESCE(OLD.dav_name, 'contact-' || OLD.id || '.vcf'));
+  END IF;
+  NEW.sync_version := nextval('contact_sync_seq');
+  RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+ALTER TABLE "contact_tombstones" DROP COLUMN IF EXISTS "sync_xid";
+ALTER TABLE "contacts" DROP COLUMN IF EXISTS "sync_xid";
@@ -0,0 +1,29 @@
+-- sync_xid is the transaction that last changed a contact or wrote a
+-- tombstone. Sync tokens are based on it rather than on sync_version: a
+-- version drawn by a transaction that commits late can be lower than a token
+-- a client already holds, while the id of a transaction still in progress is
+-- never below the xmin of a snapshot that cannot see it.
+ALTER TABLE "contacts"
+  ADD COLUMN "sync_xid" xid8 NOT NULL DEFAULT pg_current_xact_id();
+
+ALTER TABLE "contact_tombstones"
+  ADD COLUMN "sync_xid" xid8 NOT NULL DEFAULT pg_current_xact_id();
+
+CREATE INDEX ON "contacts" ("sync_xid");
+CREATE INDEX ON "contact_tombstones" ("sync_xid");
+
+CREATE OR REPLACE FUNCTION touch_contact() RETURNS trigger AS $$
+BEGIN
+  IF to_jsonb(NEW) - 'sync_version' - 'sync_xid' - 'last_contacted_at' = to_jsonb(OLD) - 'sync_version' - 'sync_xid' - 'last_contacted_at'
+    AND NEW.sync_version = OLD.sync_version THEN
+    RETURN NEW;
+  END IF;
+  IF NEW.category_id <> OLD.category_id THEN
+    INSERT INTO contact_tombstones (contact_id, category_id, dav_name)
+    VALUES (OLD.id, OLD.category_id, COALESCE(OLD.dav_name, 'contact-' || OLD.id || '.vcf'));
+  END IF;
+  NEW.sync_version := nextval('contact_sync_seq');
+  NEW.sync_xid := pg_current_xact_id();
+  RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
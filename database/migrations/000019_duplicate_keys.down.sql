@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS "contacts_name_trgm_idx";
+DROP INDEX IF EXISTS "contact_emails_match_key_idx";
+DROP INDEX IF EXISTS "contact_phones_match_key_idx";
+
+ALTER TABLE "contact_emails" DROP COLUMN IF EXISTS "match_key";
+ALTER TABLE "contact_phones" DROP COLUMN IF EXISTS "match_key";
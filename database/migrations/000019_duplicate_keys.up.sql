@@ -0,0 +1,13 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+-- Normalized values duplicate detection blocks on, see normalizePhone and
+-- emailLocalPart.
+ALTER TABLE "contact_phones"
+  ADD COLUMN "match_key" varchar GENERATED ALWAYS AS (right(regexp_replace("value", '\D', '', 'g'), 9)) STORED;
+
+ALTER TABLE "contact_emails"
+  ADD COLUMN "match_key" varchar GENERATED ALWAYS AS (replace(split_part(split_part(lower("value"), '@', 1), '+', 1), '.', '')) STORED;
+
+CREATE INDEX "contact_phones_match_key_idx" ON "contact_phones" ("match_key");
+CREATE INDEX "contact_emails_match_key_idx" ON "contact_emails" ("match_key");
+CREATE INDEX "contacts_name_trgm_idx" ON "contacts" USING gin (lower("name") gin_trgm_ops);
@@ -0,0 +1,165 @@
+// Package filterexpr parses contact filter expressions such as
+//
+//	category in ("Suppliers", "Partners") and created_at > 2024-01-01 and not email ends_with "@gmail.com"
+//
+// into an AST. Comparisons are joined with and, or and not, and grouped with
+// parentheses. Operators are =, !=, <, <=, >, >=, contains, starts_with,
+// ends_with, in (...), is null and is not null. Values are double quoted
+// strings, numbers, true, false and dates (2024-01-01 or RFC 3339 times).
+//
+// The AST is independent of storage, which compiles it to SQL.
+package filterexpr
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Expr is a node of a filter expression: And, Or, Not or Comparison.
+type Expr interface {
+	String() string
+}
+
+type And struct {
+	Left, Right Expr
+}
+
+type Or struct {
+	Left, Right Expr
+}
+
+type Not struct {
+	Expr Expr
+}
+
+type Op string
+
+const (
+	OpEq         Op = "="
+	OpNe         Op = "!="
+	OpLt         Op = "<"
+	OpLe         Op = "<="
+	OpGt         Op = ">"
+	OpGe         Op = ">="
+	OpContains   Op = "contains"
+	OpStartsWith Op = "starts_with"
+	OpEndsWith   Op = "ends_with"
+	OpIn         Op = "in"
+	OpIsNull     Op = "is null"
+	OpIsNotNull  Op = "is not null"
+)
+
+// Comparison tests a field against its values: one for most operators,
+// several for in and none for is null and is not null. Pos is the position
+// of the field in the expression, starting at 1.
+type Comparison struct {
+	Field  string
+	Op     Op
+	Values []Value
+	Pos    int
+}
+
+type Kind int
+
+const (
+	KindString Kind = iota
+	KindNumber
+	KindBool
+	KindTime
+)
+
+func (kind Kind) String() string {
+	switch kind {
+	case KindNumber:
+		return "number"
+	case KindBool:
+		return "boolean"
+	case KindTime:
+		return "date"
+	default:
+		return "string"
+	}
+}
+
+// Value is a literal of an expression.
+type Value struct {
+	Kind   Kind
+	Text   string
+	Number float64
+	Bool   bool
+	Time   time.Time
+}
+
+func String(text string) Value {
+	return Value{Kind: KindString, Text: text}
+}
+
+func Number(number float64) Value {
+	return Value{Kind: KindNumber, Number: number}
+}
+
+func Bool(value bool) Value {
+	return Value{Kind: KindBool, Bool: value}
+}
+
+func Time(value time.Time) Value {
+	return Value{Kind: KindTime, Time: value}
+}
+
+// Interface returns the Go value of a literal, for use as a query argument.
+func (value Value) Interface() interface{} {
+	switch value.Kind {
+	case KindNumber:
+		return value.Number
+	case KindBool:
+		return value.Bool
+	case KindTime:
+		return value.Time
+	default:
+		return value.Text
+	}
+}
+
+func (value Value) String() string {
+	switch value.Kind {
+	case KindNumber:
+		return strconv.FormatFloat(value.Number, 'f', -1, 64)
+	case KindBool:
+		return strconv.FormatBool(value.Bool)
+	case KindTime:
+		return value.Time.Format(time.RFC3339)
+	default:
+		return strconv.Quote(value.Text)
+	}
+}
+
+func (expr And) String() string {
+	return "(" + expr.Left.String() + " and " + expr.Right.String() + ")"
+}
+
+func (expr Or) String() string {
+	return "(" + expr.Left.String() + " or " + expr.Right.String() + ")"
+}
+
+func (expr Not) String() string {
+	return "not " + expr.Expr.String()
+}
+
+func (expr Comparison) String() string {
+	switch expr.Op {
+	case OpIsNull, OpIsNotNull:
+		return expr.Field + " " + string(expr.Op)
+	case OpIn:
+		list := ""
+		for i, value := range expr.Values {
+			if i > 0 {
+				list += ", "
+			}
+			list += value.String()
+		}
+		return expr.Field + " in (" + list + ")"
+	default:
+		return fmt.Sprintf("%s %s %s", expr.Field, expr.Op, expr.Values[0])
+	}
+}
@@ -0,0 +1,348 @@
+package filterexpr
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// MaxLength is the longest expression accepted by Parse.
+const MaxLength = 2000
+
+// maxDepth limits nesting so hostile expressions cannot exhaust the stack.
+const maxDepth = 32
+
+// SyntaxError reports where an expression could not be parsed; Pos counts
+// characters from 1.
+type SyntaxError struct {
+	Pos int
+	Msg string
+}
+
+func (err *SyntaxError) Error() string {
+	return fmt.Sprintf("invalid filter at position %d: %s", err.Pos, err.Msg)
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenWord
+	tokenString
+	tokenOperator
+	tokenLParen
+	tokenRParen
+	tokenComma
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (tok token) describe() string {
+	switch tok.kind {
+	case tokenEOF:
+		return "end of filter"
+	case tokenString:
+		return strconv.Quote(tok.text)
+	default:
+		return "'" + tok.text + "'"
+	}
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("_.-:+", r)
+}
+
+func lex(input string) ([]token, error) {
+	runes := []rune(input)
+	tokens := []token{}
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		pos := i + 1
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, token{tokenLParen, "(", pos})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{tokenRParen, ")", pos})
+			i++
+		case r == ',':
+			tokens = append(tokens, token{tokenComma, ",", pos})
+			i++
+		case r == '=':
+			tokens = append(tokens, token{tokenOperator, "=", pos})
+			i++
+		case r == '!' || r == '<' || r == '>':
+			op := string(r)
+			if i+1 < len(runes) && runes[i+1] == '=' {
+				op += "="
+			}
+			if op == "!" {
+				return nil, &SyntaxError{pos, "expected '!='"}
+			}
+			tokens = append(tokens, token{tokenOperator, op, pos})
+			i += len(op)
+		case r == '"':
+			var text strings.Builder
+			i++
+			for {
+				if i >= len(runes) {
+					return nil, &SyntaxError{pos, "unterminated string"}
+				}
+				if runes[i] == '"' {
+					i++
+					break
+				}
+				if runes[i] == '\\' {
+					if i+1 >= len(runes) || (runes[i+1] != '"' && runes[i+1] != '\\') {
+						return nil, &SyntaxError{i + 1, `only \" and \\ can be escaped`}
+					}
+					i++
+				}
+				text.WriteRune(runes[i])
+				i++
+			}
+			tokens = append(tokens, token{tokenString, text.String(), pos})
+		case isWordRune(r):
+			start := i
+			for i < len(runes) && isWordRune(runes[i]) {
+				i++
+			}
+			tokens = append(tokens, token{tokenWord, string(runes[start:i]), pos})
+		default:
+			return nil, &SyntaxError{pos, fmt.Sprintf("unexpected character '%c'", r)}
+		}
+	}
+
+	return append(tokens, token{tokenEOF, "", len(runes) + 1}), nil
+}
+
+type parser struct {
+	tokens []token
+	next   int
+	depth  int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.next]
+}
+
+func (p *parser) take() token {
+	tok := p.tokens[p.next]
+	if tok.kind != tokenEOF {
+		p.next++
+	}
+	return tok
+}
+
+// keyword reports whether the next token is the given keyword, consuming it
+// when it is.
+func (p *parser) keyword(word string) bool {
+	tok := p.peek()
+	if tok.kind == tokenWord && strings.EqualFold(tok.text, word) {
+		p.next++
+		return true
+	}
+	return false
+}
+
+func (p *parser) fail(tok token, format string, args ...interface{}) error {
+	return &SyntaxError{tok.pos, fmt.Sprintf(format, args...)}
+}
+
+// Parse parses a filter expression.
+func Parse(input string) (Expr, error) {
+	if len(input) > MaxLength {
+		return nil, &SyntaxError{MaxLength + 1, fmt.Sprintf("filter is longer than %d characters", MaxLength)}
+	}
+
+	tokens, err := lex(input)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	if p.peek().kind == tokenEOF {
+		return nil, p.fail(p.peek(), "filter is empty")
+	}
+
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokenEOF {
+		return nil, p.fail(tok, "expected 'and', 'or' or end of filter, found %s", tok.describe())
+	}
+	return expr, nil
+}
+
+func (p *parser) parseOr() (Expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = Or{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (Expr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = And{left, right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (Expr, error) {
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > maxDepth {
+		return nil, p.fail(p.peek(), "filter is nested too deeply")
+	}
+
+	if p.keyword("not") {
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return Not{expr}, nil
+	}
+
+	if p.peek().kind == tokenLParen {
+		p.take()
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if tok := p.take(); tok.kind != tokenRParen {
+			return nil, p.fail(tok, "expected ')', found %s", tok.describe())
+		}
+		return expr, nil
+	}
+
+	return p.parseComparison()
+}
+
+var wordOperators = []Op{OpContains, OpStartsWith, OpEndsWith}
+
+func (p *parser) parseComparison() (Expr, error) {
+	field := p.take()
+	if field.kind != tokenWord || isKeyword(field.text) {
+		return nil, p.fail(field, "expected a field name, found %s", field.describe())
+	}
+	comparison := Comparison{Field: strings.ToLower(field.text), Pos: field.pos}
+
+	opToken := p.take()
+	switch {
+	case opToken.kind == tokenOperator:
+		comparison.Op = Op(opToken.text)
+	case opToken.kind == tokenWord && strings.EqualFold(opToken.text, "is"):
+		comparison.Op = OpIsNull
+		if p.keyword("not") {
+			comparison.Op = OpIsNotNull
+		}
+		if !p.keyword("null") {
+			return nil, p.fail(p.peek(), "expected 'null', found %s", p.peek().describe())
+		}
+		return comparison, nil
+	case opToken.kind == tokenWord && strings.EqualFold(opToken.text, "in"):
+		comparison.Op = OpIn
+		if tok := p.take(); tok.kind != tokenLParen {
+			return nil, p.fail(tok, "expected '(' after 'in', found %s", tok.describe())
+		}
+		for {
+			value, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			comparison.Values = append(comparison.Values, value)
+
+			tok := p.take()
+			if tok.kind == tokenRParen {
+				return comparison, nil
+			}
+			if tok.kind != tokenComma {
+				return nil, p.fail(tok, "expected ',' or ')', found %s", tok.describe())
+			}
+		}
+	case opToken.kind == tokenWord:
+		for _, op := range wordOperators {
+			if strings.EqualFold(opToken.text, string(op)) {
+				comparison.Op = op
+			}
+		}
+	}
+	if comparison.Op == "" {
+		return nil, p.fail(opToken, "expected an operator after '%s', found %s", field.text, opToken.describe())
+	}
+
+	value, err := p.parseValue()
+	if err != nil {
+		return nil, err
+	}
+	comparison.Values = []Value{value}
+	return comparison, nil
+}
+
+func (p *parser) parseValue() (Value, error) {
+	tok := p.take()
+	switch tok.kind {
+	case tokenString:
+		return String(tok.text), nil
+	case tokenWord:
+		if value, ok := parseLiteral(tok.text); ok {
+			return value, nil
+		}
+	}
+	return Value{}, p.fail(tok, "expected a value, found %s; quote text values", tok.describe())
+}
+
+// parseLiteral reads an unquoted number, boolean or date.
+func parseLiteral(text string) (Value, bool) {
+	switch strings.ToLower(text) {
+	case "true":
+		return Bool(true), true
+	case "false":
+		return Bool(false), true
+	}
+	if date, err := time.Parse(time.DateOnly, text); err == nil {
+		return Time(date), true
+	}
+	if moment, err := time.Parse(time.RFC3339, text); err == nil {
+		return Time(moment), true
+	}
+	if number, err := strconv.ParseFloat(text, 64); err == nil && !math.IsInf(number, 0) && !math.IsNaN(number) {
+		return Number(number), true
+	}
+	return Value{}, false
+}
+
+func isKeyword(word string) bool {
+	switch strings.ToLower(word) {
+	case "and", "or", "not", "in", "is", "null":
+		return true
+	}
+	return false
+}
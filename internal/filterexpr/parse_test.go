@@ -0,0 +1,111 @@
+package filterexpr
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{`name = "Ann"`, `name = "Ann"`},
+		{`Name CONTAINS "an"`, `name contains "an"`},
+		{`id >= 10`, `id >= 10`},
+		{`cf.vip = true`, `cf.vip = true`},
+		{`created_at < 2024-01-01`, `created_at < 2024-01-01T00:00:00Z`},
+		{`created_at > 2024-01-01T10:00:00+02:00`, `created_at > 2024-01-01T10:00:00+02:00`},
+		{`name = "say \"hi\" \\ bye"`, `name = "say \"hi\" \\ bye"`},
+
+		// and binds tighter than or, not tighter than both.
+		{`a = 1 or b = 2 and c = 3`, `(a = 1 or (b = 2 and c = 3))`},
+		{`a = 1 and b = 2 or c = 3`, `((a = 1 and b = 2) or c = 3)`},
+		{`a = 1 and b = 2 and c = 3`, `((a = 1 and b = 2) and c = 3)`},
+		{`not a = 1 and b = 2`, `(not a = 1 and b = 2)`},
+		{`not (a = 1 and b = 2)`, `not (a = 1 and b = 2)`},
+		{`not not a = 1`, `not not a = 1`},
+		{`(a = 1 or b = 2) and c = 3`, `((a = 1 or b = 2) and c = 3)`},
+		{`a = 1 OR b = 2 AND NOT c = 3`, `(a = 1 or (b = 2 and not c = 3))`},
+
+		{`category in ("Suppliers", "Partners")`, `category in ("Suppliers", "Partners")`},
+		{`id in (1)`, `id in (1)`},
+		{`email is null`, `email is null`},
+		{`email IS NOT NULL`, `email is not null`},
+		{`not email is null or phone is not null`, `(not email is null or phone is not null)`},
+	}
+
+	for _, test := range tests {
+		expr, err := Parse(test.input)
+		if err != nil {
+			t.Errorf("Parse(%q) failed: %v", test.input, err)
+			continue
+		}
+		if got := expr.String(); got != test.want {
+			t.Errorf("Parse(%q) = %s, want %s", test.input, got, test.want)
+		}
+	}
+}
+
+func TestParseComparison(t *testing.T) {
+	expr, err := Parse(`  city in ("Oslo", "Bergen") and email is not null`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	and, ok := expr.(And)
+	if !ok {
+		t.Fatalf("got %T, want And", expr)
+	}
+	in := and.Left.(Comparison)
+	if in.Op != OpIn || in.Pos != 3 || len(in.Values) != 2 || in.Values[1] != String("Bergen") {
+		t.Errorf("got %+v, want city in with 2 values at 3", in)
+	}
+	notNull := and.Right.(Comparison)
+	if notNull.Op != OpIsNotNull || notNull.Pos != 34 || len(notNull.Values) != 0 {
+		t.Errorf("got %+v, want email is not null at 34", notNull)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		pos   int
+		msg   string
+	}{
+		{``, 1, "filter is empty"},
+		{`   `, 4, "filter is empty"},
+		{`name`, 5, "expected an operator after 'name', found end of filter"},
+		{`name ~ "a"`, 6, "unexpected character '~'"},
+		{`name ! "a"`, 6, "expected '!='"},
+		{`name = "abc`, 8, "unterminated string"},
+		{`name = "a\nb"`, 10, `only \" and \\ can be escaped`},
+		{`name = Ann`, 8, "expected a value, found 'Ann'; quote text values"},
+		{`name = "a" name = "b"`, 12, "expected 'and', 'or' or end of filter, found 'name'"},
+		{`name = "a" and`, 15, "expected a field name, found end of filter"},
+		{`and = 1`, 1, "expected a field name, found 'and'"},
+		{`(name = "a"`, 12, "expected ')', found end of filter"},
+		{`name like "a"`, 6, "expected an operator after 'name', found 'like'"},
+		{`email is empty`, 10, "expected 'null', found 'empty'"},
+		{`email is not "a"`, 14, `expected 'null', found "a"`},
+		{`id in 1, 2`, 7, "expected '(' after 'in', found '1'"},
+		{`id in (1 2)`, 10, "expected ',' or ')', found '2'"},
+		{`id in ()`, 8, "expected a value, found ')'; quote text values"},
+		{`name = "ä" and é`, 17, "expected an operator after 'é', found end of filter"},
+		{strings.Repeat("(", 40) + `id = 1` + strings.Repeat(")", 40), 33, "filter is nested too deeply"},
+		{strings.Repeat(" ", MaxLength+1), MaxLength + 1, "filter is longer than 2000 characters"},
+	}
+
+	for _, test := range tests {
+		_, err := Parse(test.input)
+		var syntaxErr *SyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Errorf("Parse(%q) error = %v, want a SyntaxError", test.input, err)
+			continue
+		}
+		if syntaxErr.Pos != test.pos || syntaxErr.Msg != test.msg {
+			t.Errorf("Parse(%q) error = %d %q, want %d %q", test.input, syntaxErr.Pos, syntaxErr.Msg, test.pos, test.msg)
+		}
+	}
+}
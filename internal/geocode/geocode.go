@@ -0,0 +1,108 @@
+package geocode
+
+import (
+	_ "embed"
+	"encoding/csv"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// gazetteerCSV is the bundled list of known places, so geocoding needs no network.
+//
+//go:embed gazetteer.csv
+var gazetteerCSV string
+
+type Place struct {
+	City      string
+	Region    string
+	Country   string
+	Latitude  float64
+	Longitude float64
+
+	names     []string
+	countries []string
+}
+
+type Gazetteer struct {
+	places []Place
+}
+
+func NewGazetteer() (*Gazetteer, error) {
+	records, err := csv.NewReader(strings.NewReader(gazetteerCSV)).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("error reading gazetteer: %v", err)
+	}
+
+	gazetteer := &Gazetteer{}
+	for i, record := range records[1:] {
+		if len(record) != 7 {
+			return nil, fmt.Errorf("gazetteer line %d: expected 7 columns, got %d", i+2, len(record))
+		}
+
+		latitude, err := strconv.ParseFloat(record[5], 64)
+		if err != nil {
+			return nil, fmt.Errorf("gazetteer line %d: invalid latitude: %v", i+2, err)
+		}
+		longitude, err := strconv.ParseFloat(record[6], 64)
+		if err != nil {
+			return nil, fmt.Errorf("gazetteer line %d: invalid longitude: %v", i+2, err)
+		}
+
+		gazetteer.places = append(gazetteer.places, Place{
+			City:      record[0],
+			Region:    record[2],
+			Country:   record[3],
+			Latitude:  latitude,
+			Longitude: longitude,
+			names:     normalizeAll(record[0], record[1]),
+			countries: normalizeAll(record[3], record[4]),
+		})
+	}
+
+	return gazetteer, nil
+}
+
+func normalize(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+func normalizeAll(name, aliases string) []string {
+	names := []string{normalize(name)}
+	for _, alias := range strings.Split(aliases, "|") {
+		if alias != "" {
+			names = append(names, normalize(alias))
+		}
+	}
+	return names
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Lookup finds a place by city name or alias. When a country is given, only
+// cities in that country match, so a namesake elsewhere is never returned.
+func (gazetteer *Gazetteer) Lookup(city, country string) (Place, bool) {
+	city = normalize(city)
+	country = normalize(country)
+	if city == "" {
+		return Place{}, false
+	}
+
+	for _, place := range gazetteer.places {
+		if !contains(place.names, city) {
+			continue
+		}
+		if country == "" || contains(place.countries, country) {
+			return place, true
+		}
+	}
+
+	return Place{}, false
+}
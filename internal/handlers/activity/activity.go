@@ -0,0 +1,293 @@
+package activity
+
+import (
+	"database/sql"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/utah1280/backend-internship-2024/internal/storage"
+)
+
+type ActivityHandler struct {
+	Storage *storage.ActivityStorage
+}
+
+func NewActivityHandler(storage *storage.ActivityStorage) *ActivityHandler {
+	return &ActivityHandler{Storage: storage}
+}
+
+type basicResponse struct {
+	Success bool `json:"success"`
+}
+
+type activityRequest struct {
+	Type       string    `json:"type" enums:"note,call,meeting,email"`
+	OccurredAt time.Time `json:"occurred_at"`
+	Author     string    `json:"author"`
+	Body       string    `json:"body"`
+}
+
+type activityResponse struct {
+	Id int `json:"id"`
+}
+
+// AddActivity swagger
+// @Summary Log an activity with a contact
+// @Description Add a note, call, meeting or email to the contact history, occurred_at defaults to now
+// @Tags Activities
+// @Accept json
+// @Produce json
+// @Param id path int true "Contact ID"
+// @Param body body activityRequest true "Activity details"
+// @Success 200 {object} activityResponse
+// @Failure 400 {string} string "Bad Request"
+// @Failure 404 {string} string "Not Found"
+// @Router /contacts/add-contact-activity/{id} [post]
+func (handler *ActivityHandler) AddActivity(ctx *fiber.Ctx) error {
+	contactId, err := strconv.Atoi(ctx.Params("id"))
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid contact ID")
+	}
+
+	var body activityRequest
+	if err := ctx.BodyParser(&body); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid request body")
+	}
+
+	id, err := handler.Storage.AddActivity(contactId, storage.NewActivityInput{
+		Type:       body.Type,
+		OccurredAt: body.OccurredAt,
+		Author:     body.Author,
+		Body:       body.Body,
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ctx.Status(fiber.StatusNotFound).SendString("Contact not found")
+		}
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	resp := activityResponse{Id: id}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+type activityListResponse struct {
+	Activities []storage.Activity `json:"activities"`
+}
+
+// GetActivities swagger
+// @Summary Get activities of a contact
+// @Description Retrieve the activities of a contact, newest first
+// @Tags Activities
+// @Accept json
+// @Produce json
+// @Param id path int true "Contact ID"
+// @Param type query string false "Only activities of this type"
+// @Param limit query int false "Limit results per page"
+// @Param offset query int false "Offset results for pagination"
+// @Success 200 {object} activityListResponse
+// @Failure 400 {string} string "Bad Request"
+// @Router /contacts/get-contact-activities/{id} [get]
+func (handler *ActivityHandler) GetActivities(ctx *fiber.Ctx) error {
+	contactId, err := strconv.Atoi(ctx.Params("id"))
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid contact ID")
+	}
+
+	limit, err := strconv.Atoi(ctx.Query("limit", "20"))
+	if err != nil {
+		limit = 20
+	}
+
+	offset, err := strconv.Atoi(ctx.Query("offset", "0"))
+	if err != nil {
+		offset = 0
+	}
+
+	activities, err := handler.Storage.GetActivities(contactId, limit, offset, ctx.Query("type", ""))
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	resp := activityListResponse{
+		Activities: activities,
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+type fetchActivityResponse struct {
+	Activity storage.Activity `json:"activity"`
+}
+
+// GetActivity swagger
+// @Summary Get an activity of a contact
+// @Description Retrieve a single activity of a contact
+// @Tags Activities
+// @Accept json
+// @Produce json
+// @Param id path int true "Contact ID"
+// @Param activityId path int true "Activity ID"
+// @Success 200 {object} fetchActivityResponse
+// @Failure 400 {string} string "Bad Request"
+// @Failure 404 {string} string "Not Found"
+// @Router /contacts/get-contact-activity/{id}/{activityId} [get]
+func (handler *ActivityHandler) GetActivity(ctx *fiber.Ctx) error {
+	contactId, err := strconv.Atoi(ctx.Params("id"))
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid contact ID")
+	}
+
+	activityId, err := strconv.Atoi(ctx.Params("activityId"))
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid activity ID")
+	}
+
+	activity, err := handler.Storage.GetActivity(contactId, activityId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ctx.Status(fiber.StatusNotFound).SendString("Activity not found")
+		}
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	resp := fetchActivityResponse{
+		Activity: activity,
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+type updateActivityRequest struct {
+	Type       *string    `json:"type" enums:"note,call,meeting,email"`
+	OccurredAt *time.Time `json:"occurred_at"`
+	Author     *string    `json:"author"`
+	Body       *string    `json:"body"`
+}
+
+// UpdateActivity swagger
+// @Summary Update an activity of a contact
+// @Description Update activity details, omitted fields are left unchanged
+// @Tags Activities
+// @Accept json
+// @Produce json
+// @Param id path int true "Contact ID"
+// @Param activityId path int true "Activity ID"
+// @Param body body updateActivityRequest true "Activity details"
+// @Success 200 {object} basicResponse
+// @Failure 400 {string} string "Bad Request"
+// @Failure 404 {string} string "Not Found"
+// @Router /contacts/update-contact-activity/{id}/{activityId} [patch]
+func (handler *ActivityHandler) UpdateActivity(ctx *fiber.Ctx) error {
+	contactId, err := strconv.Atoi(ctx.Params("id"))
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid contact ID")
+	}
+
+	activityId, err := strconv.Atoi(ctx.Params("activityId"))
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid activity ID")
+	}
+
+	var body updateActivityRequest
+	if err := ctx.BodyParser(&body); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid request body")
+	}
+
+	err = handler.Storage.UpdateActivity(contactId, activityId, storage.UpdateActivityInput{
+		Type:       body.Type,
+		OccurredAt: body.OccurredAt,
+		Author:     body.Author,
+		Body:       body.Body,
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ctx.Status(fiber.StatusNotFound).SendString("Activity not found")
+		}
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	resp := basicResponse{Success: true}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+// DeleteActivity swagger
+// @Summary Delete an activity of a contact
+// @Description Delete activity with the given id
+// @Tags Activities
+// @Accept json
+// @Produce json
+// @Param id path int true "Contact ID"
+// @Param activityId path int true "Activity ID"
+// @Success 200 {object} basicResponse
+// @Failure 400 {string} string "Bad Request"
+// @Failure 404 {string} string "Not Found"
+// @Router /contacts/delete-contact-activity/{id}/{activityId} [delete]
+func (handler *ActivityHandler) DeleteActivity(ctx *fiber.Ctx) error {
+	contactId, err := strconv.Atoi(ctx.Params("id"))
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid contact ID")
+	}
+
+	activityId, err := strconv.Atoi(ctx.Params("activityId"))
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid activity ID")
+	}
+
+	err = handler.Storage.DeleteActivity(contactId, activityId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ctx.Status(fiber.StatusNotFound).SendString("Activity not found")
+		}
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	resp := basicResponse{Success: true}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+type timelineResponse struct {
+	Timeline []storage.TimelineEntry `json:"timeline"`
+}
+
+// GetTimeline swagger
+// @Summary Get the timeline of a contact
+// @Description Retrieve activities and contact events merged into one chronological list, newest first
+// @Tags Activities
+// @Accept json
+// @Produce json
+// @Param id path int true "Contact ID"
+// @Param limit query int false "Limit results per page"
+// @Param offset query int false "Offset results for pagination"
+// @Success 200 {object} timelineResponse
+// @Failure 400 {string} string "Bad Request"
+// @Failure 404 {string} string "Not Found"
+// @Router /contacts/get-contact-timeline/{id} [get]
+func (handler *ActivityHandler) GetTimeline(ctx *fiber.Ctx) error {
+	contactId, err := strconv.Atoi(ctx.Params("id"))
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid contact ID")
+	}
+
+	limit, err := strconv.Atoi(ctx.Query("limit", "20"))
+	if err != nil {
+		limit = 20
+	}
+
+	offset, err := strconv.Atoi(ctx.Query("offset", "0"))
+	if err != nil {
+		offset = 0
+	}
+
+	timeline, err := handler.Storage.GetTimeline(contactId, limit, offset)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ctx.Status(fiber.StatusNotFound).SendString("Contact not found")
+		}
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	resp := timelineResponse{
+		Timeline: timeline,
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
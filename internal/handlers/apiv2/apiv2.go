@@ -0,0 +1,113 @@
+// Package apiv2 serves the resource oriented /api/v2 routes. Every response
+// body is an envelope: {"data": ...} with a "meta" member for lists, or
+// {"error": {"status": ..., "message": ...}} when the request failed.
+package apiv2
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/utah1280/backend-internship-2024/internal/storage"
+)
+
+// Prefix is the path all v2 routes are mounted under.
+const Prefix = "/api/v2"
+
+const (
+	defaultLimit = 10
+	maxLimit     = 100
+)
+
+var (
+	// deprecatedAt is when the routes replaced by v2 were deprecated.
+	deprecatedAt = time.Date(2026, time.November, 1, 0, 0, 0, 0, time.UTC)
+	// sunsetAt is when the routes replaced by v2 will be removed.
+	sunsetAt = time.Date(2027, time.May, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// HeaderUser names the user a request is made for. There are no accounts, so
+// it is trusted as given, like the author of an activity.
+const HeaderUser = "X-User-Id"
+
+type APIHandler struct {
+	Contacts   *storage.ContactStorage
+	Categories *storage.CategoryStorage
+	Searches   *storage.SavedSearchStorage
+}
+
+func NewAPIHandler(contacts *storage.ContactStorage, categories *storage.CategoryStorage, searches *storage.SavedSearchStorage) *APIHandler {
+	return &APIHandler{Contacts: contacts, Categories: categories, Searches: searches}
+}
+
+type apiError struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+type errorResponse struct {
+	Error apiError `json:"error"`
+}
+
+// pageMeta describes a page of a list; Total counts every matching item.
+type pageMeta struct {
+	Limit  int `json:"limit"`
+	Offset int `json:"offset"`
+	Total  int `json:"total"`
+}
+
+func sendError(ctx *fiber.Ctx, status int, message string) error {
+	return ctx.Status(status).JSON(errorResponse{Error: apiError{Status: status, Message: message}})
+}
+
+// idParam parses a numeric path parameter.
+func idParam(ctx *fiber.Ctx, name string) (int, error) {
+	id, err := strconv.Atoi(ctx.Params(name))
+	if err != nil || id <= 0 {
+		return 0, fmt.Errorf("invalid %s '%s'", name, ctx.Params(name))
+	}
+	return id, nil
+}
+
+// page parses the limit and offset query parameters.
+func page(ctx *fiber.Ctx) (pageMeta, error) {
+	meta := pageMeta{Limit: defaultLimit}
+
+	if value := ctx.Query("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxLimit {
+			return meta, fmt.Errorf("invalid limit '%s', expected 1 to %d", value, maxLimit)
+		}
+		meta.Limit = limit
+	}
+
+	if value := ctx.Query("offset"); value != "" {
+		offset, err := strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			return meta, fmt.Errorf("invalid offset '%s'", value)
+		}
+		meta.Offset = offset
+	}
+
+	return meta, nil
+}
+
+// Deprecated marks a route as replaced by successor, a v2 path whose
+// parameters are filled from the request. Responses carry Deprecation and
+// Sunset headers (RFC 9745, RFC 8594) and a link to the successor.
+func Deprecated(successor string) fiber.Handler {
+	return func(ctx *fiber.Ctx) error {
+		link := successor
+		for _, name := range ctx.Route().Params {
+			link = strings.ReplaceAll(link, ":"+name, ctx.Params(name))
+		}
+
+		ctx.Set("Deprecation", "@"+strconv.FormatInt(deprecatedAt.Unix(), 10))
+		ctx.Set("Sunset", sunsetAt.Format(http.TimeFormat))
+		ctx.Append(fiber.HeaderLink, "<"+Prefix+link+`>; rel="successor-version"`)
+		return ctx.Next()
+	}
+}
@@ -0,0 +1,261 @@
+package apiv2
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/utah1280/backend-internship-2024/internal/storage"
+)
+
+type categoryRequest struct {
+	Label       string `json:"label"`
+	Description string `json:"description"`
+	Color       string `json:"color"`
+	Icon        string `json:"icon"`
+}
+
+// categoryPatchRequest holds the category fields to change, omitted fields
+// are left unchanged.
+type categoryPatchRequest struct {
+	Label       *string `json:"label"`
+	Description *string `json:"description"`
+	Color       *string `json:"color"`
+	Icon        *string `json:"icon"`
+	Archived    *bool   `json:"archived"`
+}
+
+type categoryResponse struct {
+	Data storage.Category `json:"data"`
+}
+
+type categoryListResponse struct {
+	Data []storage.Category `json:"data"`
+}
+
+// sendCategory answers with the current state of a category.
+func (handler *APIHandler) sendCategory(ctx *fiber.Ctx, status, id int) error {
+	category, err := handler.Categories.GetCategory(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return sendError(ctx, fiber.StatusNotFound, "Category not found")
+		}
+		return sendError(ctx, fiber.StatusInternalServerError, err.Error())
+	}
+	return ctx.Status(status).JSON(categoryResponse{Data: category})
+}
+
+// ListCategories swagger
+// @Summary List categories
+// @Description Retrieve the categories ordered by position, archived categories are hidden unless requested
+// @Tags Categories v2
+// @Produce json
+// @Param archived query bool false "Include archived categories"
+// @Success 200 {object} categoryListResponse
+// @Failure 500 {object} errorResponse
+// @Router /api/v2/categories [get]
+func (handler *APIHandler) ListCategories(ctx *fiber.Ctx) error {
+	categories, err := handler.Categories.GetCategoryList(ctx.QueryBool("archived", false))
+	if err != nil {
+		return sendError(ctx, fiber.StatusInternalServerError, err.Error())
+	}
+
+	if categories == nil {
+		categories = []storage.Category{}
+	}
+	return ctx.Status(fiber.StatusOK).JSON(categoryListResponse{Data: categories})
+}
+
+// CreateCategory swagger
+// @Summary Create a category
+// @Description Create a category, answering with the stored category and its URL in Location
+// @Tags Categories v2
+// @Accept json
+// @Produce json
+// @Param body body categoryRequest true "Category details"
+// @Success 201 {object} categoryResponse
+// @Failure 400 {object} errorResponse
+// @Failure 409 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /api/v2/categories [post]
+func (handler *APIHandler) CreateCategory(ctx *fiber.Ctx) error {
+	var body categoryRequest
+	if err := ctx.BodyParser(&body); err != nil {
+		return sendError(ctx, fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	if body.Color != "" && !storage.ColorPattern.MatchString(body.Color) {
+		return sendError(ctx, fiber.StatusBadRequest, "Invalid color, expected #RRGGBB")
+	}
+
+	id, err := handler.Categories.AddCategory(storage.NewCategoryInput{
+		Label:       body.Label,
+		Description: body.Description,
+		Color:       body.Color,
+		Icon:        body.Icon,
+	})
+	if err != nil {
+		if errors.Is(err, storage.ErrInvalidCategory) {
+			return sendError(ctx, fiber.StatusBadRequest, err.Error())
+		}
+		if errors.Is(err, storage.ErrCategoryExists) {
+			return sendError(ctx, fiber.StatusConflict, err.Error())
+		}
+		return sendError(ctx, fiber.StatusInternalServerError, err.Error())
+	}
+
+	ctx.Location(Prefix + "/categories/" + strconv.Itoa(id))
+	return handler.sendCategory(ctx, fiber.StatusCreated, id)
+}
+
+// GetCategory swagger
+// @Summary Get a category
+// @Tags Categories v2
+// @Produce json
+// @Param id path int true "Category ID"
+// @Success 200 {object} categoryResponse
+// @Failure 400 {object} errorResponse
+// @Failure 404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /api/v2/categories/{id} [get]
+func (handler *APIHandler) GetCategory(ctx *fiber.Ctx) error {
+	id, err := idParam(ctx, "id")
+	if err != nil {
+		return sendError(ctx, fiber.StatusBadRequest, err.Error())
+	}
+
+	return handler.sendCategory(ctx, fiber.StatusOK, id)
+}
+
+// UpdateCategory swagger
+// @Summary Update a category
+// @Description Change the label, description, color, icon or archived flag of a category, omitted fields are left unchanged
+// @Tags Categories v2
+// @Accept json
+// @Produce json
+// @Param id path int true "Category ID"
+// @Param body body categoryPatchRequest true "Category changes"
+// @Success 200 {object} categoryResponse
+// @Failure 400 {object} errorResponse
+// @Failure 404 {object} errorResponse
+// @Failure 409 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /api/v2/categories/{id} [patch]
+func (handler *APIHandler) UpdateCategory(ctx *fiber.Ctx) error {
+	id, err := idParam(ctx, "id")
+	if err != nil {
+		return sendError(ctx, fiber.StatusBadRequest, err.Error())
+	}
+
+	var body categoryPatchRequest
+	if err := ctx.BodyParser(&body); err != nil {
+		return sendError(ctx, fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	if body.Color != nil && *body.Color != "" && !storage.ColorPattern.MatchString(*body.Color) {
+		return sendError(ctx, fiber.StatusBadRequest, "Invalid color, expected #RRGGBB")
+	}
+
+	if _, err := handler.Categories.GetCategory(id); err != nil {
+		if err == sql.ErrNoRows {
+			return sendError(ctx, fiber.StatusNotFound, "Category not found")
+		}
+		return sendError(ctx, fiber.StatusInternalServerError, err.Error())
+	}
+
+	if body.Label != nil {
+		if err := handler.Categories.UpdateCategoryLabel(id, *body.Label); err != nil {
+			if err == sql.ErrNoRows {
+				return sendError(ctx, fiber.StatusNotFound, "Category not found")
+			}
+			if errors.Is(err, storage.ErrInvalidCategory) {
+				return sendError(ctx, fiber.StatusBadRequest, err.Error())
+			}
+			if errors.Is(err, storage.ErrCategoryExists) {
+				return sendError(ctx, fiber.StatusConflict, err.Error())
+			}
+			return sendError(ctx, fiber.StatusInternalServerError, err.Error())
+		}
+	}
+
+	if body.Description != nil || body.Color != nil || body.Icon != nil || body.Archived != nil {
+		err := handler.Categories.UpdateCategoryMetadata(id, storage.UpdateCategoryMetadataInput{
+			Description: body.Description,
+			Color:       body.Color,
+			Icon:        body.Icon,
+			Archived:    body.Archived,
+		})
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return sendError(ctx, fiber.StatusNotFound, "Category not found")
+			}
+			return sendError(ctx, fiber.StatusInternalServerError, err.Error())
+		}
+	}
+
+	return handler.sendCategory(ctx, fiber.StatusOK, id)
+}
+
+// DeleteCategory swagger
+// @Summary Delete a category
+// @Tags Categories v2
+// @Produce json
+// @Param id path int true "Category ID"
+// @Success 204
+// @Failure 400 {object} errorResponse
+// @Failure 404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /api/v2/categories/{id} [delete]
+func (handler *APIHandler) DeleteCategory(ctx *fiber.Ctx) error {
+	id, err := idParam(ctx, "id")
+	if err != nil {
+		return sendError(ctx, fiber.StatusBadRequest, err.Error())
+	}
+
+	if _, err := handler.Categories.GetCategory(id); err != nil {
+		if err == sql.ErrNoRows {
+			return sendError(ctx, fiber.StatusNotFound, "Category not found")
+		}
+		return sendError(ctx, fiber.StatusInternalServerError, err.Error())
+	}
+
+	if err := handler.Categories.DeleteCategory(id); err != nil {
+		return sendError(ctx, fiber.StatusInternalServerError, err.Error())
+	}
+
+	return ctx.SendStatus(fiber.StatusNoContent)
+}
+
+// ListCategoryContacts swagger
+// @Summary List the contacts of a category
+// @Tags Categories v2
+// @Produce json
+// @Param id path int true "Category ID"
+// @Param limit query int false "Page size, 1 to 100 (10 default)"
+// @Param offset query int false "Number of contacts to skip"
+// @Param sort_by query string false "Sort field: created_at, last_contacted_at or name (default)"
+// @Param sort_dir query string false "Sort direction, ASC (default) or DESC"
+// @Param fields query string false "Comma separated contact fields to return, e.g. id,name,phone; all fields by default"
+// @Param include query string false "Related objects to embed: category replaces the category label with the category"
+// @Success 200 {object} contactListResponse
+// @Failure 400 {object} errorResponse
+// @Failure 404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /api/v2/categories/{id}/contacts [get]
+func (handler *APIHandler) ListCategoryContacts(ctx *fiber.Ctx) error {
+	id, err := idParam(ctx, "id")
+	if err != nil {
+		return sendError(ctx, fiber.StatusBadRequest, err.Error())
+	}
+
+	if _, err := handler.Categories.GetCategory(id); err != nil {
+		if err == sql.ErrNoRows {
+			return sendError(ctx, fiber.StatusNotFound, "Category not found")
+		}
+		return sendError(ctx, fiber.StatusInternalServerError, err.Error())
+	}
+
+	filter := storage.ContactFilter{CategoryId: id}
+	return handler.listContacts(ctx, filter, ctx.Query("sort_by", "name"), ctx.Query("sort_dir", "ASC"))
+}
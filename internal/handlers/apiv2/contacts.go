@@ -0,0 +1,252 @@
+package apiv2
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/utah1280/backend-internship-2024/internal/handlers/contactpatch"
+	"github.com/utah1280/backend-internship-2024/internal/storage"
+)
+
+type contactRequest struct {
+	Name           string                       `json:"name"`
+	Phone          string                       `json:"phone"`
+	Email          string                       `json:"email"`
+	Address        string                       `json:"address"`
+	Category       string                       `json:"category"`
+	CustomFields   map[string]interface{}       `json:"custom_fields"`
+	Phones         []storage.ContactMethodInput `json:"phones"`
+	Emails         []storage.ContactMethodInput `json:"emails"`
+	Addresses      []storage.ContactMethodInput `json:"addresses"`
+	OrganizationId int                          `json:"organization_id"`
+	JobTitle       string                       `json:"job_title"`
+}
+
+type contactResponse struct {
+	Data storage.Contact_ `json:"data"`
+}
+
+type contactListResponse struct {
+	Data []storage.Contact_ `json:"data"`
+	Meta pageMeta           `json:"meta"`
+}
+
+// contactViewListResponse holds contacts trimmed to the requested fields.
+type contactViewListResponse struct {
+	Data []storage.ContactView `json:"data"`
+	Meta pageMeta              `json:"meta"`
+}
+
+// listContacts sends a page of the contacts matching filter, trimmed to the
+// fields and include query parameters.
+func (handler *APIHandler) listContacts(ctx *fiber.Ctx, filter storage.ContactFilter, sortBy, sortDir string) error {
+	meta, err := page(ctx)
+	if err != nil {
+		return sendError(ctx, fiber.StatusBadRequest, err.Error())
+	}
+
+	selection, err := storage.NewContactSelection(ctx.Query("fields"), ctx.Query("include"))
+	if err != nil {
+		return sendError(ctx, fiber.StatusBadRequest, err.Error())
+	}
+
+	meta.Total, err = handler.Contacts.CountContacts(filter)
+	if err != nil {
+		return sendError(ctx, fiber.StatusInternalServerError, err.Error())
+	}
+
+	if !selection.IsZero() {
+		contacts, err := handler.Contacts.GetSelectedContacts(meta.Limit, meta.Offset, filter, sortBy, sortDir, selection)
+		if err != nil {
+			return sendError(ctx, fiber.StatusInternalServerError, err.Error())
+		}
+		return ctx.Status(fiber.StatusOK).JSON(contactViewListResponse{Data: contacts, Meta: meta})
+	}
+
+	contacts, err := handler.Contacts.GetContacts(meta.Limit, meta.Offset, filter, sortBy, sortDir)
+	if err != nil {
+		return sendError(ctx, fiber.StatusInternalServerError, err.Error())
+	}
+
+	if contacts == nil {
+		contacts = []storage.Contact_{}
+	}
+	return ctx.Status(fiber.StatusOK).JSON(contactListResponse{Data: contacts, Meta: meta})
+}
+
+// ListContacts swagger
+// @Summary List contacts
+// @Description Retrieve a page of contacts with optional filtering and sorting. With near, results are ordered by distance
+// @Tags Contacts v2
+// @Produce json
+// @Param limit query int false "Page size, 1 to 100 (10 default)"
+// @Param offset query int false "Number of contacts to skip"
+// @Param name query string false "Filter by contact name"
+// @Param phone query string false "Filter by any of the contact phones"
+// @Param email query string false "Filter by any of the contact emails"
+// @Param category query string false "Filter by category label"
+// @Param organization query string false "Filter by organization name"
+// @Param city query string false "Filter by address city"
+// @Param region query string false "Filter by address region"
+// @Param country query string false "Filter by address country"
+// @Param sort_by query string false "Sort field: created_at (default), last_contacted_at or name"
+// @Param sort_dir query string false "Sort direction, ASC (default) or DESC"
+// @Param near query string false "Only contacts near this point, as lat,lng"
+// @Param radius query number false "Search radius around near in km (5 default)"
+// @Param cf.key query string false "Filter by custom field value, e.g. cf.tax_id=123"
+// @Param filter query string false "Filter expression, e.g. created_at > 2024-01-01 and not (city = ...); text values are double quoted"
+// @Param fields query string false "Comma separated contact fields to return, e.g. id,name,phone; all fields by default"
+// @Param include query string false "Related objects to embed: category replaces the category label with the category"
+// @Success 200 {object} contactListResponse
+// @Failure 400 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /api/v2/contacts [get]
+func (handler *APIHandler) ListContacts(ctx *fiber.Ctx) error {
+	filter, err := storage.NewContactFilter(ctx.Queries())
+	if err != nil {
+		return sendError(ctx, fiber.StatusBadRequest, err.Error())
+	}
+
+	return handler.listContacts(ctx, filter, ctx.Query("sort_by", "created_at"), ctx.Query("sort_dir", "ASC"))
+}
+
+// CreateContact swagger
+// @Summary Create a contact
+// @Description Create a contact, answering with the stored contact and its URL in Location
+// @Tags Contacts v2
+// @Accept json
+// @Produce json
+// @Param body body contactRequest true "Contact details"
+// @Success 201 {object} contactResponse
+// @Failure 400 {object} errorResponse
+// @Failure 409 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /api/v2/contacts [post]
+func (handler *APIHandler) CreateContact(ctx *fiber.Ctx) error {
+	var body contactRequest
+	if err := ctx.BodyParser(&body); err != nil {
+		return sendError(ctx, fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	id, err := handler.Contacts.CreateContact(storage.NewContactInput{
+		Name:           body.Name,
+		Phone:          body.Phone,
+		Email:          body.Email,
+		Address:        body.Address,
+		Label:          body.Category,
+		CustomFields:   body.CustomFields,
+		Phones:         body.Phones,
+		Emails:         body.Emails,
+		Addresses:      body.Addresses,
+		OrganizationId: body.OrganizationId,
+		JobTitle:       body.JobTitle,
+	})
+	if err != nil {
+		if errors.Is(err, storage.ErrEmailExists) {
+			return sendError(ctx, fiber.StatusConflict, err.Error())
+		}
+		if errors.Is(err, storage.ErrInvalidContact) {
+			return sendError(ctx, fiber.StatusBadRequest, err.Error())
+		}
+		return sendError(ctx, fiber.StatusInternalServerError, err.Error())
+	}
+
+	contact, err := handler.Contacts.GetContact(id)
+	if err != nil {
+		return sendError(ctx, fiber.StatusInternalServerError, err.Error())
+	}
+
+	ctx.Location(Prefix + "/contacts/" + strconv.Itoa(id))
+	return ctx.Status(fiber.StatusCreated).JSON(contactResponse{Data: contact})
+}
+
+// GetContact swagger
+// @Summary Get a contact
+// @Tags Contacts v2
+// @Produce json
+// @Param id path int true "Contact ID"
+// @Success 200 {object} contactResponse
+// @Failure 400 {object} errorResponse
+// @Failure 404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /api/v2/contacts/{id} [get]
+func (handler *APIHandler) GetContact(ctx *fiber.Ctx) error {
+	id, err := idParam(ctx, "id")
+	if err != nil {
+		return sendError(ctx, fiber.StatusBadRequest, err.Error())
+	}
+
+	contact, err := handler.Contacts.GetContact(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return sendError(ctx, fiber.StatusNotFound, "Contact not found")
+		}
+		return sendError(ctx, fiber.StatusInternalServerError, err.Error())
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(contactResponse{Data: contact})
+}
+
+// UpdateContact swagger
+// @Summary Update a contact
+// @Description Apply a JSON Merge Patch (RFC 7396, sent as application/merge-patch+json or application/json) or a JSON Patch (RFC 6902, sent as application/json-patch+json) to the contact document shown in the body schema.
+// @Description Members left out of a merge patch are not changed, while null or empty values clear the field; name and category cannot be cleared.
+// @Tags Contacts v2
+// @Accept json
+// @Accept application/merge-patch+json
+// @Accept application/json-patch+json
+// @Produce json
+// @Param id path int true "Contact ID"
+// @Param body body storage.ContactDocument true "Merge patch, or a JSON Patch array of operations"
+// @Success 200 {object} contactResponse
+// @Failure 400 {object} errorResponse
+// @Failure 404 {object} errorResponse
+// @Failure 409 {object} errorResponse
+// @Failure 415 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /api/v2/contacts/{id} [patch]
+func (handler *APIHandler) UpdateContact(ctx *fiber.Ctx) error {
+	id, err := idParam(ctx, "id")
+	if err != nil {
+		return sendError(ctx, fiber.StatusBadRequest, err.Error())
+	}
+
+	if status, msg := contactpatch.Apply(ctx, handler.Contacts, id); status != fiber.StatusOK {
+		return sendError(ctx, status, msg)
+	}
+
+	contact, err := handler.Contacts.GetContact(id)
+	if err != nil {
+		return sendError(ctx, fiber.StatusInternalServerError, err.Error())
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(contactResponse{Data: contact})
+}
+
+// DeleteContact swagger
+// @Summary Delete a contact
+// @Tags Contacts v2
+// @Produce json
+// @Param id path int true "Contact ID"
+// @Success 204
+// @Failure 400 {object} errorResponse
+// @Failure 404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /api/v2/contacts/{id} [delete]
+func (handler *APIHandler) DeleteContact(ctx *fiber.Ctx) error {
+	id, err := idParam(ctx, "id")
+	if err != nil {
+		return sendError(ctx, fiber.StatusBadRequest, err.Error())
+	}
+
+	if err := handler.Contacts.DeleteContact(id); err != nil {
+		if err == sql.ErrNoRows {
+			return sendError(ctx, fiber.StatusNotFound, "Contact not found")
+		}
+		return sendError(ctx, fiber.StatusInternalServerError, err.Error())
+	}
+
+	return ctx.SendStatus(fiber.StatusNoContent)
+}
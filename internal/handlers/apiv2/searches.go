@@ -0,0 +1,343 @@
+package apiv2
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/utah1280/backend-internship-2024/internal/storage"
+)
+
+type savedSearchRequest struct {
+	Name    string            `json:"name"`
+	Shared  bool              `json:"shared"`
+	Query   map[string]string `json:"query"`
+	SortBy  string            `json:"sort_by"`
+	SortDir string            `json:"sort_dir"`
+}
+
+// savedSearchPatchRequest holds the saved search fields to change, omitted
+// fields are left unchanged. A query replaces the stored one as a whole.
+type savedSearchPatchRequest struct {
+	Name    *string            `json:"name"`
+	Shared  *bool              `json:"shared"`
+	Query   *map[string]string `json:"query"`
+	SortBy  *string            `json:"sort_by"`
+	SortDir *string            `json:"sort_dir"`
+}
+
+type savedSearchResponse struct {
+	Data storage.SavedSearch `json:"data"`
+}
+
+type savedSearchListResponse struct {
+	Data []storage.SavedSearch `json:"data"`
+}
+
+type savedSearchCountsResponse struct {
+	Data []storage.SavedSearchCount `json:"data"`
+}
+
+// requestUser reads the user a request is made for from HeaderUser.
+func requestUser(ctx *fiber.Ctx) (string, error) {
+	user := strings.TrimSpace(ctx.Get(HeaderUser))
+	if user == "" {
+		return "", errors.New("missing " + HeaderUser + " header")
+	}
+	return user, nil
+}
+
+// sendSavedSearchError answers with the status matching a saved search
+// storage error.
+func sendSavedSearchError(ctx *fiber.Ctx, err error) error {
+	if err == sql.ErrNoRows {
+		return sendError(ctx, fiber.StatusNotFound, "Saved search not found")
+	}
+	if errors.Is(err, storage.ErrNotSearchOwner) {
+		return sendError(ctx, fiber.StatusForbidden, err.Error())
+	}
+	if errors.Is(err, storage.ErrSavedSearchExists) {
+		return sendError(ctx, fiber.StatusConflict, err.Error())
+	}
+	if errors.Is(err, storage.ErrInvalidSavedSearch) {
+		return sendError(ctx, fiber.StatusBadRequest, err.Error())
+	}
+	return sendError(ctx, fiber.StatusInternalServerError, err.Error())
+}
+
+// sendSavedSearch answers with the current state of a saved search.
+func (handler *APIHandler) sendSavedSearch(ctx *fiber.Ctx, status, id int, user string) error {
+	search, err := handler.Searches.GetSavedSearch(id, user)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return sendError(ctx, fiber.StatusNotFound, "Saved search not found")
+		}
+		return sendError(ctx, fiber.StatusInternalServerError, err.Error())
+	}
+	return ctx.Status(status).JSON(savedSearchResponse{Data: search})
+}
+
+// ListSavedSearches swagger
+// @Summary List saved searches
+// @Description Retrieve the saved searches of the user followed by the searches shared by others
+// @Tags Saved searches v2
+// @Produce json
+// @Param X-User-Id header string true "User the request is made for"
+// @Success 200 {object} savedSearchListResponse
+// @Failure 400 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /api/v2/saved-searches [get]
+func (handler *APIHandler) ListSavedSearches(ctx *fiber.Ctx) error {
+	user, err := requestUser(ctx)
+	if err != nil {
+		return sendError(ctx, fiber.StatusBadRequest, err.Error())
+	}
+
+	searches, err := handler.Searches.GetSavedSearches(user)
+	if err != nil {
+		return sendError(ctx, fiber.StatusInternalServerError, err.Error())
+	}
+
+	if searches == nil {
+		searches = []storage.SavedSearch{}
+	}
+	return ctx.Status(fiber.StatusOK).JSON(savedSearchListResponse{Data: searches})
+}
+
+// CreateSavedSearch swagger
+// @Summary Create a saved search
+// @Description Save a named contact query for the user. The query holds the filter parameters of GET /api/v2/contacts, e.g. category, city, cf.key or filter; sort_by and sort_dir default to created_at and ASC
+// @Tags Saved searches v2
+// @Accept json
+// @Produce json
+// @Param X-User-Id header string true "User the request is made for"
+// @Param body body savedSearchRequest true "Saved search details"
+// @Success 201 {object} savedSearchResponse
+// @Failure 400 {object} errorResponse
+// @Failure 409 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /api/v2/saved-searches [post]
+func (handler *APIHandler) CreateSavedSearch(ctx *fiber.Ctx) error {
+	user, err := requestUser(ctx)
+	if err != nil {
+		return sendError(ctx, fiber.StatusBadRequest, err.Error())
+	}
+
+	var body savedSearchRequest
+	if err := ctx.BodyParser(&body); err != nil {
+		return sendError(ctx, fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	id, err := handler.Searches.AddSavedSearch(user, storage.NewSavedSearchInput{
+		Name:    body.Name,
+		Shared:  body.Shared,
+		Query:   body.Query,
+		SortBy:  body.SortBy,
+		SortDir: body.SortDir,
+	})
+	if err != nil {
+		return sendSavedSearchError(ctx, err)
+	}
+
+	ctx.Location(Prefix + "/saved-searches/" + strconv.Itoa(id))
+	return handler.sendSavedSearch(ctx, fiber.StatusCreated, id, user)
+}
+
+// GetSavedSearch swagger
+// @Summary Get a saved search
+// @Description Retrieve a saved search of the user or one shared by others
+// @Tags Saved searches v2
+// @Produce json
+// @Param X-User-Id header string true "User the request is made for"
+// @Param id path int true "Saved search ID"
+// @Success 200 {object} savedSearchResponse
+// @Failure 400 {object} errorResponse
+// @Failure 404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /api/v2/saved-searches/{id} [get]
+func (handler *APIHandler) GetSavedSearch(ctx *fiber.Ctx) error {
+	user, err := requestUser(ctx)
+	if err != nil {
+		return sendError(ctx, fiber.StatusBadRequest, err.Error())
+	}
+
+	id, err := idParam(ctx, "id")
+	if err != nil {
+		return sendError(ctx, fiber.StatusBadRequest, err.Error())
+	}
+
+	return handler.sendSavedSearch(ctx, fiber.StatusOK, id, user)
+}
+
+// UpdateSavedSearch swagger
+// @Summary Update a saved search
+// @Description Change the name, sharing, query or sort of a saved search of the user, omitted fields are left unchanged
+// @Tags Saved searches v2
+// @Accept json
+// @Produce json
+// @Param X-User-Id header string true "User the request is made for"
+// @Param id path int true "Saved search ID"
+// @Param body body savedSearchPatchRequest true "Saved search changes"
+// @Success 200 {object} savedSearchResponse
+// @Failure 400 {object} errorResponse
+// @Failure 403 {object} errorResponse
+// @Failure 404 {object} errorResponse
+// @Failure 409 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /api/v2/saved-searches/{id} [patch]
+func (handler *APIHandler) UpdateSavedSearch(ctx *fiber.Ctx) error {
+	user, err := requestUser(ctx)
+	if err != nil {
+		return sendError(ctx, fiber.StatusBadRequest, err.Error())
+	}
+
+	id, err := idParam(ctx, "id")
+	if err != nil {
+		return sendError(ctx, fiber.StatusBadRequest, err.Error())
+	}
+
+	var body savedSearchPatchRequest
+	if err := ctx.BodyParser(&body); err != nil {
+		return sendError(ctx, fiber.StatusBadRequest, "Invalid request body")
+	}
+
+	input := storage.UpdateSavedSearchInput{
+		Name:    body.Name,
+		Shared:  body.Shared,
+		SortBy:  body.SortBy,
+		SortDir: body.SortDir,
+	}
+	if body.Query != nil {
+		query := storage.SearchQuery(*body.Query)
+		input.Query = &query
+	}
+
+	if err := handler.Searches.UpdateSavedSearch(id, user, input); err != nil {
+		return sendSavedSearchError(ctx, err)
+	}
+
+	return handler.sendSavedSearch(ctx, fiber.StatusOK, id, user)
+}
+
+// DeleteSavedSearch swagger
+// @Summary Delete a saved search
+// @Tags Saved searches v2
+// @Produce json
+// @Param X-User-Id header string true "User the request is made for"
+// @Param id path int true "Saved search ID"
+// @Success 204
+// @Failure 400 {object} errorResponse
+// @Failure 403 {object} errorResponse
+// @Failure 404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /api/v2/saved-searches/{id} [delete]
+func (handler *APIHandler) DeleteSavedSearch(ctx *fiber.Ctx) error {
+	user, err := requestUser(ctx)
+	if err != nil {
+		return sendError(ctx, fiber.StatusBadRequest, err.Error())
+	}
+
+	id, err := idParam(ctx, "id")
+	if err != nil {
+		return sendError(ctx, fiber.StatusBadRequest, err.Error())
+	}
+
+	if err := handler.Searches.DeleteSavedSearch(id, user); err != nil {
+		if err == sql.ErrNoRows {
+			return sendError(ctx, fiber.StatusNotFound, "Saved search not found")
+		}
+		if errors.Is(err, storage.ErrNotSearchOwner) {
+			return sendError(ctx, fiber.StatusForbidden, err.Error())
+		}
+		return sendError(ctx, fiber.StatusInternalServerError, err.Error())
+	}
+
+	return ctx.SendStatus(fiber.StatusNoContent)
+}
+
+// RunSavedSearch swagger
+// @Summary Run a saved search
+// @Description Retrieve a page of the contacts currently matching a saved search, in its sort order
+// @Tags Saved searches v2
+// @Produce json
+// @Param X-User-Id header string true "User the request is made for"
+// @Param id path int true "Saved search ID"
+// @Param limit query int false "Page size, 1 to 100 (10 default)"
+// @Param offset query int false "Number of contacts to skip"
+// @Param fields query string false "Comma separated contact fields to return, e.g. id,name,phone; all fields by default"
+// @Param include query string false "Related objects to embed: category replaces the category label with the category"
+// @Success 200 {object} contactListResponse
+// @Failure 400 {object} errorResponse
+// @Failure 404 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /api/v2/saved-searches/{id}/contacts [get]
+func (handler *APIHandler) RunSavedSearch(ctx *fiber.Ctx) error {
+	user, err := requestUser(ctx)
+	if err != nil {
+		return sendError(ctx, fiber.StatusBadRequest, err.Error())
+	}
+
+	id, err := idParam(ctx, "id")
+	if err != nil {
+		return sendError(ctx, fiber.StatusBadRequest, err.Error())
+	}
+
+	search, err := handler.Searches.GetSavedSearch(id, user)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return sendError(ctx, fiber.StatusNotFound, "Saved search not found")
+		}
+		return sendError(ctx, fiber.StatusInternalServerError, err.Error())
+	}
+
+	filter, err := search.Filter()
+	if err != nil {
+		return sendError(ctx, fiber.StatusInternalServerError, err.Error())
+	}
+
+	return handler.listContacts(ctx, filter, search.SortBy, search.SortDir)
+}
+
+// CountSavedSearches swagger
+// @Summary Count the contacts of saved searches
+// @Description Count the contacts currently matching each saved search visible to the user, in the order of the saved search list
+// @Tags Saved searches v2
+// @Produce json
+// @Param X-User-Id header string true "User the request is made for"
+// @Success 200 {object} savedSearchCountsResponse
+// @Failure 400 {object} errorResponse
+// @Failure 500 {object} errorResponse
+// @Router /api/v2/saved-searches/counts [get]
+func (handler *APIHandler) CountSavedSearches(ctx *fiber.Ctx) error {
+	user, err := requestUser(ctx)
+	if err != nil {
+		return sendError(ctx, fiber.StatusBadRequest, err.Error())
+	}
+
+	searches, err := handler.Searches.GetSavedSearches(user)
+	if err != nil {
+		return sendError(ctx, fiber.StatusInternalServerError, err.Error())
+	}
+
+	filters := []storage.ContactFilter{}
+	for _, search := range searches {
+		filter, err := search.Filter()
+		if err != nil {
+			return sendError(ctx, fiber.StatusInternalServerError, err.Error())
+		}
+		filters = append(filters, filter)
+	}
+
+	counts, err := handler.Contacts.CountContactsByFilters(filters)
+	if err != nil {
+		return sendError(ctx, fiber.StatusInternalServerError, err.Error())
+	}
+
+	result := []storage.SavedSearchCount{}
+	for i, search := range searches {
+		result = append(result, storage.SavedSearchCount{Id: search.Id, Name: search.Name, Count: counts[i]})
+	}
+	return ctx.Status(fiber.StatusOK).JSON(savedSearchCountsResponse{Data: result})
+}
@@ -0,0 +1,607 @@
+// Package carddav serves the contact book over CardDAV (RFC 6352) with
+// incremental sync (RFC 6578). The whole book and every category are
+// exposed as address books of a single anonymous principal:
+//
+//	/carddav/                             principal
+//	/carddav/addressbooks/                address book home
+//	/carddav/addressbooks/all/            every contact
+//	/carddav/addressbooks/{categoryId}/   contacts of a category
+//	/carddav/addressbooks/{book}/{name}   a contact as a vCard
+package carddav
+
+import (
+	"database/sql"
+	"encoding/xml"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/utah1280/backend-internship-2024/internal/storage"
+	"github.com/utah1280/backend-internship-2024/internal/vcard"
+)
+
+// Methods are the request methods CardDAV needs on top of the HTTP ones.
+var Methods = []string{"PROPFIND", "REPORT"}
+
+const (
+	principalPath = "/carddav/"
+	homePath      = "/carddav/addressbooks/"
+	// allBook is the path segment of the address book with every contact.
+	allBook = "all"
+	// syncTokenPrefix turns sync tokens into the URIs RFC 6578 expects. It
+	// names the token scheme, so that tokens of an earlier one are rejected
+	// and clients start over with a full sync.
+	syncTokenPrefix = "urn:contacts:sync:xid:"
+)
+
+type CardDAVHandler struct {
+	Contacts   *storage.ContactStorage
+	Categories *storage.CategoryStorage
+}
+
+func NewCardDAVHandler(contacts *storage.ContactStorage, categories *storage.CategoryStorage) *CardDAVHandler {
+	return &CardDAVHandler{Contacts: contacts, Categories: categories}
+}
+
+// addressBook is the whole contact book or a category; CategoryId is zero
+// for the whole book.
+type addressBook struct {
+	Segment     string
+	CategoryId  int
+	Name        string
+	Description string
+}
+
+func (book addressBook) path() string {
+	return homePath + book.Segment + "/"
+}
+
+func (book addressBook) cardPath(name string) string {
+	return book.path() + url.PathEscape(name)
+}
+
+// cardName returns the resource name an href points to, empty when it is
+// not a card of the address book.
+func (book addressBook) cardName(href string) string {
+	target, err := url.Parse(strings.TrimSpace(href))
+	if err != nil || !strings.HasPrefix(target.Path, book.path()) {
+		return ""
+	}
+	name := strings.TrimPrefix(target.Path, book.path())
+	if strings.Contains(name, "/") {
+		return ""
+	}
+	return name
+}
+
+func (handler *CardDAVHandler) addressBook(segment string) (addressBook, error) {
+	if segment == allBook {
+		return addressBook{Segment: allBook, Name: "All contacts", Description: "Every contact of the contact book"}, nil
+	}
+
+	categoryId, err := strconv.Atoi(segment)
+	if err != nil {
+		return addressBook{}, sql.ErrNoRows
+	}
+	category, err := handler.Categories.GetCategory(categoryId)
+	if err != nil {
+		return addressBook{}, err
+	}
+	return addressBook{Segment: segment, CategoryId: category.Id, Name: category.Label, Description: category.Description}, nil
+}
+
+// addressBooks lists the whole book followed by the active categories.
+func (handler *CardDAVHandler) addressBooks() ([]addressBook, error) {
+	categories, err := handler.Categories.GetCategoryList(false)
+	if err != nil {
+		return nil, err
+	}
+
+	books := []addressBook{{Segment: allBook, Name: "All contacts", Description: "Every contact of the contact book"}}
+	for _, category := range categories {
+		books = append(books, addressBook{
+			Segment:     strconv.Itoa(category.Id),
+			CategoryId:  category.Id,
+			Name:        category.Label,
+			Description: category.Description,
+		})
+	}
+	return books, nil
+}
+
+func syncToken(token int64) string {
+	return syncTokenPrefix + strconv.FormatInt(token, 10)
+}
+
+// parseSyncToken returns the value of a sync token, zero for an initial sync.
+func parseSyncToken(token string) (int64, bool) {
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return 0, true
+	}
+	if !strings.HasPrefix(token, syncTokenPrefix) {
+		return 0, false
+	}
+	value, err := strconv.ParseInt(strings.TrimPrefix(token, syncTokenPrefix), 10, 64)
+	return value, err == nil && value >= 0
+}
+
+// cardVersion returns the vCard version asked for, 3.0 unless 4.0 is requested.
+func cardVersion(requested string) string {
+	if strings.Contains(requested, vcard.Version4) {
+		return vcard.Version4
+	}
+	return vcard.Version3
+}
+
+func encodeCard(resource storage.DavResource, version string) (string, error) {
+	uid := resource.UID
+	if uid == "" {
+		uid = vcard.UID(resource.Id)
+	}
+
+	var card strings.Builder
+	if err := vcard.EncodeWithUID(&card, resource.Contact_, uid, version); err != nil {
+		return "", err
+	}
+	return card.String(), nil
+}
+
+// depth returns 0 or 1 for the Depth header; infinity is served as 1.
+func depth(ctx *fiber.Ctx) int {
+	if ctx.Get("Depth") == "0" {
+		return 0
+	}
+	return 1
+}
+
+func currentUserPrincipal() prop {
+	return davProp("current-user-principal", hrefValue(principalPath))
+}
+
+func principalProps() props {
+	return props{
+		davProp("resourcetype", "<D:collection/><D:principal/>"),
+		davProp("displayname", "Contacts"),
+		currentUserPrincipal(),
+		davProp("principal-URL", hrefValue(principalPath)),
+		cardDAVProp("addressbook-home-set", hrefValue(homePath)),
+	}
+}
+
+func homeProps() props {
+	return props{
+		davProp("resourcetype", "<D:collection/>"),
+		davProp("displayname", "Address books"),
+		currentUserPrincipal(),
+	}
+}
+
+func bookProps(book addressBook, token int64) props {
+	return props{
+		davProp("resourcetype", "<D:collection/><C:addressbook/>"),
+		davProp("displayname", escapeText(book.Name)),
+		cardDAVProp("addressbook-description", escapeText(book.Description)),
+		currentUserPrincipal(),
+		davProp("current-user-privilege-set", "<D:privilege><D:read/></D:privilege><D:privilege><D:write/></D:privilege>"+
+			"<D:privilege><D:write-content/></D:privilege><D:privilege><D:bind/></D:privilege><D:privilege><D:unbind/></D:privilege>"),
+		davProp("supported-report-set", "<D:supported-report><D:report><C:addressbook-query/></D:report></D:supported-report>"+
+			"<D:supported-report><D:report><C:addressbook-multiget/></D:report></D:supported-report>"+
+			"<D:supported-report><D:report><D:sync-collection/></D:report></D:supported-report>"),
+		cardDAVProp("supported-address-data", `<C:address-data-type content-type="text/vcard" version="3.0"/>`+
+			`<C:address-data-type content-type="text/vcard" version="4.0"/>`),
+		davProp("sync-token", escapeText(syncToken(token))),
+		{name: xml.Name{Space: nsCalendarServer, Local: "getctag"}, value: escapeText(syncToken(token))},
+	}
+}
+
+// cardProps returns the properties of a contact; address-data is only
+// encoded when asked for.
+func cardProps(resource storage.DavResource, request *propRequest) (props, error) {
+	list := props{
+		davProp("resourcetype", ""),
+		davProp("getetag", escapeText(resource.ETag())),
+		davProp("getcontenttype", escapeText(vcard.ContentType)),
+	}
+
+	if request != nil {
+		for _, name := range request.Names {
+			if name != addressDataName {
+				continue
+			}
+			card, err := encodeCard(resource, cardVersion(request.AddressDataVersion))
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, prop{name: addressDataName, value: escapeText(card), hidden: true})
+		}
+	}
+	return list, nil
+}
+
+// cardResponses builds the responses for a list of contacts.
+func cardResponses(book addressBook, resources []storage.DavResource, request *propRequest, names bool) ([]response, error) {
+	responses := []response{}
+	for _, resource := range resources {
+		list, err := cardProps(resource, request)
+		if err != nil {
+			return nil, err
+		}
+		responses = append(responses, list.response(book.cardPath(resource.Name), request, names))
+	}
+	return responses, nil
+}
+
+// Options advertises CardDAV support.
+func (handler *CardDAVHandler) Options(ctx *fiber.Ctx) error {
+	ctx.Set("DAV", "1, 3, addressbook, sync-collection")
+	ctx.Set(fiber.HeaderAllow, "OPTIONS, GET, HEAD, PUT, DELETE, PROPFIND, REPORT")
+	return ctx.SendStatus(fiber.StatusOK)
+}
+
+// WellKnown redirects service discovery (RFC 6764) to the principal.
+func (handler *CardDAVHandler) WellKnown(ctx *fiber.Ctx) error {
+	return ctx.Redirect(principalPath, fiber.StatusMovedPermanently)
+}
+
+// PropfindPrincipal describes the principal and its address book home.
+func (handler *CardDAVHandler) PropfindPrincipal(ctx *fiber.Ctx) error {
+	request, err := parsePropfind(ctx.Body())
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid PROPFIND body")
+	}
+
+	resp := principalProps().response(principalPath, request.Prop, request.PropName != nil)
+	return sendMultistatus(ctx, []response{resp}, "")
+}
+
+// PropfindHome describes the address book home and, with Depth 1, its
+// address books.
+func (handler *CardDAVHandler) PropfindHome(ctx *fiber.Ctx) error {
+	request, err := parsePropfind(ctx.Body())
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid PROPFIND body")
+	}
+	names := request.PropName != nil
+
+	responses := []response{homeProps().response(homePath, request.Prop, names)}
+	if depth(ctx) > 0 {
+		books, err := handler.addressBooks()
+		if err != nil {
+			return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+		}
+		for _, book := range books {
+			token, err := handler.Contacts.GetDavToken(book.CategoryId)
+			if err != nil {
+				return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+			}
+			responses = append(responses, bookProps(book, token).response(book.path(), request.Prop, names))
+		}
+	}
+
+	return sendMultistatus(ctx, responses, "")
+}
+
+// PropfindBook describes an address book and, with Depth 1, its contacts.
+func (handler *CardDAVHandler) PropfindBook(ctx *fiber.Ctx) error {
+	book, err := handler.addressBook(ctx.Params("book"))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ctx.Status(fiber.StatusNotFound).SendString("Address book not found")
+		}
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	request, err := parsePropfind(ctx.Body())
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid PROPFIND body")
+	}
+	names := request.PropName != nil
+
+	token, err := handler.Contacts.GetDavToken(book.CategoryId)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+	responses := []response{bookProps(book, token).response(book.path(), request.Prop, names)}
+
+	if depth(ctx) > 0 {
+		resources, err := handler.Contacts.GetDavResources(book.CategoryId, nil)
+		if err != nil {
+			return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+		}
+		cards, err := cardResponses(book, resources, request.Prop, names)
+		if err != nil {
+			return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+		}
+		responses = append(responses, cards...)
+	}
+
+	return sendMultistatus(ctx, responses, "")
+}
+
+// PropfindCard describes a single contact.
+func (handler *CardDAVHandler) PropfindCard(ctx *fiber.Ctx) error {
+	book, resource, err := handler.resource(ctx)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ctx.Status(fiber.StatusNotFound).SendString("Contact not found")
+		}
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	request, err := parsePropfind(ctx.Body())
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid PROPFIND body")
+	}
+
+	responses, err := cardResponses(book, []storage.DavResource{resource}, request.Prop, request.PropName != nil)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+	return sendMultistatus(ctx, responses, "")
+}
+
+// Report answers addressbook-multiget, addressbook-query and sync-collection
+// reports on an address book.
+func (handler *CardDAVHandler) Report(ctx *fiber.Ctx) error {
+	book, err := handler.addressBook(ctx.Params("book"))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ctx.Status(fiber.StatusNotFound).SendString("Address book not found")
+		}
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	var request reportRequest
+	if err := xml.Unmarshal(ctx.Body(), &request); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid REPORT body")
+	}
+
+	switch request.XMLName {
+	case xml.Name{Space: nsCardDAV, Local: "addressbook-multiget"}:
+		return handler.multiget(ctx, book, request)
+	case xml.Name{Space: nsCardDAV, Local: "addressbook-query"}:
+		return handler.query(ctx, book, request)
+	case xml.Name{Space: nsDAV, Local: "sync-collection"}:
+		return handler.syncCollection(ctx, book, request)
+	}
+	return sendError(ctx, fiber.StatusForbidden, xml.Name{Space: nsDAV, Local: "supported-report"})
+}
+
+// multiget returns the contacts with the given hrefs; hrefs outside the
+// address book or without a contact are reported as not found.
+func (handler *CardDAVHandler) multiget(ctx *fiber.Ctx, book addressBook, request reportRequest) error {
+	names := []string{}
+	for _, href := range request.Hrefs {
+		if name := book.cardName(href); name != "" {
+			names = append(names, name)
+		}
+	}
+
+	resources, err := handler.Contacts.GetDavResources(book.CategoryId, names)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+	responses, err := cardResponses(book, resources, request.Prop, false)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	found := map[string]bool{}
+	for _, resource := range resources {
+		found[resource.Name] = true
+	}
+	for _, href := range request.Hrefs {
+		if name := book.cardName(href); name == "" || !found[name] {
+			responses = append(responses, response{Href: href, Status: status(fiber.StatusNotFound)})
+		}
+	}
+
+	return sendMultistatus(ctx, responses, "")
+}
+
+// query returns the contacts matching a filter, truncated to the requested
+// number of results.
+func (handler *CardDAVHandler) query(ctx *fiber.Ctx, book addressBook, request reportRequest) error {
+	resources, err := handler.Contacts.GetDavResources(book.CategoryId, nil)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	matching := []storage.DavResource{}
+	for _, resource := range resources {
+		text, err := encodeCard(resource, vcard.Version4)
+		if err != nil {
+			return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+		}
+		cards, err := vcard.Decode(strings.NewReader(text))
+		if err != nil || len(cards) != 1 {
+			return ctx.Status(fiber.StatusInternalServerError).SendString("Error encoding contact")
+		}
+
+		ok, err := request.Filter.matches(cards[0])
+		if err == errUnsupportedCollation {
+			return sendError(ctx, fiber.StatusForbidden, xml.Name{Space: nsCardDAV, Local: "supported-collation"})
+		}
+		if ok {
+			matching = append(matching, resource)
+		}
+	}
+
+	truncated := false
+	if request.Limit != nil && request.Limit.NResults > 0 && len(matching) > request.Limit.NResults {
+		matching = matching[:request.Limit.NResults]
+		truncated = true
+	}
+
+	responses, err := cardResponses(book, matching, request.Prop, false)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+	if truncated {
+		responses = append(responses, response{Href: book.path(), Status: status(fiber.StatusInsufficientStorage)})
+	}
+
+	return sendMultistatus(ctx, responses, "")
+}
+
+// syncCollection returns the contacts changed since the sync token and the
+// hrefs of those deleted or moved out of the address book.
+func (handler *CardDAVHandler) syncCollection(ctx *fiber.Ctx, book addressBook, request reportRequest) error {
+	since, ok := parseSyncToken(request.SyncToken)
+	if !ok {
+		return sendError(ctx, fiber.StatusForbidden, xml.Name{Space: nsDAV, Local: "valid-sync-token"})
+	}
+
+	changes, err := handler.Contacts.GetDavChanges(book.CategoryId, since)
+	if err != nil {
+		if err == storage.ErrInvalidSyncToken {
+			return sendError(ctx, fiber.StatusForbidden, xml.Name{Space: nsDAV, Local: "valid-sync-token"})
+		}
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	responses, err := cardResponses(book, changes.Changed, request.Prop, false)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+	for _, name := range changes.Deleted {
+		responses = append(responses, response{Href: book.cardPath(name), Status: status(fiber.StatusNotFound)})
+	}
+
+	return sendMultistatus(ctx, responses, syncToken(changes.Token))
+}
+
+// resource returns the address book and contact a card URL points to.
+func (handler *CardDAVHandler) resource(ctx *fiber.Ctx) (addressBook, storage.DavResource, error) {
+	book, err := handler.addressBook(ctx.Params("book"))
+	if err != nil {
+		return book, storage.DavResource{}, err
+	}
+
+	name, err := url.PathUnescape(ctx.Params("name"))
+	if err != nil {
+		return book, storage.DavResource{}, sql.ErrNoRows
+	}
+	resources, err := handler.Contacts.GetDavResources(book.CategoryId, []string{name})
+	if err != nil {
+		return book, storage.DavResource{}, err
+	}
+	if len(resources) == 0 {
+		return book, storage.DavResource{}, sql.ErrNoRows
+	}
+	return book, resources[0], nil
+}
+
+// GetCard returns a contact as a vCard, 4.0 when the Accept header asks for
+// it and 3.0 otherwise.
+func (handler *CardDAVHandler) GetCard(ctx *fiber.Ctx) error {
+	_, resource, err := handler.resource(ctx)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ctx.Status(fiber.StatusNotFound).SendString("Contact not found")
+		}
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	ctx.Set(fiber.HeaderETag, resource.ETag())
+	if ctx.Get(fiber.HeaderIfNoneMatch) == resource.ETag() {
+		return ctx.SendStatus(fiber.StatusNotModified)
+	}
+
+	card, err := encodeCard(resource, cardVersion(ctx.Get(fiber.HeaderAccept)))
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	ctx.Set(fiber.HeaderContentType, vcard.ContentType)
+	return ctx.Status(fiber.StatusOK).SendString(card)
+}
+
+// PutCard creates or replaces a contact from a vCard. The stored card is
+// normalized, so no ETag is returned and clients fetch it again.
+func (handler *CardDAVHandler) PutCard(ctx *fiber.Ctx) error {
+	book, err := handler.addressBook(ctx.Params("book"))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ctx.Status(fiber.StatusNotFound).SendString("Address book not found")
+		}
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	name, err := url.PathUnescape(ctx.Params("name"))
+	if err != nil || name == "" {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid resource name")
+	}
+
+	cards, err := vcard.Decode(strings.NewReader(string(ctx.Body())))
+	if err != nil {
+		return sendError(ctx, fiber.StatusForbidden, xml.Name{Space: nsCardDAV, Local: "valid-address-data"})
+	}
+	if len(cards) != 1 {
+		return sendError(ctx, fiber.StatusForbidden, xml.Name{Space: nsCardDAV, Local: "valid-address-data"})
+	}
+
+	created, err := handler.Contacts.PutDavResource(storage.DavWrite{
+		CategoryId: book.CategoryId,
+		Name:       name,
+		UID:        cards[0].UID(),
+		Record:     vcard.ToImportRecord(cards[0]),
+		Precondition: storage.DavPrecondition{
+			IfMatch:     ctx.Get(fiber.HeaderIfMatch),
+			IfNoneMatch: ctx.Get(fiber.HeaderIfNoneMatch),
+		},
+	})
+	if err != nil {
+		if err == storage.ErrPreconditionFailed {
+			return ctx.Status(fiber.StatusPreconditionFailed).SendString("Precondition failed")
+		}
+		if errors.Is(err, storage.ErrInvalidContact) {
+			return sendError(ctx, fiber.StatusForbidden, xml.Name{Space: nsCardDAV, Local: "valid-address-data"})
+		}
+		if errors.Is(err, storage.ErrEmailExists) || errors.Is(err, storage.ErrDavConflict) {
+			return ctx.Status(fiber.StatusConflict).SendString(err.Error())
+		}
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	if created {
+		ctx.Set(fiber.HeaderLocation, book.cardPath(name))
+		return ctx.SendStatus(fiber.StatusCreated)
+	}
+	return ctx.SendStatus(fiber.StatusNoContent)
+}
+
+// DeleteCard deletes a contact.
+func (handler *CardDAVHandler) DeleteCard(ctx *fiber.Ctx) error {
+	book, err := handler.addressBook(ctx.Params("book"))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ctx.Status(fiber.StatusNotFound).SendString("Address book not found")
+		}
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	name, err := url.PathUnescape(ctx.Params("name"))
+	if err != nil {
+		return ctx.Status(fiber.StatusNotFound).SendString("Contact not found")
+	}
+
+	err = handler.Contacts.DeleteDavResource(book.CategoryId, name, storage.DavPrecondition{
+		IfMatch:     ctx.Get(fiber.HeaderIfMatch),
+		IfNoneMatch: ctx.Get(fiber.HeaderIfNoneMatch),
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ctx.Status(fiber.StatusNotFound).SendString("Contact not found")
+		}
+		if err == storage.ErrPreconditionFailed {
+			return ctx.Status(fiber.StatusPreconditionFailed).SendString("Precondition failed")
+		}
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	return ctx.SendStatus(fiber.StatusNoContent)
+}
@@ -0,0 +1,142 @@
+package carddav
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/utah1280/backend-internship-2024/internal/vcard"
+)
+
+// errUnsupportedCollation is returned for text matches using a collation
+// other than i;unicode-casemap, i;ascii-casemap and i;octet.
+var errUnsupportedCollation = errors.New("unsupported collation")
+
+// textMatch compares property or parameter values (RFC 6352 10.5.4).
+type textMatch struct {
+	Collation       string `xml:"collation,attr"`
+	NegateCondition string `xml:"negate-condition,attr"`
+	MatchType       string `xml:"match-type,attr"`
+	Text            string `xml:",chardata"`
+}
+
+type paramFilter struct {
+	Name         string     `xml:"name,attr"`
+	IsNotDefined *struct{}  `xml:"urn:ietf:params:xml:ns:carddav is-not-defined"`
+	TextMatch    *textMatch `xml:"urn:ietf:params:xml:ns:carddav text-match"`
+}
+
+type propFilter struct {
+	Name         string        `xml:"name,attr"`
+	Test         string        `xml:"test,attr"`
+	IsNotDefined *struct{}     `xml:"urn:ietf:params:xml:ns:carddav is-not-defined"`
+	TextMatches  []textMatch   `xml:"urn:ietf:params:xml:ns:carddav text-match"`
+	ParamFilters []paramFilter `xml:"urn:ietf:params:xml:ns:carddav param-filter"`
+}
+
+// queryFilter is the filter of an addressbook-query report. Prop filters are
+// combined with anyof unless test is allof.
+type queryFilter struct {
+	Test        string       `xml:"test,attr"`
+	PropFilters []propFilter `xml:"urn:ietf:params:xml:ns:carddav prop-filter"`
+}
+
+// combine applies an anyof or allof test to the results of checks, stopping
+// at the first error.
+func combine(test string, count int, check func(int) (bool, error)) (bool, error) {
+	all := test == "allof"
+	for i := 0; i < count; i++ {
+		ok, err := check(i)
+		if err != nil {
+			return false, err
+		}
+		if ok != all {
+			return ok, nil
+		}
+	}
+	return all || count == 0, nil
+}
+
+func (match textMatch) matches(value string) (bool, error) {
+	text := match.Text
+	switch match.Collation {
+	case "", "i;unicode-casemap":
+		text, value = strings.ToLower(text), strings.ToLower(value)
+	case "i;ascii-casemap":
+		lower := func(r rune) rune {
+			if r >= 'A' && r <= 'Z' {
+				return r + 'a' - 'A'
+			}
+			return r
+		}
+		text, value = strings.Map(lower, text), strings.Map(lower, value)
+	case "i;octet":
+	default:
+		return false, errUnsupportedCollation
+	}
+
+	var ok bool
+	switch match.MatchType {
+	case "equals":
+		ok = value == text
+	case "starts-with":
+		ok = strings.HasPrefix(value, text)
+	case "ends-with":
+		ok = strings.HasSuffix(value, text)
+	default:
+		ok = strings.Contains(value, text)
+	}
+	return ok != (match.NegateCondition == "yes"), nil
+}
+
+func (filter paramFilter) matches(property vcard.Property) (bool, error) {
+	values, defined := property.Params[strings.ToUpper(filter.Name)]
+	if filter.IsNotDefined != nil {
+		return !defined, nil
+	}
+	if !defined {
+		return false, nil
+	}
+	if filter.TextMatch == nil {
+		return true, nil
+	}
+	for _, value := range values {
+		ok, err := filter.TextMatch.matches(value)
+		if err != nil || ok {
+			return ok, err
+		}
+	}
+	return false, nil
+}
+
+// matches reports whether a card has a property satisfying the filter.
+func (filter propFilter) matches(card vcard.Card) (bool, error) {
+	properties := card.Get(strings.ToUpper(filter.Name))
+	if filter.IsNotDefined != nil {
+		return len(properties) == 0, nil
+	}
+
+	for _, property := range properties {
+		count := len(filter.TextMatches) + len(filter.ParamFilters)
+		ok, err := combine(filter.Test, count, func(i int) (bool, error) {
+			if i < len(filter.TextMatches) {
+				return filter.TextMatches[i].matches(property.Text())
+			}
+			return filter.ParamFilters[i-len(filter.TextMatches)].matches(property)
+		})
+		if err != nil || ok {
+			return ok, err
+		}
+	}
+	return false, nil
+}
+
+// matches reports whether a card satisfies the filter; an empty filter
+// matches every card.
+func (filter *queryFilter) matches(card vcard.Card) (bool, error) {
+	if filter == nil || len(filter.PropFilters) == 0 {
+		return true, nil
+	}
+	return combine(filter.Test, len(filter.PropFilters), func(i int) (bool, error) {
+		return filter.PropFilters[i].matches(card)
+	})
+}
@@ -0,0 +1,246 @@
+package carddav
+
+import (
+	"encoding/xml"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+)
+
+const (
+	nsDAV            = "DAV:"
+	nsCardDAV        = "urn:ietf:params:xml:ns:carddav"
+	nsCalendarServer = "http://calendarserver.org/ns/"
+)
+
+// prefixes are the namespace prefixes declared on every multistatus.
+var prefixes = map[string]string{
+	nsDAV:            "D",
+	nsCardDAV:        "C",
+	nsCalendarServer: "CS",
+}
+
+var addressDataName = xml.Name{Space: nsCardDAV, Local: "address-data"}
+
+// propRequest is the prop element of a PROPFIND or REPORT request.
+type propRequest struct {
+	Names []xml.Name
+	// AddressDataVersion is the vCard version asked for with address-data.
+	AddressDataVersion string
+}
+
+func (request *propRequest) UnmarshalXML(decoder *xml.Decoder, start xml.StartElement) error {
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		switch element := token.(type) {
+		case xml.StartElement:
+			request.Names = append(request.Names, element.Name)
+			if element.Name == addressDataName {
+				for _, attr := range element.Attr {
+					if attr.Name.Local == "version" {
+						request.AddressDataVersion = attr.Value
+					}
+				}
+			}
+			if err := decoder.Skip(); err != nil {
+				return err
+			}
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+type propfindRequest struct {
+	XMLName  xml.Name     `xml:"DAV: propfind"`
+	AllProp  *struct{}    `xml:"DAV: allprop"`
+	PropName *struct{}    `xml:"DAV: propname"`
+	Prop     *propRequest `xml:"DAV: prop"`
+}
+
+// parsePropfind reads a PROPFIND body, an empty one asking for all properties.
+func parsePropfind(body []byte) (propfindRequest, error) {
+	var request propfindRequest
+	if len(strings.TrimSpace(string(body))) == 0 {
+		return request, nil
+	}
+	err := xml.Unmarshal(body, &request)
+	return request, err
+}
+
+type reportLimit struct {
+	NResults int `xml:"urn:ietf:params:xml:ns:carddav nresults"`
+}
+
+// reportRequest holds the addressbook-multiget, addressbook-query and
+// sync-collection reports; XMLName tells them apart.
+type reportRequest struct {
+	XMLName   xml.Name
+	Prop      *propRequest `xml:"DAV: prop"`
+	Hrefs     []string     `xml:"DAV: href"`
+	SyncToken string       `xml:"DAV: sync-token"`
+	Filter    *queryFilter `xml:"urn:ietf:params:xml:ns:carddav filter"`
+	Limit     *reportLimit `xml:"urn:ietf:params:xml:ns:carddav limit"`
+}
+
+// property is a property element in a response, Inner holding its XML content.
+type property struct {
+	XMLName xml.Name
+	Inner   string `xml:",innerxml"`
+}
+
+type propstat struct {
+	Props  []property `xml:"D:prop>any"`
+	Status string     `xml:"D:status"`
+}
+
+type response struct {
+	Href      string     `xml:"D:href"`
+	Status    string     `xml:"D:status,omitempty"`
+	Propstats []propstat `xml:"D:propstat"`
+}
+
+type multistatus struct {
+	XMLName   xml.Name   `xml:"D:multistatus"`
+	DAV       string     `xml:"xmlns:D,attr"`
+	CardDAV   string     `xml:"xmlns:C,attr"`
+	CS        string     `xml:"xmlns:CS,attr"`
+	Responses []response `xml:"D:response"`
+	SyncToken string     `xml:"D:sync-token,omitempty"`
+}
+
+func status(code int) string {
+	return "HTTP/1.1 " + strconv.Itoa(code) + " " + utils.StatusMessage(code)
+}
+
+// escapeText escapes character data for use in inner XML.
+func escapeText(text string) string {
+	var builder strings.Builder
+	xml.EscapeText(&builder, []byte(text))
+	return builder.String()
+}
+
+func hrefValue(path string) string {
+	return "<D:href>" + escapeText(path) + "</D:href>"
+}
+
+// outputName returns the element name of a property with a declared prefix,
+// unknown namespaces being declared on the element itself.
+func outputName(name xml.Name) xml.Name {
+	if prefix, ok := prefixes[name.Space]; ok {
+		return xml.Name{Local: prefix + ":" + name.Local}
+	}
+	return name
+}
+
+// prop is a live property of a resource. Hidden properties are only
+// returned when asked for by name.
+type prop struct {
+	name   xml.Name
+	value  string
+	hidden bool
+}
+
+type props []prop
+
+func davProp(local, value string) prop {
+	return prop{name: xml.Name{Space: nsDAV, Local: local}, value: value}
+}
+
+func cardDAVProp(local, value string) prop {
+	return prop{name: xml.Name{Space: nsCardDAV, Local: local}, value: value}
+}
+
+// response builds the response of a resource to a PROPFIND or REPORT. All
+// visible properties are returned when request is nil; names only lists
+// their names. Unknown properties asked for are reported as not found.
+func (list props) response(href string, request *propRequest, names bool) response {
+	found := []property{}
+	missing := []property{}
+
+	add := func(item prop) {
+		value := item.value
+		if names {
+			value = ""
+		}
+		found = append(found, property{XMLName: outputName(item.name), Inner: value})
+	}
+
+	if request == nil {
+		for _, item := range list {
+			if !item.hidden {
+				add(item)
+			}
+		}
+	} else {
+		for _, name := range request.Names {
+			known := false
+			for _, item := range list {
+				if item.name == name {
+					add(item)
+					known = true
+					break
+				}
+			}
+			if !known {
+				missing = append(missing, property{XMLName: outputName(name)})
+			}
+		}
+	}
+
+	resp := response{Href: href}
+	if len(found) > 0 || len(missing) == 0 {
+		resp.Propstats = append(resp.Propstats, propstat{Props: found, Status: status(fiber.StatusOK)})
+	}
+	if len(missing) > 0 {
+		resp.Propstats = append(resp.Propstats, propstat{Props: missing, Status: status(fiber.StatusNotFound)})
+	}
+	return resp
+}
+
+// sendMultistatus writes a 207 Multi-Status response.
+func sendMultistatus(ctx *fiber.Ctx, responses []response, syncToken string) error {
+	body, err := xml.Marshal(multistatus{
+		DAV:       nsDAV,
+		CardDAV:   nsCardDAV,
+		CS:        nsCalendarServer,
+		Responses: responses,
+		SyncToken: syncToken,
+	})
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	ctx.Set(fiber.HeaderContentType, "application/xml; charset=utf-8")
+	return ctx.Status(fiber.StatusMultiStatus).Send(append([]byte(xml.Header), body...))
+}
+
+// sendError writes a DAV error body naming the failed precondition.
+func sendError(ctx *fiber.Ctx, code int, condition xml.Name) error {
+	body := xml.Header + `<D:error xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:carddav"><` + outputName(condition).Local + `/></D:error>`
+	ctx.Set(fiber.HeaderContentType, "application/xml; charset=utf-8")
+	return ctx.Status(code).SendString(body)
+}
+
+// rootName returns the name of the root element of an XML document.
+func rootName(body []byte) (xml.Name, error) {
+	decoder := xml.NewDecoder(strings.NewReader(string(body)))
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return xml.Name{}, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return xml.Name{}, err
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			return start.Name, nil
+		}
+	}
+}
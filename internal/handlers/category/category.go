@@ -2,6 +2,7 @@ package category
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"strconv"
 
@@ -22,7 +23,10 @@ type basicResponse struct {
 }
 
 type categoryRequest struct {
-	Label string `json:"label"`
+	Label       string `json:"label"`
+	Description string `json:"description"`
+	Color       string `json:"color"`
+	Icon        string `json:"icon"`
 }
 
 type categoryResponse struct {
@@ -38,6 +42,9 @@ type categoryResponse struct {
 // @Param body body categoryRequest true "Category details"
 // @Success 200 {object} categoryResponse
 // @Failure 400 {string} string "Bad Request"
+// @Failure 409 {string} string "Category already exists"
+// @Failure 500 {string} string "Internal Server Error"
+// @Deprecated
 // @Router /categories/add-category [post]
 func (handler *CategoryHandler) AddCategory(ctx *fiber.Ctx) error {
 	var body categoryRequest
@@ -46,10 +53,23 @@ func (handler *CategoryHandler) AddCategory(ctx *fiber.Ctx) error {
 		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid request body")
 	}
 
+	if body.Color != "" && !storage.ColorPattern.MatchString(body.Color) {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid color, expected #RRGGBB")
+	}
+
 	id, err := handler.Storage.AddCategory(storage.NewCategoryInput{
-		Label: body.Label,
+		Label:       body.Label,
+		Description: body.Description,
+		Color:       body.Color,
+		Icon:        body.Icon,
 	})
 	if err != nil {
+		if errors.Is(err, storage.ErrInvalidCategory) {
+			return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+		}
+		if errors.Is(err, storage.ErrCategoryExists) {
+			return ctx.Status(fiber.StatusConflict).SendString(err.Error())
+		}
 		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
 	}
 
@@ -63,14 +83,18 @@ type categoryListResponse struct {
 
 // GetCategoryList swagger
 // @Summary Get list of categories
-// @Description Retrieve a list of all categories
+// @Description Retrieve a list of categories ordered by position, archived categories are hidden unless requested
 // @Tags Categories
 // @Accept json
 // @Produce json
+// @Param archived query bool false "Include archived categories"
 // @Success 200 {object} categoryListResponse
+// @Deprecated
 // @Router /categories/get-categories [get]
 func (handler *CategoryHandler) GetCategoryList(ctx *fiber.Ctx) error {
-	categories, err := handler.Storage.GetCategoryList()
+	includeArchived := ctx.QueryBool("archived", false)
+
+	categories, err := handler.Storage.GetCategoryList(includeArchived)
 	if err != nil {
 		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
 	}
@@ -92,6 +116,7 @@ func (handler *CategoryHandler) GetCategoryList(ctx *fiber.Ctx) error {
 // @Success 200 {object} basicResponse
 // @Failure 400 {string} string "Bad Request"
 // @Failure 404 {string} string "Not Found"
+// @Deprecated
 // @Router /categories/delete-category/{id} [delete]
 func (handler *CategoryHandler) DeleteCategory(ctx *fiber.Ctx) error {
 	id := ctx.Params("id")
@@ -130,6 +155,9 @@ type updateCategoryLabelRequest struct {
 // @Success 200 {object} basicResponse
 // @Failure 400 {string} string "Bad Request"
 // @Failure 404 {string} string "Not Found"
+// @Failure 409 {string} string "Category already exists"
+// @Failure 500 {string} string "Internal Server Error"
+// @Deprecated
 // @Router /categories/update-category/{id} [patch]
 func (handler *CategoryHandler) UpdateCategoryLabel(ctx *fiber.Ctx) error {
 	id := ctx.Params("id")
@@ -146,6 +174,15 @@ func (handler *CategoryHandler) UpdateCategoryLabel(ctx *fiber.Ctx) error {
 
 	err = handler.Storage.UpdateCategoryLabel(categoryID, req.Label)
 	if err != nil {
+		if err == sql.ErrNoRows {
+			return ctx.Status(fiber.StatusNotFound).SendString("Category not found")
+		}
+		if errors.Is(err, storage.ErrInvalidCategory) {
+			return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+		}
+		if errors.Is(err, storage.ErrCategoryExists) {
+			return ctx.Status(fiber.StatusConflict).SendString(err.Error())
+		}
 		return ctx.Status(fiber.StatusInternalServerError).SendString(fmt.Sprintf("Failed to update category label: %v", err))
 	}
 
@@ -167,6 +204,7 @@ type fetchCategoryRespones struct {
 // @Success 200 {object} fetchCategoryRespones
 // @Failure 400 {string} string "Bad Request"
 // @Failure 404 {string} string "Not Found"
+// @Deprecated
 // @Router /categories/get-category/{id} [get]
 func (handler *CategoryHandler) GetCategory(ctx *fiber.Ctx) error {
 	id := ctx.Params("id")
@@ -179,7 +217,7 @@ func (handler *CategoryHandler) GetCategory(ctx *fiber.Ctx) error {
 	category, err := handler.Storage.GetCategory(categoryId)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return ctx.Status(fiber.StatusInternalServerError).SendString("Category not found")
+			return ctx.Status(fiber.StatusNotFound).SendString("Category not found")
 		}
 		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
 	}
@@ -189,3 +227,328 @@ func (handler *CategoryHandler) GetCategory(ctx *fiber.Ctx) error {
 	}
 	return ctx.Status(fiber.StatusOK).JSON(resp)
 }
+
+type updateCategoryMetadataRequest struct {
+	Description *string `json:"description"`
+	Color       *string `json:"color"`
+	Icon        *string `json:"icon"`
+	Archived    *bool   `json:"archived"`
+}
+
+// UpdateCategoryMetadata swagger
+// @Summary Update category metadata
+// @Description Update description, color, icon or archived flag of a category, omitted fields are left unchanged
+// @Tags Categories
+// @Accept json
+// @Produce json
+// @Param id path int true "Category ID"
+// @Param body body updateCategoryMetadataRequest true "Category metadata"
+// @Success 200 {object} basicResponse
+// @Failure 400 {string} string "Bad Request"
+// @Failure 404 {string} string "Not Found"
+// @Deprecated
+// @Router /categories/update-category-metadata/{id} [patch]
+func (handler *CategoryHandler) UpdateCategoryMetadata(ctx *fiber.Ctx) error {
+	id := ctx.Params("id")
+
+	var req updateCategoryMetadataRequest
+	if err := ctx.BodyParser(&req); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid request body")
+	}
+
+	categoryId, err := strconv.Atoi(id)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid category ID")
+	}
+
+	if req.Color != nil && *req.Color != "" && !storage.ColorPattern.MatchString(*req.Color) {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid color, expected #RRGGBB")
+	}
+
+	err = handler.Storage.UpdateCategoryMetadata(categoryId, storage.UpdateCategoryMetadataInput{
+		Description: req.Description,
+		Color:       req.Color,
+		Icon:        req.Icon,
+		Archived:    req.Archived,
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ctx.Status(fiber.StatusNotFound).SendString("Category not found")
+		}
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	resp := basicResponse{Success: true}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+type reorderCategoriesRequest struct {
+	Ids []int `json:"ids"`
+}
+
+// ReorderCategories swagger
+// @Summary Reorder categories
+// @Description Move the given categories to the front in the order of their IDs, in one atomic call. Categories left out keep their relative order after them
+// @Tags Categories
+// @Accept json
+// @Produce json
+// @Param body body reorderCategoriesRequest true "Category IDs in the desired order"
+// @Success 200 {object} basicResponse
+// @Failure 400 {string} string "Bad Request"
+// @Failure 404 {string} string "Not Found"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /categories/reorder-categories [put]
+func (handler *CategoryHandler) ReorderCategories(ctx *fiber.Ctx) error {
+	var req reorderCategoriesRequest
+	if err := ctx.BodyParser(&req); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid request body")
+	}
+
+	if len(req.Ids) == 0 {
+		return ctx.Status(fiber.StatusBadRequest).SendString("No category IDs given")
+	}
+
+	seen := make(map[int]bool, len(req.Ids))
+	for _, id := range req.Ids {
+		if seen[id] {
+			return ctx.Status(fiber.StatusBadRequest).SendString(fmt.Sprintf("Category ID %d is listed more than once", id))
+		}
+		seen[id] = true
+	}
+
+	if err := handler.Storage.ReorderCategories(req.Ids); err != nil {
+		if errors.Is(err, storage.ErrUnknownCategory) {
+			return ctx.Status(fiber.StatusNotFound).SendString(err.Error())
+		}
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	resp := basicResponse{Success: true}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+type categoryStatsResponse struct {
+	Stats []storage.CategoryStats `json:"stats"`
+}
+
+// GetCategoryStats swagger
+// @Summary Get category statistics
+// @Description Per-category contact counts, contacts created in the last 7 and 30 days and the newest contact date. Accepts the same filters as the contact list
+// @Tags Categories
+// @Accept json
+// @Produce json
+// @Param name query string false "Filter by contact name"
+// @Param phone query string false "Filter by any of the contact phones"
+// @Param email query string false "Filter by any of the contact emails"
+// @Param category query string false "Filter by category label"
+// @Param organization query string false "Filter by organization name"
+// @Param city query string false "Filter by address city"
+// @Param region query string false "Filter by address region"
+// @Param country query string false "Filter by address country"
+// @Param near query string false "Only contacts near this point, as lat,lng"
+// @Param radius query number false "Search radius around near in km (5 default)"
+// @Param cf.key query string false "Filter by custom field value, e.g. cf.tax_id=123"
+// @Param filter query string false "Filter expression, e.g. created_at > 2024-01-01 and not (city = ...); text values are double quoted"
+// @Param archived query bool false "Include archived categories"
+// @Param fresh query bool false "Bypass the statistics cache"
+// @Success 200 {object} categoryStatsResponse
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /categories/get-category-stats [get]
+func (handler *CategoryHandler) GetCategoryStats(ctx *fiber.Ctx) error {
+	filter, err := storage.NewContactFilter(ctx.Queries())
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+	includeArchived := ctx.QueryBool("archived", false)
+	fresh := ctx.QueryBool("fresh", false)
+
+	stats, err := handler.Storage.GetCategoryStats(filter, includeArchived, fresh)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	resp := categoryStatsResponse{
+		Stats: stats,
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+// mergeCategoryConflict reports a merge blocked by the custom fields of the
+// contacts listed in invalid_contacts.
+type mergeCategoryConflict struct {
+	Error string `json:"error"`
+	storage.MergeCategoryResult
+}
+
+// MergeCategory swagger
+// @Summary Merge a category into another
+// @Description Move all contacts of the category to the target category and delete it. With dry_run only the affected counts are reported. Contacts whose custom fields do not fit the target block the merge and are listed in a 409 response
+// @Tags Categories
+// @Accept json
+// @Produce json
+// @Param id path int true "Source category ID"
+// @Param target path int true "Target category ID"
+// @Param dry_run query bool false "Report affected counts without merging"
+// @Success 200 {object} storage.MergeCategoryResult
+// @Failure 400 {string} string "Bad Request"
+// @Failure 404 {string} string "Not Found"
+// @Failure 409 {object} mergeCategoryConflict
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /categories/{id}/merge-into/{target} [post]
+func (handler *CategoryHandler) MergeCategory(ctx *fiber.Ctx) error {
+	sourceId, err := strconv.Atoi(ctx.Params("id"))
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid category ID")
+	}
+
+	targetId, err := strconv.Atoi(ctx.Params("target"))
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid target category ID")
+	}
+
+	if sourceId == targetId {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Cannot merge a category into itself")
+	}
+
+	result, err := handler.Storage.MergeCategory(sourceId, targetId, ctx.QueryBool("dry_run", false))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ctx.Status(fiber.StatusNotFound).SendString("Category not found")
+		}
+		if errors.Is(err, storage.ErrCustomFieldsNotValid) {
+			return ctx.Status(fiber.StatusConflict).JSON(mergeCategoryConflict{Error: err.Error(), MergeCategoryResult: result})
+		}
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(result)
+}
+
+type categoryFieldRequest struct {
+	Key      string   `json:"key"`
+	Label    string   `json:"label"`
+	Type     string   `json:"type" enums:"text,number,date,enum,boolean"`
+	Options  []string `json:"options"`
+	Required bool     `json:"required"`
+	// Default fills the field on existing contacts of the category, required
+	// fields need one when the category has contacts.
+	Default interface{} `json:"default" swaggertype:"string"`
+}
+
+// AddCategoryField swagger
+// @Summary Add a custom field to a category
+// @Description Define a typed custom field (text, number, date, enum, boolean) for contacts of the category.
+// @Description The default is stored on existing contacts without a value; a required field needs one when the category has contacts
+// @Tags Categories
+// @Accept json
+// @Produce json
+// @Param id path int true "Category ID"
+// @Param body body categoryFieldRequest true "Field definition"
+// @Success 200 {object} categoryResponse
+// @Failure 400 {string} string "Bad Request"
+// @Failure 404 {string} string "Not Found"
+// @Failure 409 {string} string "Field already exists"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /categories/add-category-field/{id} [post]
+func (handler *CategoryHandler) AddCategoryField(ctx *fiber.Ctx) error {
+	categoryId, err := strconv.Atoi(ctx.Params("id"))
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid category ID")
+	}
+
+	var body categoryFieldRequest
+	if err := ctx.BodyParser(&body); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid request body")
+	}
+
+	id, err := handler.Storage.AddCategoryField(categoryId, storage.NewCategoryFieldInput{
+		Key:      body.Key,
+		Label:    body.Label,
+		Type:     body.Type,
+		Options:  body.Options,
+		Required: body.Required,
+		Default:  body.Default,
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ctx.Status(fiber.StatusNotFound).SendString("Category not found")
+		}
+		if errors.Is(err, storage.ErrFieldExists) {
+			return ctx.Status(fiber.StatusConflict).SendString(err.Error())
+		}
+		if errors.Is(err, storage.ErrInvalidField) {
+			return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+		}
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	resp := categoryResponse{Id: id}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+type categoryFieldListResponse struct {
+	Fields []storage.CategoryField `json:"fields"`
+}
+
+// GetCategoryFields swagger
+// @Summary Get custom fields of a category
+// @Description Retrieve the custom field definitions of a category
+// @Tags Categories
+// @Accept json
+// @Produce json
+// @Param id path int true "Category ID"
+// @Success 200 {object} categoryFieldListResponse
+// @Failure 400 {string} string "Bad Request"
+// @Router /categories/get-category-fields/{id} [get]
+func (handler *CategoryHandler) GetCategoryFields(ctx *fiber.Ctx) error {
+	categoryId, err := strconv.Atoi(ctx.Params("id"))
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid category ID")
+	}
+
+	fields, err := handler.Storage.GetCategoryFields(categoryId)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	resp := categoryFieldListResponse{
+		Fields: fields,
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+// DeleteCategoryField swagger
+// @Summary Delete a custom field of a category
+// @Description Delete the field definition and the values stored for it on contacts
+// @Tags Categories
+// @Accept json
+// @Produce json
+// @Param id path int true "Category ID"
+// @Param fieldId path int true "Field ID"
+// @Success 200 {object} basicResponse
+// @Failure 400 {string} string "Bad Request"
+// @Failure 404 {string} string "Not Found"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /categories/delete-category-field/{id}/{fieldId} [delete]
+func (handler *CategoryHandler) DeleteCategoryField(ctx *fiber.Ctx) error {
+	categoryId, err := strconv.Atoi(ctx.Params("id"))
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid category ID")
+	}
+
+	fieldId, err := strconv.Atoi(ctx.Params("fieldId"))
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid field ID")
+	}
+
+	err = handler.Storage.DeleteCategoryField(categoryId, fieldId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ctx.Status(fiber.StatusNotFound).SendString("Field not found")
+		}
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	resp := basicResponse{Success: true}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
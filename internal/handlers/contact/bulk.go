@@ -0,0 +1,188 @@
+package contact
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/utah1280/backend-internship-2024/internal/storage"
+)
+
+type bulkCreateRequest struct {
+	Mode     string                 `json:"mode" enums:"transactional,best_effort"`
+	Contacts []createContactRequest `json:"contacts"`
+}
+
+type bulkUpdateItem struct {
+	Id           int                    `json:"id"`
+	Name         string                 `json:"name"`
+	Phone        string                 `json:"phone"`
+	Email        string                 `json:"email"`
+	Address      string                 `json:"address"`
+	Category     string                 `json:"category"`
+	CustomFields map[string]interface{} `json:"custom_fields"`
+}
+
+type bulkUpdateRequest struct {
+	Mode     string           `json:"mode" enums:"transactional,best_effort"`
+	Contacts []bulkUpdateItem `json:"contacts"`
+}
+
+type bulkDeleteRequest struct {
+	Mode string `json:"mode" enums:"transactional,best_effort"`
+	Ids  []int  `json:"ids"`
+}
+
+// sendBulkResult answers with the batch result, as a 400 when nothing was applied.
+func sendBulkResult(ctx *fiber.Ctx, result storage.BulkResult) error {
+	if !result.Committed {
+		return ctx.Status(fiber.StatusBadRequest).JSON(result)
+	}
+	return ctx.Status(fiber.StatusOK).JSON(result)
+}
+
+// BulkCreateContacts swagger
+// @Summary Create contacts in bulk
+// @Description Create up to 500 contacts in one transaction. In transactional mode (default) nothing is created when any contact fails, in best_effort mode the valid ones are created. Every contact gets a result
+// @Tags Contacts
+// @Accept json
+// @Produce json
+// @Param body body bulkCreateRequest true "Contacts"
+// @Success 200 {object} storage.BulkResult
+// @Failure 400 {object} storage.BulkResult
+// @Router /contacts/bulk-create-contacts [post]
+func (handler *ContactHandler) BulkCreateContacts(ctx *fiber.Ctx) error {
+	var body bulkCreateRequest
+	if err := ctx.BodyParser(&body); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid request body")
+	}
+
+	contacts := make([]storage.NewContactInput, len(body.Contacts))
+	for i, contact := range body.Contacts {
+		contacts[i] = contact.input()
+	}
+
+	result, err := handler.Storage.BulkCreateContacts(contacts, body.Mode)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+
+	return sendBulkResult(ctx, result)
+}
+
+// BulkUpdateContacts swagger
+// @Summary Update contacts in bulk
+// @Description Update up to 500 contacts in one transaction, empty fields being left unchanged. In transactional mode (default) nothing is changed when any update fails, in best_effort mode the valid ones are applied
+// @Tags Contacts
+// @Accept json
+// @Produce json
+// @Param body body bulkUpdateRequest true "Contact changes"
+// @Success 200 {object} storage.BulkResult
+// @Failure 400 {object} storage.BulkResult
+// @Router /contacts/bulk-update-contacts [patch]
+func (handler *ContactHandler) BulkUpdateContacts(ctx *fiber.Ctx) error {
+	var body bulkUpdateRequest
+	if err := ctx.BodyParser(&body); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid request body")
+	}
+
+	contacts := make([]storage.UpdateContactInput, len(body.Contacts))
+	for i, contact := range body.Contacts {
+		contacts[i] = storage.UpdateContactInput{
+			Id:           contact.Id,
+			Name:         contact.Name,
+			Phone:        contact.Phone,
+			Email:        contact.Email,
+			Address:      contact.Address,
+			Category:     contact.Category,
+			CustomFields: contact.CustomFields,
+		}
+	}
+
+	result, err := handler.Storage.BulkUpdateContacts(contacts, body.Mode)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+
+	return sendBulkResult(ctx, result)
+}
+
+// BulkDeleteContacts swagger
+// @Summary Delete contacts in bulk
+// @Description Delete up to 500 contacts in one transaction. In transactional mode (default) nothing is deleted when any id is not found, in best_effort mode the found ones are deleted
+// @Tags Contacts
+// @Accept json
+// @Produce json
+// @Param body body bulkDeleteRequest true "Contact IDs"
+// @Success 200 {object} storage.BulkResult
+// @Failure 400 {object} storage.BulkResult
+// @Router /contacts/bulk-delete-contacts [delete]
+func (handler *ContactHandler) BulkDeleteContacts(ctx *fiber.Ctx) error {
+	var body bulkDeleteRequest
+	if err := ctx.BodyParser(&body); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid request body")
+	}
+
+	result, err := handler.Storage.BulkDeleteContacts(body.Ids, body.Mode)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+
+	return sendBulkResult(ctx, result)
+}
+
+type bulkSetCategoryRequest struct {
+	Category string `json:"category"`
+	DryRun   bool   `json:"dry_run"`
+}
+
+// bulkSetCategoryConflict reports a reassignment blocked by the custom fields
+// of the contacts listed in invalid_contacts.
+type bulkSetCategoryConflict struct {
+	Error string `json:"error"`
+	storage.SetCategoryResult
+}
+
+// BulkSetCategory swagger
+// @Summary Move contacts matching a filter to a category
+// @Description Set the category of every contact matching the same filters as get-contacts in one statement. At least one filter is required. Nothing is moved when some contact has custom fields the category does not accept; the answer is then a 409 listing them in invalid_contacts
+// @Tags Contacts
+// @Accept json
+// @Produce json
+// @Param body body bulkSetCategoryRequest true "Target category"
+// @Param name query string false "Filter by contact name"
+// @Param phone query string false "Filter by any of the contact phones"
+// @Param email query string false "Filter by any of the contact emails"
+// @Param category query string false "Filter by category label"
+// @Param organization query string false "Filter by organization name"
+// @Param city query string false "Filter by address city"
+// @Param region query string false "Filter by address region"
+// @Param country query string false "Filter by address country"
+// @Param near query string false "Only contacts near this point, as lat,lng"
+// @Param radius query number false "Search radius around near in km (5 default)"
+// @Param cf.key query string false "Filter by custom field value, e.g. cf.tax_id=123"
+// @Param filter query string false "Filter expression, e.g. created_at > 2024-01-01 and not (city = ...); text values are double quoted"
+// @Success 200 {object} storage.SetCategoryResult
+// @Failure 400 {string} string "Bad Request"
+// @Failure 409 {object} bulkSetCategoryConflict
+// @Router /contacts/bulk-set-category [patch]
+func (handler *ContactHandler) BulkSetCategory(ctx *fiber.Ctx) error {
+	var body bulkSetCategoryRequest
+	if err := ctx.BodyParser(&body); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid request body")
+	}
+
+	filter, err := storage.NewContactFilter(ctx.Queries())
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+
+	result, err := handler.Storage.SetCategoryByFilter(filter, body.Category, body.DryRun)
+	if err != nil {
+		if errors.Is(err, storage.ErrCustomFieldsNotValid) {
+			return ctx.Status(fiber.StatusConflict).JSON(bulkSetCategoryConflict{Error: err.Error(), SetCategoryResult: result})
+		}
+		return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(result)
+}
@@ -1,13 +1,60 @@
 package contact
 
 import (
+	"bufio"
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/utah1280/backend-internship-2024/internal/exporter"
+	"github.com/utah1280/backend-internship-2024/internal/handlers/contactpatch"
+	"github.com/utah1280/backend-internship-2024/internal/importer"
 	"github.com/utah1280/backend-internship-2024/internal/storage"
+	"github.com/utah1280/backend-internship-2024/internal/vcard"
 )
 
+// exportFlushRows is the number of exported rows sent to the client at once.
+const exportFlushRows = 500
+
+// exportWriteTimeout replaces the server write timeout for streamed exports,
+// which take as long as they take: the client is only dropped when no rows
+// could be sent to it for that long.
+const exportWriteTimeout = time.Minute
+
+// exportStream is the connection a streamed export is written to.
+type exportStream struct {
+	conn net.Conn
+}
+
+// newExportStream must be called from the handler, the context is recycled
+// before the body is written.
+func newExportStream(ctx *fiber.Ctx) exportStream {
+	return exportStream{conn: ctx.Context().Conn()}
+}
+
+// progress pushes the write deadline back, it is called for every flush.
+func (stream exportStream) progress() {
+	if stream.conn != nil {
+		stream.conn.SetWriteDeadline(time.Now().Add(exportWriteTimeout))
+	}
+}
+
+// fail ends an export that could not be completed. The connection is closed
+// before the final chunk is sent, so the client sees an incomplete response
+// instead of a file that looks whole.
+func (stream exportStream) fail(what string, err error) {
+	log.Printf("Error exporting %s, closing the connection: %v", what, err)
+	if stream.conn != nil {
+		stream.conn.Close()
+	}
+}
+
 type ContactHandler struct {
 	Storage *storage.ContactStorage
 }
@@ -21,27 +68,55 @@ type basicResponse struct {
 }
 
 type createContactRequest struct {
-	Name    string `json:"name"`
-	Phone   string `json:"phone"`
-	Email   string `json:"email"`
-	Address string `json:"address"`
-	Label   string `json:"label"`
+	Name           string                       `json:"name"`
+	Phone          string                       `json:"phone"`
+	Email          string                       `json:"email"`
+	Address        string                       `json:"address"`
+	Label          string                       `json:"label"`
+	CustomFields   map[string]interface{}       `json:"custom_fields"`
+	Phones         []storage.ContactMethodInput `json:"phones"`
+	Emails         []storage.ContactMethodInput `json:"emails"`
+	Addresses      []storage.ContactMethodInput `json:"addresses"`
+	OrganizationId int                          `json:"organization_id"`
+	JobTitle       string                       `json:"job_title"`
 }
 
+func (body createContactRequest) input() storage.NewContactInput {
+	return storage.NewContactInput{
+		Name:           body.Name,
+		Phone:          body.Phone,
+		Email:          body.Email,
+		Address:        body.Address,
+		Label:          body.Label,
+		CustomFields:   body.CustomFields,
+		Phones:         body.Phones,
+		Emails:         body.Emails,
+		Addresses:      body.Addresses,
+		OrganizationId: body.OrganizationId,
+		JobTitle:       body.JobTitle,
+	}
+}
+
+// createContactResponse carries a warning and the matching contacts when the
+// new contact looks like a duplicate; the contact is created regardless.
 type createContactResponse struct {
-	Id int `json:"id"`
+	Id         int                      `json:"id"`
+	Warning    string                   `json:"warning,omitempty"`
+	Duplicates []storage.DuplicateMatch `json:"duplicates,omitempty"`
 }
 
 // CreateContact swagger
 // @Summary Create a new contact
-// @Description Create a new contact with the given details
+// @Description Create a new contact with the given details, warning when it resembles existing contacts
 // @Tags Contacts
 // @Accept json
 // @Produce json
 // @Param body body createContactRequest true "Contact details"
 // @Success 200 {object} createContactResponse
 // @Failure 400 {string} string "Bad Request"
+// @Failure 409 {string} string "Email already exists"
 // @Failure 500 {string} string "Internal Server Error"
+// @Deprecated
 // @Router /contacts/new-contact [post]
 func (handler *ContactHandler) CreateContact(ctx *fiber.Ctx) error {
 	var body createContactRequest
@@ -50,18 +125,27 @@ func (handler *ContactHandler) CreateContact(ctx *fiber.Ctx) error {
 		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid request body")
 	}
 
-	id, err := handler.Storage.CreateContact(storage.NewContactInput{
-		Name:    body.Name,
-		Phone:   body.Phone,
-		Email:   body.Email,
-		Address: body.Address,
-		Label:   body.Label,
-	})
+	id, err := handler.Storage.CreateContact(body.input())
 	if err != nil {
+		if errors.Is(err, storage.ErrEmailExists) {
+			return ctx.Status(fiber.StatusConflict).SendString(err.Error())
+		}
+		if errors.Is(err, storage.ErrInvalidContact) {
+			return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+		}
 		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
 	}
 
 	resp := createContactResponse{Id: id}
+
+	duplicates, err := handler.Storage.FindContactDuplicates(id, storage.LikelyDuplicateScore)
+	if err != nil {
+		log.Printf("Error checking duplicates of contact %d: %v", id, err)
+	} else if len(duplicates) > 0 {
+		resp.Warning = "Contact looks like a duplicate of existing contacts"
+		resp.Duplicates = duplicates
+	}
+
 	return ctx.Status(fiber.StatusOK).JSON(resp)
 }
 
@@ -79,6 +163,7 @@ type fetchContactResponse struct {
 // @Success 200 {object} fetchContactResponse
 // @Failure 400 {string} string "Bad Request"
 // @Failure 404 {string} string "Not Found"
+// @Deprecated
 // @Router /contacts/get-contact/{id} [get]
 func (handler *ContactHandler) GetContact(ctx *fiber.Ctx) error {
 	id := ctx.Params("id")
@@ -112,6 +197,7 @@ func (handler *ContactHandler) GetContact(ctx *fiber.Ctx) error {
 // @Success 200 {object} basicResponse
 // @Failure 400 {string} string "Bad Request"
 // @Failure 404 {string} string "Not Found"
+// @Deprecated
 // @Router /contacts/delete-contact/{id} [delete]
 func (handler *ContactHandler) DeleteContact(ctx *fiber.Ctx) error {
 	id := ctx.Params("id")
@@ -139,19 +225,38 @@ type contactListResponse struct {
 	Contacts []storage.Contact_ `json:"contact"`
 }
 
+// contactViewListResponse holds contacts trimmed to the requested fields.
+type contactViewListResponse struct {
+	Contacts []storage.ContactView `json:"contact"`
+}
+
 // GetContacts swagger
 // @Summary Get list of contacts
-// @Description Retrieve a list of contacts with optional filtering, sorting, and pagination
+// @Description Retrieve a list of contacts with optional filtering, sorting, and pagination. With near, results are ordered by distance
 // @Tags Contacts
 // @Accept json
 // @Produce json
 // @Param limit query int false "Limit results per page"
 // @Param offset query int false "Offset results for pagination"
 // @Param name query string false "Filter by contact name"
-// @Param email query string false "Filter by contact email"
+// @Param phone query string false "Filter by any of the contact phones"
+// @Param email query string false "Filter by any of the contact emails"
 // @Param category query string false "Filter by category label"
+// @Param organization query string false "Filter by organization name"
+// @Param city query string false "Filter by address city"
+// @Param region query string false "Filter by address region"
+// @Param country query string false "Filter by address country"
+// @Param sortBy query string false "Sort field: created_at (default), last_contacted_at or name"
 // @Param sortDir query string false "Sort direction (ASC default)"
-// @Success 200 {array} storage.Contact_
+// @Param near query string false "Only contacts near this point, as lat,lng"
+// @Param radius query number false "Search radius around near in km (5 default)"
+// @Param cf.key query string false "Filter by custom field value, e.g. cf.tax_id=123"
+// @Param filter query string false "Filter expression, e.g. created_at > 2024-01-01 and not (city = ...); text values are double quoted"
+// @Param fields query string false "Comma separated contact fields to return, e.g. id,name,phone; all fields by default"
+// @Param include query string false "Related objects to embed: category replaces the category label with the category"
+// @Success 200 {object} contactListResponse
+// @Failure 400 {string} string "Bad Request"
+// @Deprecated
 // @Router /contacts/get-contacts [get]
 func (handler *ContactHandler) GetContacts(ctx *fiber.Ctx) error {
 	limit, err := strconv.Atoi(ctx.Query("limit", "10"))
@@ -164,12 +269,31 @@ func (handler *ContactHandler) GetContacts(ctx *fiber.Ctx) error {
 		offset = 0
 	}
 
-	name := ctx.Query("name", "")
-	email := ctx.Query("email", "")
-	category := ctx.Query("category", "")
+	sortBy := ctx.Query("sortBy", "created_at")
 	sortDir := ctx.Query("sortDir", "ASC")
+	filter, err := storage.NewContactFilter(ctx.Queries())
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
 
-	contacts, err := handler.Storage.GetContacts(limit, offset, name, email, category, sortDir)
+	selection, err := storage.NewContactSelection(ctx.Query("fields"), ctx.Query("include"))
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+
+	if !selection.IsZero() {
+		contacts, err := handler.Storage.GetSelectedContacts(limit, offset, filter, sortBy, sortDir, selection)
+		if err != nil {
+			return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+		}
+
+		resp := contactViewListResponse{
+			Contacts: contacts,
+		}
+		return ctx.Status(fiber.StatusOK).JSON(resp)
+	}
+
+	contacts, err := handler.Storage.GetContacts(limit, offset, filter, sortBy, sortDir)
 	if err != nil {
 		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
 	}
@@ -183,19 +307,22 @@ func (handler *ContactHandler) GetContacts(ctx *fiber.Ctx) error {
 
 // UpdateContact swagger
 // @Summary Update an existing contact
-// @Description Update contact details by ID
+// @Description Apply a JSON Merge Patch (RFC 7396, sent as application/merge-patch+json or application/json) or a JSON Patch (RFC 6902, sent as application/json-patch+json) to the contact document shown in the body schema.
+// @Description Members left out of a merge patch are not changed, while null or empty values clear the field; name and category cannot be cleared. Phone, email and address are the primary entries of the phones, emails and addresses lists.
 // @Tags Contacts
 // @Accept json
+// @Accept application/merge-patch+json
+// @Accept application/json-patch+json
 // @Produce json
 // @Param id path int true "Contact ID"
-// @Param name query string false "Contact name"
-// @Param phone query string false "Contact phone"
-// @Param email query string false "Contact email"
-// @Param address query string false "Contact address"
-// @Param category query string false "Contact category"
-// @Success 200 {object} basicResponse
-// @Failure 400 {string} string "Invalid contact ID"
+// @Param body body storage.ContactDocument true "Merge patch, or a JSON Patch array of operations"
+// @Success 200 {object} storage.Contact_
+// @Failure 400 {string} string "Invalid patch"
+// @Failure 404 {string} string "Not Found"
+// @Failure 409 {string} string "A test operation failed or the email already exists"
+// @Failure 415 {string} string "Unsupported patch format"
 // @Failure 500 {string} string "Internal Server Error"
+// @Deprecated
 // @Router /contacts/update-contact/{id} [patch]
 func (handler *ContactHandler) UpdateContact(ctx *fiber.Ctx) error {
 	id := ctx.Params("id")
@@ -205,20 +332,867 @@ func (handler *ContactHandler) UpdateContact(ctx *fiber.Ctx) error {
 		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid contact ID")
 	}
 
-	name := ctx.Query("name", "")
-	phone := ctx.Query("phone", "")
-	email := ctx.Query("email")
-	address := ctx.Query("address")
-	category := ctx.Query("category", "")
+	if status, msg := contactpatch.Apply(ctx, handler.Storage, contactId); status != fiber.StatusOK {
+		return ctx.Status(status).SendString(msg)
+	}
+
+	contact, err := handler.Storage.GetContact(contactId)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(contact)
+}
+
+type contactMethodsRequest struct {
+	Phones    []storage.ContactMethodInput `json:"phones"`
+	Emails    []storage.ContactMethodInput `json:"emails"`
+	Addresses []storage.ContactMethodInput `json:"addresses"`
+}
+
+// SetContactMethods swagger
+// @Summary Replace contact phones, emails or addresses
+// @Description Replace the listed contact methods of a contact, omitted lists are left unchanged. The primary entries become the contact phone, email and address
+// @Tags Contacts
+// @Accept json
+// @Produce json
+// @Param id path int true "Contact ID"
+// @Param body body contactMethodsRequest true "Contact methods"
+// @Success 200 {object} basicResponse
+// @Failure 400 {string} string "Bad Request"
+// @Failure 404 {string} string "Not Found"
+// @Failure 409 {string} string "Email already exists"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /contacts/set-contact-methods/{id} [put]
+func (handler *ContactHandler) SetContactMethods(ctx *fiber.Ctx) error {
+	contactId, err := strconv.Atoi(ctx.Params("id"))
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid contact ID")
+	}
+
+	var body contactMethodsRequest
+	if err := ctx.BodyParser(&body); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid request body")
+	}
+
+	err = handler.Storage.SetContactMethods(contactId, storage.ContactMethods{
+		Phones:    body.Phones,
+		Emails:    body.Emails,
+		Addresses: body.Addresses,
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ctx.Status(fiber.StatusNotFound).SendString("Contact not found")
+		}
+		if errors.Is(err, storage.ErrEmailExists) {
+			return ctx.Status(fiber.StatusConflict).SendString(err.Error())
+		}
+		if errors.Is(err, storage.ErrInvalidContact) {
+			return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+		}
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	resp := basicResponse{
+		Success: true,
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+type contactLocationRequest struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// SetContactLocation swagger
+// @Summary Set contact coordinates manually
+// @Description Override the geocoded location of a contact, the manual location is kept when the address changes
+// @Tags Contacts
+// @Accept json
+// @Produce json
+// @Param id path int true "Contact ID"
+// @Param body body contactLocationRequest true "Coordinates"
+// @Success 200 {object} basicResponse
+// @Failure 400 {string} string "Bad Request"
+// @Failure 404 {string} string "Not Found"
+// @Router /contacts/set-contact-location/{id} [put]
+func (handler *ContactHandler) SetContactLocation(ctx *fiber.Ctx) error {
+	contactId, err := strconv.Atoi(ctx.Params("id"))
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid contact ID")
+	}
+
+	var body contactLocationRequest
+	if err := ctx.BodyParser(&body); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid request body")
+	}
+
+	err = handler.Storage.SetContactLocation(contactId, storage.GeoPoint{
+		Latitude:  body.Latitude,
+		Longitude: body.Longitude,
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ctx.Status(fiber.StatusNotFound).SendString("Contact not found")
+		}
+		return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+
+	resp := basicResponse{
+		Success: true,
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+// DeleteContactLocation swagger
+// @Summary Remove a manual contact location
+// @Description Drop the manual coordinates of a contact and geocode it from its address again
+// @Tags Contacts
+// @Accept json
+// @Produce json
+// @Param id path int true "Contact ID"
+// @Success 200 {object} basicResponse
+// @Failure 400 {string} string "Bad Request"
+// @Failure 404 {string} string "Not Found"
+// @Router /contacts/delete-contact-location/{id} [delete]
+func (handler *ContactHandler) DeleteContactLocation(ctx *fiber.Ctx) error {
+	contactId, err := strconv.Atoi(ctx.Params("id"))
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid contact ID")
+	}
+
+	err = handler.Storage.ClearContactLocation(contactId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ctx.Status(fiber.StatusNotFound).SendString("Contact not found")
+		}
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	resp := basicResponse{
+		Success: true,
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+type geocodeContactsResponse struct {
+	Located int `json:"located"`
+}
+
+// GeocodeContacts swagger
+// @Summary Geocode all contacts
+// @Description Geocode every contact without a manual location from its primary address using the bundled gazetteer
+// @Tags Contacts
+// @Accept json
+// @Produce json
+// @Success 200 {object} geocodeContactsResponse
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /contacts/geocode-contacts [post]
+func (handler *ContactHandler) GeocodeContacts(ctx *fiber.Ctx) error {
+	located, err := handler.Storage.GeocodeContacts()
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	resp := geocodeContactsResponse{
+		Located: located,
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+type contactOrganizationRequest struct {
+	OrganizationId *int   `json:"organization_id"`
+	JobTitle       string `json:"job_title"`
+}
+
+// SetContactOrganization swagger
+// @Summary Link a contact to an organization
+// @Description Set the organization and job title of a contact, a null organization_id unlinks it
+// @Tags Contacts
+// @Accept json
+// @Produce json
+// @Param id path int true "Contact ID"
+// @Param body body contactOrganizationRequest true "Organization link"
+// @Success 200 {object} basicResponse
+// @Failure 400 {string} string "Bad Request"
+// @Failure 404 {string} string "Not Found"
+// @Router /contacts/set-contact-organization/{id} [put]
+func (handler *ContactHandler) SetContactOrganization(ctx *fiber.Ctx) error {
+	contactId, err := strconv.Atoi(ctx.Params("id"))
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid contact ID")
+	}
+
+	var body contactOrganizationRequest
+	if err := ctx.BodyParser(&body); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid request body")
+	}
+
+	err = handler.Storage.SetContactOrganization(contactId, body.OrganizationId, body.JobTitle)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ctx.Status(fiber.StatusNotFound).SendString("Contact not found")
+		}
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	resp := basicResponse{
+		Success: true,
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+type contactRelationshipRequest struct {
+	RelatedContactId int    `json:"related_contact_id"`
+	Type             string `json:"type" enums:"manager,assistant,spouse,referrer"`
+	Bidirectional    bool   `json:"bidirectional"`
+}
+
+type contactRelationshipResponse struct {
+	Id int `json:"id"`
+}
+
+// AddContactRelationship swagger
+// @Summary Link two contacts
+// @Description Record that the related contact is the manager, assistant, spouse or referrer of the contact, optionally in both directions
+// @Tags Contacts
+// @Accept json
+// @Produce json
+// @Param id path int true "Contact ID"
+// @Param body body contactRelationshipRequest true "Relationship details"
+// @Success 200 {object} contactRelationshipResponse
+// @Failure 400 {string} string "Bad Request"
+// @Failure 404 {string} string "Not Found"
+// @Failure 409 {string} string "Relationship already exists"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /contacts/add-contact-relationship/{id} [post]
+func (handler *ContactHandler) AddContactRelationship(ctx *fiber.Ctx) error {
+	contactId, err := strconv.Atoi(ctx.Params("id"))
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid contact ID")
+	}
+
+	var body contactRelationshipRequest
+	if err := ctx.BodyParser(&body); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid request body")
+	}
+
+	id, err := handler.Storage.AddContactRelationship(contactId, storage.NewRelationshipInput{
+		RelatedContactId: body.RelatedContactId,
+		Type:             body.Type,
+		Bidirectional:    body.Bidirectional,
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ctx.Status(fiber.StatusNotFound).SendString("Contact not found")
+		}
+		if errors.Is(err, storage.ErrRelationshipExists) {
+			return ctx.Status(fiber.StatusConflict).SendString(err.Error())
+		}
+		if errors.Is(err, storage.ErrInvalidRelationship) {
+			return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+		}
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	resp := contactRelationshipResponse{Id: id}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+// DeleteContactRelationship swagger
+// @Summary Remove a link between contacts
+// @Description Delete a relationship the contact takes part in
+// @Tags Contacts
+// @Accept json
+// @Produce json
+// @Param id path int true "Contact ID"
+// @Param relationshipId path int true "Relationship ID"
+// @Success 200 {object} basicResponse
+// @Failure 400 {string} string "Bad Request"
+// @Failure 404 {string} string "Not Found"
+// @Router /contacts/delete-contact-relationship/{id}/{relationshipId} [delete]
+func (handler *ContactHandler) DeleteContactRelationship(ctx *fiber.Ctx) error {
+	contactId, err := strconv.Atoi(ctx.Params("id"))
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid contact ID")
+	}
+
+	relationshipId, err := strconv.Atoi(ctx.Params("relationshipId"))
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid relationship ID")
+	}
+
+	err = handler.Storage.DeleteContactRelationship(contactId, relationshipId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ctx.Status(fiber.StatusNotFound).SendString("Relationship not found")
+		}
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	resp := basicResponse{
+		Success: true,
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+type contactDateRequest struct {
+	Type  string `json:"type" enums:"birthday,anniversary,contract_renewal"`
+	Label string `json:"label"`
+	Month int    `json:"month"`
+	Day   int    `json:"day"`
+	Year  *int   `json:"year"`
+}
+
+type contactDateResponse struct {
+	Id int `json:"id"`
+}
+
+// AddContactDate swagger
+// @Summary Add an important date to a contact
+// @Description Store a recurring birthday, anniversary or contract renewal date, the year is optional
+// @Tags Contacts
+// @Accept json
+// @Produce json
+// @Param id path int true "Contact ID"
+// @Param body body contactDateRequest true "Date details"
+// @Success 200 {object} contactDateResponse
+// @Failure 400 {string} string "Bad Request"
+// @Failure 404 {string} string "Not Found"
+// @Router /contacts/add-contact-date/{id} [post]
+func (handler *ContactHandler) AddContactDate(ctx *fiber.Ctx) error {
+	contactId, err := strconv.Atoi(ctx.Params("id"))
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid contact ID")
+	}
+
+	var body contactDateRequest
+	if err := ctx.BodyParser(&body); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid request body")
+	}
 
-	err = handler.Storage.UpdateContact(contactId, name, phone, email, address, category)
+	id, err := handler.Storage.AddContactDate(contactId, storage.NewContactDateInput{
+		Type:  body.Type,
+		Label: body.Label,
+		Month: body.Month,
+		Day:   body.Day,
+		Year:  body.Year,
+	})
 	if err != nil {
+		if err == sql.ErrNoRows {
+			return ctx.Status(fiber.StatusNotFound).SendString("Contact not found")
+		}
+		return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+
+	resp := contactDateResponse{Id: id}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+// DeleteContactDate swagger
+// @Summary Remove an important date from a contact
+// @Description Delete a date of the contact
+// @Tags Contacts
+// @Accept json
+// @Produce json
+// @Param id path int true "Contact ID"
+// @Param dateId path int true "Date ID"
+// @Success 200 {object} basicResponse
+// @Failure 400 {string} string "Bad Request"
+// @Failure 404 {string} string "Not Found"
+// @Router /contacts/delete-contact-date/{id}/{dateId} [delete]
+func (handler *ContactHandler) DeleteContactDate(ctx *fiber.Ctx) error {
+	contactId, err := strconv.Atoi(ctx.Params("id"))
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid contact ID")
+	}
+
+	dateId, err := strconv.Atoi(ctx.Params("dateId"))
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid date ID")
+	}
+
+	err = handler.Storage.DeleteContactDate(contactId, dateId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ctx.Status(fiber.StatusNotFound).SendString("Date not found")
+		}
 		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
 	}
 
 	resp := basicResponse{
 		Success: true,
 	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+type upcomingDatesResponse struct {
+	Dates []storage.UpcomingDate `json:"dates"`
+}
+
+// GetUpcomingDates swagger
+// @Summary Get upcoming important dates
+// @Description List birthdays, anniversaries and renewals occurring in the next days across all contacts, soonest first. February 29 is observed on February 28 in common years
+// @Tags Contacts
+// @Accept json
+// @Produce json
+// @Param days query int false "Number of days to look ahead, 30 by default, at most 366"
+// @Param type query string false "Only dates of this type"
+// @Param from query string false "Start date as YYYY-MM-DD, today by default"
+// @Success 200 {object} upcomingDatesResponse
+// @Failure 400 {string} string "Bad Request"
+// @Router /contacts/get-upcoming-dates [get]
+func (handler *ContactHandler) GetUpcomingDates(ctx *fiber.Ctx) error {
+	days, err := strconv.Atoi(ctx.Query("days", "30"))
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid number of days")
+	}
+
+	from := time.Now()
+	if value := ctx.Query("from", ""); value != "" {
+		from, err = time.Parse("2006-01-02", value)
+		if err != nil {
+			return ctx.Status(fiber.StatusBadRequest).SendString("Invalid from date, expected YYYY-MM-DD")
+		}
+	}
+
+	dates, err := handler.Storage.GetUpcomingDates(from, days, ctx.Query("type", ""))
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+
+	resp := upcomingDatesResponse{
+		Dates: dates,
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+type contactDuplicatesResponse struct {
+	Duplicates []storage.DuplicateMatch `json:"duplicates"`
+}
+
+// GetContactDuplicates swagger
+// @Summary Get likely duplicates of a contact
+// @Description Score other contacts against the contact on phone, email local part, name similarity and address
+// @Tags Contacts
+// @Accept json
+// @Produce json
+// @Param id path int true "Contact ID"
+// @Param threshold query number false "Minimum score between 0 and 1, 0.5 by default"
+// @Success 200 {object} contactDuplicatesResponse
+// @Failure 400 {string} string "Bad Request"
+// @Router /contacts/get-contact-duplicates/{id} [get]
+func (handler *ContactHandler) GetContactDuplicates(ctx *fiber.Ctx) error {
+	contactId, err := strconv.Atoi(ctx.Params("id"))
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid contact ID")
+	}
+
+	threshold, err := strconv.ParseFloat(ctx.Query("threshold", "0.5"), 64)
+	if err != nil || threshold < 0 || threshold > 1 {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid threshold, expected a number between 0 and 1")
+	}
+
+	duplicates, err := handler.Storage.FindContactDuplicates(contactId, threshold)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
 
+	resp := contactDuplicatesResponse{
+		Duplicates: duplicates,
+	}
 	return ctx.Status(fiber.StatusOK).JSON(resp)
 }
+
+type duplicateClustersResponse struct {
+	Clusters []storage.DuplicateCluster `json:"clusters"`
+}
+
+// GetDuplicates swagger
+// @Summary Get candidate duplicate clusters
+// @Description Group contacts that look like the same person into clusters with pair scores, highest score first
+// @Tags Contacts
+// @Accept json
+// @Produce json
+// @Param threshold query number false "Minimum pair score between 0 and 1, 0.5 by default"
+// @Success 200 {object} duplicateClustersResponse
+// @Failure 400 {string} string "Bad Request"
+// @Router /contacts/get-duplicates [get]
+func (handler *ContactHandler) GetDuplicates(ctx *fiber.Ctx) error {
+	threshold, err := strconv.ParseFloat(ctx.Query("threshold", "0.5"), 64)
+	if err != nil || threshold < 0 || threshold > 1 {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid threshold, expected a number between 0 and 1")
+	}
+
+	clusters, err := handler.Storage.FindDuplicateClusters(threshold)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	resp := duplicateClustersResponse{
+		Clusters: clusters,
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+type mergeContactsRequest struct {
+	PrimaryId    int            `json:"primary_id"`
+	SecondaryIds []int          `json:"secondary_ids"`
+	Fields       map[string]int `json:"fields"`
+}
+
+// MergeContacts swagger
+// @Summary Merge duplicate contacts
+// @Description Merge the secondary contacts into the primary one. Fields maps name, phone, email, address, category, organization, location or custom_fields to the id of the contact whose value is kept, the primary contact's value is kept otherwise. Contact methods, activities, tasks, dates and relationships move to the primary contact and the secondaries are deleted
+// @Tags Contacts
+// @Accept json
+// @Produce json
+// @Param body body mergeContactsRequest true "Contacts to merge and field choices"
+// @Success 200 {object} fetchContactResponse
+// @Failure 400 {string} string "Bad Request"
+// @Failure 404 {string} string "Not Found"
+// @Router /contacts/merge [post]
+func (handler *ContactHandler) MergeContacts(ctx *fiber.Ctx) error {
+	var body mergeContactsRequest
+	if err := ctx.BodyParser(&body); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid request body")
+	}
+
+	err := handler.Storage.MergeContacts(storage.MergeContactsInput{
+		PrimaryId:    body.PrimaryId,
+		SecondaryIds: body.SecondaryIds,
+		Fields:       body.Fields,
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ctx.Status(fiber.StatusNotFound).SendString("Contact not found")
+		}
+		return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+
+	contact, err := handler.Storage.GetContact(body.PrimaryId)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	resp := fetchContactResponse{
+		Contact: contact,
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+// ImportContacts swagger
+// @Summary Import contacts from CSV
+// @Description Validate a CSV file of contacts and, unless it is a dry run, insert the valid rows in one transaction. The report lists row errors and rows sharing a phone with existing contacts
+// @Tags Contacts
+// @Accept multipart/form-data
+// @Produce json
+// @Param file formData file true "CSV file with a header row"
+// @Param mapping formData string false "JSON object mapping column headers to name, phone, email, address, category, job_title or cf.<key>; columns named after a field are mapped when omitted"
+// @Param category_strategy formData string false "match (default) only accepts existing categories, create adds missing ones, default uses default_category for unknown ones"
+// @Param default_category formData string false "Category for rows without one"
+// @Param skip_duplicates formData bool false "Leave out rows sharing a phone with an existing contact"
+// @Param dry_run formData bool false "Only validate and report"
+// @Success 200 {object} storage.ImportReport
+// @Failure 400 {string} string "Bad Request"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /contacts/import [post]
+func (handler *ContactHandler) ImportContacts(ctx *fiber.Ctx) error {
+	fileHeader, err := ctx.FormFile("file")
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("File is required")
+	}
+
+	var mapping map[string]string
+	if value := ctx.FormValue("mapping"); value != "" {
+		if err := json.Unmarshal([]byte(value), &mapping); err != nil {
+			return ctx.Status(fiber.StatusBadRequest).SendString("Invalid mapping, expected a JSON object of column to field")
+		}
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid file")
+	}
+	defer file.Close()
+
+	records, err := importer.ParseCSV(file, mapping)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+
+	report, err := handler.Storage.ImportContacts(records, importOptions(ctx))
+	if err != nil {
+		if errors.Is(err, storage.ErrInvalidImportOptions) {
+			return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+		}
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(report)
+}
+
+// importOptions reads the import options shared by the import endpoints from the form.
+func importOptions(ctx *fiber.Ctx) storage.ImportOptions {
+	skipDuplicates, _ := strconv.ParseBool(ctx.FormValue("skip_duplicates"))
+	dryRun, _ := strconv.ParseBool(ctx.FormValue("dry_run"))
+
+	return storage.ImportOptions{
+		CategoryStrategy: ctx.FormValue("category_strategy"),
+		DefaultCategory:  ctx.FormValue("default_category"),
+		SkipDuplicates:   skipDuplicates,
+		DryRun:           dryRun,
+	}
+}
+
+// ExportContacts swagger
+// @Summary Export contacts as CSV or XLSX
+// @Description Stream the contacts matching the same filters as get-contacts as a file with a header row, ordered by id
+// @Tags Contacts
+// @Produce text/csv
+// @Produce application/vnd.openxmlformats-officedocument.spreadsheetml.sheet
+// @Param format query string false "csv (default) or xlsx"
+// @Param columns query string false "Comma separated columns among id, name, phone, email, address, category, organization, job_title, latitude, longitude, last_contacted_at, created_at and cf.<key>; name, phone, email, address, category by default"
+// @Param name query string false "Filter by contact name"
+// @Param phone query string false "Filter by any of the contact phones"
+// @Param email query string false "Filter by any of the contact emails"
+// @Param category query string false "Filter by category label"
+// @Param organization query string false "Filter by organization name"
+// @Param city query string false "Filter by address city"
+// @Param region query string false "Filter by address region"
+// @Param country query string false "Filter by address country"
+// @Param near query string false "Only contacts near this point, as lat,lng"
+// @Param radius query number false "Search radius around near in km (5 default)"
+// @Param cf.key query string false "Filter by custom field value, e.g. cf.tax_id=123"
+// @Param filter query string false "Filter expression, e.g. created_at > 2024-01-01 and not (city = ...); text values are double quoted"
+// @Success 200 {file} file
+// @Failure 400 {string} string "Bad Request"
+// @Router /contacts/export [get]
+func (handler *ContactHandler) ExportContacts(ctx *fiber.Ctx) error {
+	format := ctx.Query("format", exporter.FormatCSV)
+	if format != exporter.FormatCSV && format != exporter.FormatXLSX {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid format, expected csv or xlsx")
+	}
+
+	filter, err := storage.NewContactFilter(ctx.Queries())
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+
+	var columns []string
+	if value := ctx.Query("columns", ""); value != "" {
+		for _, column := range strings.Split(value, ",") {
+			columns = append(columns, strings.TrimSpace(column))
+		}
+	}
+
+	export, err := handler.Storage.ExportContacts(filter, columns)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+
+	ctx.Set(fiber.HeaderContentType, exporter.ContentType(format))
+	ctx.Set(fiber.HeaderContentDisposition, `attachment; filename="contacts.`+format+`"`)
+
+	// The body is written after the handler returns, once headers are sent,
+	// so errors past this point can only cut the file short.
+	stream := newExportStream(ctx)
+	ctx.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer export.Close()
+		stream.progress()
+
+		writer, err := exporter.NewRowWriter(format, w)
+		if err != nil {
+			stream.fail("contacts", err)
+			return
+		}
+		if err := writer.Write(export.Columns); err != nil {
+			stream.fail("contacts", err)
+			return
+		}
+
+		for count := 1; export.Next(); count++ {
+			row, err := export.Row()
+			if err != nil {
+				stream.fail("contacts", err)
+				return
+			}
+			if err := writer.Write(row); err != nil {
+				stream.fail("contacts", err)
+				return
+			}
+			if count%exportFlushRows == 0 {
+				if err := w.Flush(); err != nil {
+					return
+				}
+				stream.progress()
+			}
+		}
+		if err := export.Err(); err != nil {
+			stream.fail("contacts", err)
+			return
+		}
+
+		if err := writer.Close(); err != nil {
+			stream.fail("contacts", err)
+		}
+	})
+
+	return nil
+}
+
+// GetContactVCard swagger
+// @Summary Get a contact as a vCard
+// @Description Download a contact as a vCard with its phones, emails, addresses and category
+// @Tags Contacts
+// @Produce text/vcard
+// @Param id path int true "Contact ID"
+// @Param version query string false "vCard version, 3.0 (default) or 4.0"
+// @Success 200 {string} string "vCard"
+// @Failure 400 {string} string "Bad Request"
+// @Failure 404 {string} string "Not Found"
+// @Router /contacts/{id}.vcf [get]
+func (handler *ContactHandler) GetContactVCard(ctx *fiber.Ctx) error {
+	contactId, err := strconv.Atoi(ctx.Params("id"))
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid contact ID")
+	}
+
+	version := ctx.Query("version", vcard.Version3)
+	if version != vcard.Version3 && version != vcard.Version4 {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid version, expected 3.0 or 4.0")
+	}
+
+	contact, err := handler.Storage.GetContact(contactId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ctx.Status(fiber.StatusNotFound).SendString("Contact not found")
+		}
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	var card strings.Builder
+	if err := vcard.Encode(&card, contact, version); err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	ctx.Set(fiber.HeaderContentType, vcard.ContentType)
+	ctx.Set(fiber.HeaderContentDisposition, `attachment; filename="contact-`+strconv.Itoa(contactId)+`.vcf"`)
+	return ctx.Status(fiber.StatusOK).SendString(card.String())
+}
+
+// ExportContactsVCard swagger
+// @Summary Export contacts as vCards
+// @Description Stream the contacts matching the same filters as get-contacts as a single file of vCards
+// @Tags Contacts
+// @Produce text/vcard
+// @Param version query string false "vCard version, 3.0 (default) or 4.0"
+// @Param name query string false "Filter by contact name"
+// @Param phone query string false "Filter by any of the contact phones"
+// @Param email query string false "Filter by any of the contact emails"
+// @Param category query string false "Filter by category label"
+// @Param organization query string false "Filter by organization name"
+// @Param city query string false "Filter by address city"
+// @Param region query string false "Filter by address region"
+// @Param country query string false "Filter by address country"
+// @Param near query string false "Only contacts near this point, as lat,lng"
+// @Param radius query number false "Search radius around near in km (5 default)"
+// @Param cf.key query string false "Filter by custom field value, e.g. cf.tax_id=123"
+// @Param filter query string false "Filter expression, e.g. created_at > 2024-01-01 and not (city = ...); text values are double quoted"
+// @Success 200 {string} string "vCards"
+// @Failure 400 {string} string "Bad Request"
+// @Router /contacts/export-vcard [get]
+func (handler *ContactHandler) ExportContactsVCard(ctx *fiber.Ctx) error {
+	version := ctx.Query("version", vcard.Version3)
+	if version != vcard.Version3 && version != vcard.Version4 {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid version, expected 3.0 or 4.0")
+	}
+
+	filter, err := storage.NewContactFilter(ctx.Queries())
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+
+	// Contacts are read a page at a time by id; the first page is read here so
+	// a failing query is still reported with an error status.
+	contacts, err := handler.Storage.ExportContactPage(filter, 0, exportFlushRows)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	ctx.Set(fiber.HeaderContentType, vcard.ContentType)
+	ctx.Set(fiber.HeaderContentDisposition, `attachment; filename="contacts.vcf"`)
+
+	stream := newExportStream(ctx)
+	ctx.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		stream.progress()
+		for len(contacts) > 0 {
+			for _, contact := range contacts {
+				if err := vcard.Encode(w, contact, version); err != nil {
+					stream.fail("vCards", err)
+					return
+				}
+			}
+			if err := w.Flush(); err != nil || len(contacts) < exportFlushRows {
+				return
+			}
+			stream.progress()
+
+			contacts, err = handler.Storage.ExportContactPage(filter, contacts[len(contacts)-1].Id, exportFlushRows)
+			if err != nil {
+				stream.fail("vCards", err)
+				return
+			}
+		}
+	})
+
+	return nil
+}
+
+// ImportContactsVCard swagger
+// @Summary Import contacts from vCards
+// @Description Validate a vCard 3.0 or 4.0 file and, unless it is a dry run, insert the valid cards in one transaction. FN, TEL, EMAIL, ADR, CATEGORIES and TITLE are imported, errors are reported by the line of each card
+// @Tags Contacts
+// @Accept multipart/form-data
+// @Produce json
+// @Param file formData file true "vCard file with one or more cards"
+// @Param category_strategy formData string false "match (default) only accepts existing categories, create adds missing ones, default uses default_category for unknown ones"
+// @Param default_category formData string false "Category for cards without one"
+// @Param skip_duplicates formData bool false "Leave out cards sharing a phone with an existing contact"
+// @Param dry_run formData bool false "Only validate and report"
+// @Success 200 {object} storage.ImportReport
+// @Failure 400 {string} string "Bad Request"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /contacts/import-vcard [post]
+func (handler *ContactHandler) ImportContactsVCard(ctx *fiber.Ctx) error {
+	fileHeader, err := ctx.FormFile("file")
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("File is required")
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid file")
+	}
+	defer file.Close()
+
+	cards, err := vcard.Decode(file)
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+	}
+
+	records := make([]storage.ImportRecord, len(cards))
+	for i, card := range cards {
+		records[i] = vcard.ToImportRecord(card)
+	}
+
+	report, err := handler.Storage.ImportContacts(records, importOptions(ctx))
+	if err != nil {
+		if errors.Is(err, storage.ErrInvalidImportOptions) {
+			return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+		}
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	return ctx.Status(fiber.StatusOK).JSON(report)
+}
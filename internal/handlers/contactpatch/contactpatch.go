@@ -0,0 +1,51 @@
+// Package contactpatch applies PATCH requests to contacts, shared by both API
+// versions so they accept the same patch formats and fail the same way.
+package contactpatch
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/utah1280/backend-internship-2024/internal/jsonpatch"
+	"github.com/utah1280/backend-internship-2024/internal/storage"
+)
+
+// Apply applies the JSON Patch or merge patch in the request body to a
+// contact, chosen by the request Content-Type, and advertises both formats
+// in Accept-Patch. On failure it returns the response status and message,
+// otherwise fiber.StatusOK.
+func Apply(ctx *fiber.Ctx, contacts *storage.ContactStorage, id int) (int, string) {
+	ctx.Set("Accept-Patch", jsonpatch.MergePatchType+", "+jsonpatch.JSONPatchType)
+
+	apply, ok := jsonpatch.ForContentType(ctx.Get(fiber.HeaderContentType))
+	if !ok {
+		return fiber.StatusUnsupportedMediaType, "Unsupported patch format, expected " + jsonpatch.MergePatchType + " or " + jsonpatch.JSONPatchType
+	}
+
+	body := ctx.Body()
+	if len(body) == 0 {
+		return fiber.StatusBadRequest, "Missing patch body"
+	}
+
+	var patchErr error
+	err := contacts.PatchContact(id, func(document []byte) ([]byte, error) {
+		patched, err := apply(document, body)
+		patchErr = err
+		return patched, err
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return fiber.StatusNotFound, "Contact not found"
+		}
+		if errors.Is(err, jsonpatch.ErrTestFailed) || errors.Is(err, storage.ErrEmailExists) {
+			return fiber.StatusConflict, err.Error()
+		}
+		if patchErr != nil || errors.Is(err, storage.ErrInvalidContact) {
+			return fiber.StatusBadRequest, err.Error()
+		}
+		return fiber.StatusInternalServerError, err.Error()
+	}
+
+	return fiber.StatusOK, ""
+}
@@ -0,0 +1,276 @@
+package organization
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/utah1280/backend-internship-2024/internal/storage"
+)
+
+type OrganizationHandler struct {
+	Storage  *storage.OrganizationStorage
+	Contacts *storage.ContactStorage
+}
+
+func NewOrganizationHandler(storage *storage.OrganizationStorage, contacts *storage.ContactStorage) *OrganizationHandler {
+	return &OrganizationHandler{Storage: storage, Contacts: contacts}
+}
+
+type basicResponse struct {
+	Success bool `json:"success"`
+}
+
+type organizationRequest struct {
+	Name    string `json:"name"`
+	TaxId   string `json:"tax_id"`
+	Website string `json:"website"`
+	Address string `json:"address"`
+}
+
+type organizationResponse struct {
+	Id int `json:"id"`
+}
+
+// AddOrganization swagger
+// @Summary Create a new organization
+// @Description Create a new organization with the given details
+// @Tags Organizations
+// @Accept json
+// @Produce json
+// @Param body body organizationRequest true "Organization details"
+// @Success 200 {object} organizationResponse
+// @Failure 400 {string} string "Bad Request"
+// @Failure 409 {string} string "Tax ID already exists"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /organizations/add-organization [post]
+func (handler *OrganizationHandler) AddOrganization(ctx *fiber.Ctx) error {
+	var body organizationRequest
+
+	if err := ctx.BodyParser(&body); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid request body")
+	}
+
+	id, err := handler.Storage.AddOrganization(storage.NewOrganizationInput{
+		Name:    body.Name,
+		TaxId:   body.TaxId,
+		Website: body.Website,
+		Address: body.Address,
+	})
+	if err != nil {
+		if errors.Is(err, storage.ErrOrganizationExists) {
+			return ctx.Status(fiber.StatusConflict).SendString(err.Error())
+		}
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	resp := organizationResponse{Id: id}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+type organizationListResponse struct {
+	Organizations []storage.Organization `json:"organizations"`
+}
+
+// GetOrganizations swagger
+// @Summary Get list of organizations
+// @Description Retrieve a list of organizations with optional search by name or tax ID
+// @Tags Organizations
+// @Accept json
+// @Produce json
+// @Param limit query int false "Limit results per page"
+// @Param offset query int false "Offset results for pagination"
+// @Param search query string false "Search by organization name or tax ID"
+// @Success 200 {object} organizationListResponse
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /organizations/get-organizations [get]
+func (handler *OrganizationHandler) GetOrganizations(ctx *fiber.Ctx) error {
+	limit, err := strconv.Atoi(ctx.Query("limit", "10"))
+	if err != nil {
+		limit = 10
+	}
+
+	offset, err := strconv.Atoi(ctx.Query("offset", "0"))
+	if err != nil {
+		offset = 0
+	}
+
+	organizations, err := handler.Storage.GetOrganizations(limit, offset, ctx.Query("search", ""))
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	resp := organizationListResponse{
+		Organizations: organizations,
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+type fetchOrganizationResponse struct {
+	Organization storage.Organization `json:"organization"`
+}
+
+// GetOrganization swagger
+// @Summary Get an organization by ID
+// @Description Retrieve details of an organization based on the provided ID
+// @Tags Organizations
+// @Accept json
+// @Produce json
+// @Param id path int true "Organization ID"
+// @Success 200 {object} fetchOrganizationResponse
+// @Failure 400 {string} string "Bad Request"
+// @Failure 404 {string} string "Not Found"
+// @Router /organizations/get-organization/{id} [get]
+func (handler *OrganizationHandler) GetOrganization(ctx *fiber.Ctx) error {
+	organizationId, err := strconv.Atoi(ctx.Params("id"))
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid organization ID")
+	}
+
+	organization, err := handler.Storage.GetOrganization(organizationId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ctx.Status(fiber.StatusNotFound).SendString("Organization not found")
+		}
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	resp := fetchOrganizationResponse{
+		Organization: organization,
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+type updateOrganizationRequest struct {
+	Name    *string `json:"name"`
+	TaxId   *string `json:"tax_id"`
+	Website *string `json:"website"`
+	Address *string `json:"address"`
+}
+
+// UpdateOrganization swagger
+// @Summary Update an organization
+// @Description Update organization details, omitted fields are left unchanged
+// @Tags Organizations
+// @Accept json
+// @Produce json
+// @Param id path int true "Organization ID"
+// @Param body body updateOrganizationRequest true "Organization details"
+// @Success 200 {object} basicResponse
+// @Failure 400 {string} string "Bad Request"
+// @Failure 404 {string} string "Not Found"
+// @Failure 409 {string} string "Tax ID already exists"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /organizations/update-organization/{id} [patch]
+func (handler *OrganizationHandler) UpdateOrganization(ctx *fiber.Ctx) error {
+	organizationId, err := strconv.Atoi(ctx.Params("id"))
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid organization ID")
+	}
+
+	var body updateOrganizationRequest
+	if err := ctx.BodyParser(&body); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid request body")
+	}
+
+	err = handler.Storage.UpdateOrganization(organizationId, storage.UpdateOrganizationInput{
+		Name:    body.Name,
+		TaxId:   body.TaxId,
+		Website: body.Website,
+		Address: body.Address,
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ctx.Status(fiber.StatusNotFound).SendString("Organization not found")
+		}
+		if errors.Is(err, storage.ErrOrganizationExists) {
+			return ctx.Status(fiber.StatusConflict).SendString(err.Error())
+		}
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	resp := basicResponse{Success: true}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+// DeleteOrganization swagger
+// @Summary Delete organization
+// @Description Delete organization with the given id, its contacts are kept and unlinked
+// @Tags Organizations
+// @Accept json
+// @Produce json
+// @Param id path int true "Organization ID"
+// @Success 200 {object} basicResponse
+// @Failure 400 {string} string "Bad Request"
+// @Failure 404 {string} string "Not Found"
+// @Router /organizations/delete-organization/{id} [delete]
+func (handler *OrganizationHandler) DeleteOrganization(ctx *fiber.Ctx) error {
+	organizationId, err := strconv.Atoi(ctx.Params("id"))
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid organization ID")
+	}
+
+	err = handler.Storage.DeleteOrganization(organizationId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ctx.Status(fiber.StatusNotFound).SendString("Organization not found")
+		}
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	resp := basicResponse{Success: true}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+type organizationContactsResponse struct {
+	Contacts []storage.Contact_ `json:"contacts"`
+}
+
+// GetOrganizationContacts swagger
+// @Summary Get the people of an organization
+// @Description Retrieve the contacts linked to an organization
+// @Tags Organizations
+// @Accept json
+// @Produce json
+// @Param id path int true "Organization ID"
+// @Param limit query int false "Limit results per page"
+// @Param offset query int false "Offset results for pagination"
+// @Success 200 {object} organizationContactsResponse
+// @Failure 400 {string} string "Bad Request"
+// @Failure 404 {string} string "Not Found"
+// @Router /organizations/get-organization-contacts/{id} [get]
+func (handler *OrganizationHandler) GetOrganizationContacts(ctx *fiber.Ctx) error {
+	organizationId, err := strconv.Atoi(ctx.Params("id"))
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid organization ID")
+	}
+
+	limit, err := strconv.Atoi(ctx.Query("limit", "10"))
+	if err != nil {
+		limit = 10
+	}
+
+	offset, err := strconv.Atoi(ctx.Query("offset", "0"))
+	if err != nil {
+		offset = 0
+	}
+
+	if _, err := handler.Storage.GetOrganization(organizationId); err != nil {
+		if err == sql.ErrNoRows {
+			return ctx.Status(fiber.StatusNotFound).SendString("Organization not found")
+		}
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	filter := storage.ContactFilter{OrganizationId: organizationId}
+	contacts, err := handler.Contacts.GetContacts(limit, offset, filter, "name", "ASC")
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	resp := organizationContactsResponse{
+		Contacts: contacts,
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
@@ -0,0 +1,352 @@
+package task
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/utah1280/backend-internship-2024/internal/storage"
+)
+
+type TaskHandler struct {
+	Storage *storage.TaskStorage
+}
+
+func NewTaskHandler(storage *storage.TaskStorage) *TaskHandler {
+	return &TaskHandler{Storage: storage}
+}
+
+type basicResponse struct {
+	Success bool `json:"success"`
+}
+
+type taskRequest struct {
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	DueAt       time.Time `json:"due_at"`
+	Assignee    string    `json:"assignee"`
+}
+
+type taskResponse struct {
+	Id int `json:"id"`
+}
+
+// AddTask swagger
+// @Summary Add a follow-up task to a contact
+// @Description Create a task with a due date, optionally assigned to a user
+// @Tags Tasks
+// @Accept json
+// @Produce json
+// @Param id path int true "Contact ID"
+// @Param body body taskRequest true "Task details"
+// @Success 200 {object} taskResponse
+// @Failure 400 {string} string "Bad Request"
+// @Failure 404 {string} string "Not Found"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /contacts/add-contact-task/{id} [post]
+func (handler *TaskHandler) AddTask(ctx *fiber.Ctx) error {
+	contactId, err := strconv.Atoi(ctx.Params("id"))
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid contact ID")
+	}
+
+	var body taskRequest
+	if err := ctx.BodyParser(&body); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid request body")
+	}
+
+	id, err := handler.Storage.AddTask(contactId, storage.NewTaskInput{
+		Title:       body.Title,
+		Description: body.Description,
+		DueAt:       body.DueAt,
+		Assignee:    body.Assignee,
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ctx.Status(fiber.StatusNotFound).SendString("Contact not found")
+		}
+		if errors.Is(err, storage.ErrInvalidTask) {
+			return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+		}
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	resp := taskResponse{Id: id}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+type taskListResponse struct {
+	Tasks []storage.Task `json:"tasks"`
+}
+
+// GetContactTasks swagger
+// @Summary Get tasks of a contact
+// @Description Retrieve the tasks of a contact ordered by due date
+// @Tags Tasks
+// @Accept json
+// @Produce json
+// @Param id path int true "Contact ID"
+// @Param status query string false "Only tasks with this status"
+// @Success 200 {object} taskListResponse
+// @Failure 400 {string} string "Bad Request"
+// @Router /contacts/get-contact-tasks/{id} [get]
+func (handler *TaskHandler) GetContactTasks(ctx *fiber.Ctx) error {
+	contactId, err := strconv.Atoi(ctx.Params("id"))
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid contact ID")
+	}
+
+	filter := storage.TaskFilter{
+		ContactId: contactId,
+		Status:    ctx.Query("status", ""),
+	}
+
+	tasks, err := handler.Storage.GetTasks(0, 0, filter)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	resp := taskListResponse{
+		Tasks: tasks,
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+// GetTasks swagger
+// @Summary Get list of tasks
+// @Description Retrieve tasks across all contacts ordered by due date
+// @Tags Tasks
+// @Accept json
+// @Produce json
+// @Param limit query int false "Limit results per page"
+// @Param offset query int false "Offset results for pagination"
+// @Param assignee query string false "Only tasks assigned to this user"
+// @Param status query string false "Only tasks with this status"
+// @Param overdue query bool false "Only open tasks past their due date"
+// @Success 200 {object} taskListResponse
+// @Router /tasks/get-tasks [get]
+func (handler *TaskHandler) GetTasks(ctx *fiber.Ctx) error {
+	limit, err := strconv.Atoi(ctx.Query("limit", "10"))
+	if err != nil {
+		limit = 10
+	}
+
+	offset, err := strconv.Atoi(ctx.Query("offset", "0"))
+	if err != nil {
+		offset = 0
+	}
+
+	filter := storage.TaskFilter{
+		Assignee: ctx.Query("assignee", ""),
+		Status:   ctx.Query("status", ""),
+		Overdue:  ctx.QueryBool("overdue", false),
+	}
+
+	tasks, err := handler.Storage.GetTasks(limit, offset, filter)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	resp := taskListResponse{
+		Tasks: tasks,
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+// GetOverdueTasks swagger
+// @Summary Get overdue tasks
+// @Description Retrieve open and in progress tasks past their due date, oldest first
+// @Tags Tasks
+// @Accept json
+// @Produce json
+// @Param limit query int false "Limit results per page"
+// @Param offset query int false "Offset results for pagination"
+// @Param assignee query string false "Only tasks assigned to this user"
+// @Success 200 {object} taskListResponse
+// @Router /tasks/get-overdue-tasks [get]
+func (handler *TaskHandler) GetOverdueTasks(ctx *fiber.Ctx) error {
+	limit, err := strconv.Atoi(ctx.Query("limit", "10"))
+	if err != nil {
+		limit = 10
+	}
+
+	offset, err := strconv.Atoi(ctx.Query("offset", "0"))
+	if err != nil {
+		offset = 0
+	}
+
+	filter := storage.TaskFilter{
+		Assignee: ctx.Query("assignee", ""),
+		Overdue:  true,
+	}
+
+	tasks, err := handler.Storage.GetTasks(limit, offset, filter)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	resp := taskListResponse{
+		Tasks: tasks,
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+type fetchTaskResponse struct {
+	Task storage.Task `json:"task"`
+}
+
+// GetTask swagger
+// @Summary Get a task by ID
+// @Description Retrieve details of a task based on the provided ID
+// @Tags Tasks
+// @Accept json
+// @Produce json
+// @Param id path int true "Task ID"
+// @Success 200 {object} fetchTaskResponse
+// @Failure 400 {string} string "Bad Request"
+// @Failure 404 {string} string "Not Found"
+// @Router /tasks/get-task/{id} [get]
+func (handler *TaskHandler) GetTask(ctx *fiber.Ctx) error {
+	taskId, err := strconv.Atoi(ctx.Params("id"))
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid task ID")
+	}
+
+	task, err := handler.Storage.GetTask(taskId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ctx.Status(fiber.StatusNotFound).SendString("Task not found")
+		}
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	resp := fetchTaskResponse{
+		Task: task,
+	}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+type updateTaskRequest struct {
+	Title       *string    `json:"title"`
+	Description *string    `json:"description"`
+	DueAt       *time.Time `json:"due_at"`
+	Assignee    *string    `json:"assignee"`
+}
+
+// UpdateTask swagger
+// @Summary Update a task
+// @Description Update task details, omitted fields are left unchanged. A new due date re-arms the reminder
+// @Tags Tasks
+// @Accept json
+// @Produce json
+// @Param id path int true "Task ID"
+// @Param body body updateTaskRequest true "Task details"
+// @Success 200 {object} basicResponse
+// @Failure 400 {string} string "Bad Request"
+// @Failure 404 {string} string "Not Found"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /tasks/update-task/{id} [patch]
+func (handler *TaskHandler) UpdateTask(ctx *fiber.Ctx) error {
+	taskId, err := strconv.Atoi(ctx.Params("id"))
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid task ID")
+	}
+
+	var body updateTaskRequest
+	if err := ctx.BodyParser(&body); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid request body")
+	}
+
+	err = handler.Storage.UpdateTask(taskId, storage.UpdateTaskInput{
+		Title:       body.Title,
+		Description: body.Description,
+		DueAt:       body.DueAt,
+		Assignee:    body.Assignee,
+	})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ctx.Status(fiber.StatusNotFound).SendString("Task not found")
+		}
+		if errors.Is(err, storage.ErrInvalidTask) {
+			return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+		}
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	resp := basicResponse{Success: true}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+type taskStatusRequest struct {
+	Status string `json:"status" enums:"open,in_progress,done,cancelled"`
+}
+
+// SetTaskStatus swagger
+// @Summary Change task status
+// @Description Move a task to another status. Open and in progress tasks can be finished or cancelled, finished and cancelled tasks can be reopened
+// @Tags Tasks
+// @Accept json
+// @Produce json
+// @Param id path int true "Task ID"
+// @Param body body taskStatusRequest true "New status"
+// @Success 200 {object} basicResponse
+// @Failure 400 {string} string "Bad Request"
+// @Failure 404 {string} string "Not Found"
+// @Failure 500 {string} string "Internal Server Error"
+// @Router /tasks/set-task-status/{id} [patch]
+func (handler *TaskHandler) SetTaskStatus(ctx *fiber.Ctx) error {
+	taskId, err := strconv.Atoi(ctx.Params("id"))
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid task ID")
+	}
+
+	var body taskStatusRequest
+	if err := ctx.BodyParser(&body); err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid request body")
+	}
+
+	err = handler.Storage.SetTaskStatus(taskId, body.Status)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ctx.Status(fiber.StatusNotFound).SendString("Task not found")
+		}
+		if errors.Is(err, storage.ErrInvalidTask) {
+			return ctx.Status(fiber.StatusBadRequest).SendString(err.Error())
+		}
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	resp := basicResponse{Success: true}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
+
+// DeleteTask swagger
+// @Summary Delete task
+// @Description Delete task with the given id
+// @Tags Tasks
+// @Accept json
+// @Produce json
+// @Param id path int true "Task ID"
+// @Success 200 {object} basicResponse
+// @Failure 400 {string} string "Bad Request"
+// @Failure 404 {string} string "Not Found"
+// @Router /tasks/delete-task/{id} [delete]
+func (handler *TaskHandler) DeleteTask(ctx *fiber.Ctx) error {
+	taskId, err := strconv.Atoi(ctx.Params("id"))
+	if err != nil {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Invalid task ID")
+	}
+
+	err = handler.Storage.DeleteTask(taskId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ctx.Status(fiber.StatusNotFound).SendString("Task not found")
+		}
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+
+	resp := basicResponse{Success: true}
+	return ctx.Status(fiber.StatusOK).JSON(resp)
+}
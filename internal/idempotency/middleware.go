@@ -0,0 +1,212 @@
+// Package idempotency makes write requests safe to retry. A POST, PATCH or
+// DELETE sent with an Idempotency-Key header runs once per key and
+// principal; retries get the stored response until the key expires.
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"os"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/utah1280/backend-internship-2024/internal/storage"
+	"go.uber.org/fx"
+)
+
+const (
+	// HeaderKey is the request header carrying the idempotency key.
+	HeaderKey = "Idempotency-Key"
+	// HeaderReplayed marks responses replayed from a stored one.
+	HeaderReplayed = "Idempotent-Replayed"
+	// HeaderClient identifies the calling client; without it requests are
+	// grouped by IP address.
+	HeaderClient = "X-Client-Id"
+
+	defaultTTL          = 24 * time.Hour
+	defaultClaimTimeout = 5 * time.Minute
+	maxKeyLength        = 255
+	purgeInterval       = time.Hour
+)
+
+// replayedHeaders are the response headers stored and replayed along with
+// the status and body.
+var replayedHeaders = []string{
+	fiber.HeaderLocation,
+	fiber.HeaderETag,
+	fiber.HeaderLink,
+	"Deprecation",
+	"Sunset",
+}
+
+// Middleware stores the first response to each idempotency key and replays it.
+type Middleware struct {
+	Storage *storage.IdempotencyStorage
+	// TTL is how long responses are kept, IDEMPOTENCY_TTL (e.g. "12h") or 24 hours.
+	TTL time.Duration
+	// ClaimTimeout is how long a request may run before a retry with its key
+	// runs it again, IDEMPOTENCY_CLAIM_TIMEOUT or 5 minutes. It must exceed
+	// the longest request, or a slow request can run twice.
+	ClaimTimeout time.Duration
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func NewMiddleware(lc fx.Lifecycle, storage *storage.IdempotencyStorage) *Middleware {
+	middleware := &Middleware{
+		Storage:      storage,
+		TTL:          durationEnv("IDEMPOTENCY_TTL", defaultTTL),
+		ClaimTimeout: durationEnv("IDEMPOTENCY_CLAIM_TIMEOUT", defaultClaimTimeout),
+	}
+	if middleware.ClaimTimeout > middleware.TTL {
+		log.Printf("IDEMPOTENCY_CLAIM_TIMEOUT exceeds IDEMPOTENCY_TTL, using %s", middleware.TTL)
+		middleware.ClaimTimeout = middleware.TTL
+	}
+
+	lc.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			runCtx, cancel := context.WithCancel(context.Background())
+			middleware.cancel = cancel
+			middleware.done = make(chan struct{})
+			go middleware.purge(runCtx)
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			middleware.cancel()
+			select {
+			case <-middleware.done:
+			case <-ctx.Done():
+			}
+			return nil
+		},
+	})
+
+	return middleware
+}
+
+// durationEnv reads a positive duration such as "12h" from an environment
+// variable, falling back to a default.
+func durationEnv(name string, fallback time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		log.Printf("Invalid %s '%s', using %s", name, value, fallback)
+		return fallback
+	}
+	return duration
+}
+
+// purge periodically deletes expired keys.
+func (middleware *Middleware) purge(ctx context.Context) {
+	defer close(middleware.done)
+
+	ticker := time.NewTicker(purgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := middleware.Storage.PurgeExpiredKeys(); err != nil {
+				log.Printf("Error purging idempotency keys: %v", err)
+			}
+		}
+	}
+}
+
+func principal(ctx *fiber.Ctx) string {
+	if client := ctx.Get(HeaderClient); client != "" {
+		return "client:" + client
+	}
+	return "ip:" + ctx.IP()
+}
+
+// fingerprint identifies a request by method, path, query and body.
+func fingerprint(ctx *fiber.Ctx) string {
+	hash := sha256.New()
+	hash.Write([]byte(ctx.Method() + " " + ctx.Path() + "?"))
+	hash.Write(ctx.Request().URI().QueryString())
+	hash.Write([]byte("\n"))
+	hash.Write(ctx.Body())
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Handler runs write requests with an idempotency key at most once. A key
+// reused with another request gets 422, one whose request is still running
+// gets 409. Server errors are not stored so the request can be retried.
+func (middleware *Middleware) Handler(ctx *fiber.Ctx) error {
+	method := ctx.Method()
+	if method != fiber.MethodPost && method != fiber.MethodPatch && method != fiber.MethodDelete {
+		return ctx.Next()
+	}
+	key := ctx.Get(HeaderKey)
+	if key == "" {
+		return ctx.Next()
+	}
+	if len(key) > maxKeyLength {
+		return ctx.Status(fiber.StatusBadRequest).SendString("Idempotency key is too long")
+	}
+
+	owner := principal(ctx)
+	requestPrint := fingerprint(ctx)
+
+	record, err := middleware.Storage.ClaimKey(owner, key, requestPrint, middleware.TTL, middleware.ClaimTimeout)
+	if err != nil {
+		return ctx.Status(fiber.StatusInternalServerError).SendString(err.Error())
+	}
+	if record != nil {
+		if record.Fingerprint != requestPrint {
+			return ctx.Status(fiber.StatusUnprocessableEntity).SendString("Idempotency key was already used with a different request")
+		}
+		if !record.Completed {
+			return ctx.Status(fiber.StatusConflict).SendString("A request with this idempotency key is still in progress")
+		}
+		ctx.Set(HeaderReplayed, "true")
+		if record.ContentType != "" {
+			ctx.Set(fiber.HeaderContentType, record.ContentType)
+		}
+		for name, values := range record.Headers {
+			ctx.Response().Header.Del(name)
+			for _, value := range values {
+				ctx.Append(name, value)
+			}
+		}
+		return ctx.Status(record.Status).Send(record.Body)
+	}
+
+	if err := ctx.Next(); err != nil {
+		if releaseErr := middleware.Storage.ReleaseKey(owner, key); releaseErr != nil {
+			log.Printf("Error releasing idempotency key: %v", releaseErr)
+		}
+		return err
+	}
+
+	status := ctx.Response().StatusCode()
+	if status >= fiber.StatusInternalServerError {
+		if err := middleware.Storage.ReleaseKey(owner, key); err != nil {
+			log.Printf("Error releasing idempotency key: %v", err)
+		}
+		return nil
+	}
+
+	contentType := string(ctx.Response().Header.ContentType())
+	headers := storage.ResponseHeaders{}
+	for _, name := range replayedHeaders {
+		for _, value := range ctx.Response().Header.PeekAll(name) {
+			headers[name] = append(headers[name], string(value))
+		}
+	}
+	body := bytes.Clone(ctx.Response().Body())
+	if err := middleware.Storage.CompleteKey(owner, key, status, contentType, headers, body); err != nil {
+		log.Printf("Error saving idempotent response: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package importer
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/utah1280/backend-internship-2024/internal/storage"
+)
+
+// Fields a CSV column can be mapped to. Custom fields are mapped with their
+// key prefixed by "cf.", columns mapped to an empty field are ignored.
+var fields = []string{"name", "phone", "email", "address", "category", "job_title"}
+
+const customFieldPrefix = "cf."
+
+// normalizeHeader turns "Job Title" into "job_title" for automatic mapping.
+func normalizeHeader(header string) string {
+	header = strings.ToLower(strings.TrimSpace(header))
+	return strings.Join(strings.FieldsFunc(header, func(r rune) bool {
+		return r == ' ' || r == '-' || r == '_'
+	}), "_")
+}
+
+func validField(field string) bool {
+	if strings.HasPrefix(field, customFieldPrefix) {
+		return len(field) > len(customFieldPrefix)
+	}
+	for _, name := range fields {
+		if name == field {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseCSV reads contacts from a CSV file with a header row. Mapping maps
+// column headers to contact fields; without a mapping, columns named after a
+// field (or "cf.<key>") are mapped automatically and the others ignored.
+// Empty rows are skipped. Records carry the line they start on.
+func ParseCSV(r io.Reader, mapping map[string]string) ([]storage.ImportRecord, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("file is empty")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading header: %v", err)
+	}
+	if len(header) > 0 {
+		header[0] = strings.TrimPrefix(header[0], "\ufeff")
+	}
+
+	columns := make([]string, len(header))
+	if len(mapping) == 0 {
+		for i, name := range header {
+			field := normalizeHeader(name)
+			if strings.HasPrefix(strings.ToLower(strings.TrimSpace(name)), customFieldPrefix) {
+				field = strings.TrimSpace(name)
+			}
+			if validField(field) {
+				columns[i] = field
+			}
+		}
+	} else {
+		index := make(map[string]int, len(header))
+		for i, name := range header {
+			index[strings.TrimSpace(name)] = i
+		}
+		for name, field := range mapping {
+			i, ok := index[strings.TrimSpace(name)]
+			if !ok {
+				return nil, fmt.Errorf("column '%s' is not in the file", name)
+			}
+			if field == "" {
+				continue
+			}
+			if !validField(field) {
+				return nil, fmt.Errorf("invalid field '%s' for column '%s', expected one of %v or %s<key>", field, name, fields, customFieldPrefix)
+			}
+			columns[i] = field
+		}
+	}
+
+	mapped := false
+	for _, field := range columns {
+		if field == "name" {
+			mapped = true
+		}
+	}
+	if !mapped {
+		return nil, fmt.Errorf("no column is mapped to name")
+	}
+
+	records := []storage.ImportRecord{}
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error reading CSV: %v", err)
+		}
+		line, _ := reader.FieldPos(0)
+
+		record := storage.ImportRecord{Line: line, CustomFields: map[string]string{}}
+		empty := true
+		for i, value := range row {
+			if i >= len(columns) || columns[i] == "" {
+				continue
+			}
+			if strings.TrimSpace(value) != "" {
+				empty = false
+			}
+
+			switch field := columns[i]; field {
+			case "name":
+				record.Name = value
+			case "phone":
+				record.Phone = value
+			case "email":
+				record.Email = value
+			case "address":
+				record.Address = value
+			case "category":
+				record.Category = value
+			case "job_title":
+				record.JobTitle = value
+			default:
+				record.CustomFields[strings.TrimPrefix(field, customFieldPrefix)] = value
+			}
+		}
+		if !empty {
+			records = append(records, record)
+		}
+	}
+
+	return records, nil
+}
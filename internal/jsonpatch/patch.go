@@ -0,0 +1,294 @@
+package jsonpatch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// ErrTestFailed is returned when a test operation does not match the document.
+var ErrTestFailed = errors.New("test operation failed")
+
+type operation struct {
+	Op    string          `json:"op"`
+	Path  *string         `json:"path"`
+	From  *string         `json:"from"`
+	Value json.RawMessage `json:"value"`
+}
+
+// Apply applies a JSON Patch, a list of add, remove, replace, move, copy and
+// test operations, to a JSON document. The operations are applied in order
+// and the patch fails as a whole when one of them does.
+func Apply(document, patch []byte) ([]byte, error) {
+	var target interface{}
+	if err := json.Unmarshal(document, &target); err != nil {
+		return nil, fmt.Errorf("invalid document: %v", err)
+	}
+
+	var operations []operation
+	if err := json.Unmarshal(patch, &operations); err != nil {
+		return nil, fmt.Errorf("invalid JSON patch: %v", err)
+	}
+
+	for i, op := range operations {
+		var err error
+		target, err = op.apply(target)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d: %w", i, err)
+		}
+	}
+
+	return json.Marshal(target)
+}
+
+func (op operation) apply(document interface{}) (interface{}, error) {
+	if op.Path == nil {
+		return nil, fmt.Errorf("missing path")
+	}
+	path, err := parsePointer(*op.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var value interface{}
+	switch op.Op {
+	case "add", "replace", "test":
+		if op.Value == nil {
+			return nil, fmt.Errorf("missing value for %s", op.Op)
+		}
+		if err := json.Unmarshal(op.Value, &value); err != nil {
+			return nil, fmt.Errorf("invalid value: %v", err)
+		}
+	case "move", "copy":
+		if op.From == nil {
+			return nil, fmt.Errorf("missing from for %s", op.Op)
+		}
+		from, err := parsePointer(*op.From)
+		if err != nil {
+			return nil, err
+		}
+		if value, err = get(document, from); err != nil {
+			return nil, err
+		}
+		if op.Op == "copy" {
+			value = deepCopy(value)
+			break
+		}
+		if len(path) > len(from) && reflect.DeepEqual(path[:len(from)], from) {
+			return nil, fmt.Errorf("cannot move '%s' into itself", *op.From)
+		}
+		if document, err = remove(document, from); err != nil {
+			return nil, err
+		}
+	case "remove":
+	default:
+		return nil, fmt.Errorf("unknown operation '%s'", op.Op)
+	}
+
+	switch op.Op {
+	case "remove":
+		return remove(document, path)
+	case "replace":
+		if _, err := get(document, path); err != nil {
+			return nil, err
+		}
+		if len(path) == 0 {
+			return value, nil
+		}
+		if document, err = remove(document, path); err != nil {
+			return nil, err
+		}
+		return add(document, path, value)
+	case "test":
+		current, err := get(document, path)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(current, value) {
+			return nil, ErrTestFailed
+		}
+		return document, nil
+	default:
+		return add(document, path, value)
+	}
+}
+
+// parsePointer splits a JSON Pointer (RFC 6901) into unescaped reference tokens.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return []string{}, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("invalid pointer '%s'", pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+	}
+	return tokens, nil
+}
+
+// arrayIndex parses an array index token; "-" is only accepted when end is
+// true and refers to the position after the last element.
+func arrayIndex(token string, length int, end bool) (int, error) {
+	if token == "-" && end {
+		return length, nil
+	}
+	index, err := strconv.Atoi(token)
+	if err != nil || index < 0 || (len(token) > 1 && token[0] == '0') || token[0] == '+' {
+		return 0, fmt.Errorf("invalid array index '%s'", token)
+	}
+	limit := length - 1
+	if end {
+		limit = length
+	}
+	if index > limit {
+		return 0, fmt.Errorf("array index %d out of range", index)
+	}
+	return index, nil
+}
+
+func child(node interface{}, token string) (interface{}, error) {
+	switch container := node.(type) {
+	case map[string]interface{}:
+		value, ok := container[token]
+		if !ok {
+			return nil, fmt.Errorf("member '%s' does not exist", token)
+		}
+		return value, nil
+	case []interface{}:
+		index, err := arrayIndex(token, len(container), false)
+		if err != nil {
+			return nil, err
+		}
+		return container[index], nil
+	default:
+		return nil, fmt.Errorf("cannot reference '%s' in a scalar value", token)
+	}
+}
+
+func get(document interface{}, path []string) (interface{}, error) {
+	node := document
+	for _, token := range path {
+		var err error
+		if node, err = child(node, token); err != nil {
+			return nil, err
+		}
+	}
+	return node, nil
+}
+
+// modify replaces the container holding the last token of path with the
+// result of change, returning the new document.
+func modify(node interface{}, path []string, change func(container interface{}, token string) (interface{}, error)) (interface{}, error) {
+	if len(path) == 1 {
+		return change(node, path[0])
+	}
+
+	next, err := child(node, path[0])
+	if err != nil {
+		return nil, err
+	}
+	updated, err := modify(next, path[1:], change)
+	if err != nil {
+		return nil, err
+	}
+
+	switch container := node.(type) {
+	case map[string]interface{}:
+		container[path[0]] = updated
+	case []interface{}:
+		index, _ := arrayIndex(path[0], len(container), false)
+		container[index] = updated
+	}
+	return node, nil
+}
+
+func add(document interface{}, path []string, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	return modify(document, path, func(node interface{}, token string) (interface{}, error) {
+		switch container := node.(type) {
+		case map[string]interface{}:
+			container[token] = value
+			return container, nil
+		case []interface{}:
+			index, err := arrayIndex(token, len(container), true)
+			if err != nil {
+				return nil, err
+			}
+			container = append(container, nil)
+			copy(container[index+1:], container[index:])
+			container[index] = value
+			return container, nil
+		default:
+			return nil, fmt.Errorf("cannot add '%s' to a scalar value", token)
+		}
+	})
+}
+
+func remove(document interface{}, path []string) (interface{}, error) {
+	if len(path) == 0 {
+		return nil, fmt.Errorf("cannot remove the whole document")
+	}
+	return modify(document, path, func(node interface{}, token string) (interface{}, error) {
+		if _, err := child(node, token); err != nil {
+			return nil, err
+		}
+		switch container := node.(type) {
+		case map[string]interface{}:
+			delete(container, token)
+			return container, nil
+		default:
+			list := node.([]interface{})
+			index, _ := arrayIndex(token, len(list), false)
+			return append(list[:index], list[index+1:]...), nil
+		}
+	})
+}
+
+func deepCopy(value interface{}) interface{} {
+	switch container := value.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(container))
+		for key, item := range container {
+			copied[key] = deepCopy(item)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(container))
+		for i, item := range container {
+			copied[i] = deepCopy(item)
+		}
+		return copied
+	default:
+		return value
+	}
+}
+
+const (
+	// MergePatchType is the media type of merge patches.
+	MergePatchType = "application/merge-patch+json"
+	// JSONPatchType is the media type of JSON Patch documents.
+	JSONPatchType = "application/json-patch+json"
+)
+
+// ForContentType returns the function applying patches sent with a request
+// Content-Type: Apply for JSON Patch and MergePatch for merge patches, plain
+// JSON or no type. It returns false for other types.
+func ForContentType(contentType string) (func(document, patch []byte) ([]byte, error), bool) {
+	mediaType := strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	switch mediaType {
+	case "", "application/json", MergePatchType:
+		return MergePatch, true
+	case JSONPatchType:
+		return Apply, true
+	default:
+		return nil, false
+	}
+}
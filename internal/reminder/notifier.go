@@ -0,0 +1,71 @@
+package reminder
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Reminder is the event fired when a task on a contact becomes due.
+type Reminder struct {
+	TaskId      int       `json:"task_id"`
+	ContactId   int       `json:"contact_id"`
+	ContactName string    `json:"contact_name"`
+	Title       string    `json:"title"`
+	Assignee    string    `json:"assignee"`
+	DueAt       time.Time `json:"due_at"`
+}
+
+// Notifier delivers reminders. Implementations must be safe for concurrent use.
+type Notifier interface {
+	Notify(ctx context.Context, reminder Reminder) error
+}
+
+// LogNotifier writes reminders to the application log.
+type LogNotifier struct{}
+
+func (LogNotifier) Notify(ctx context.Context, reminder Reminder) error {
+	log.Printf("Reminder: task %d '%s' for contact %d (%s) assigned to '%s' is due since %s",
+		reminder.TaskId, reminder.Title, reminder.ContactId, reminder.ContactName, reminder.Assignee, reminder.DueAt.Format(time.RFC3339))
+	return nil
+}
+
+// FileNotifier appends reminders as JSON lines to a file.
+type FileNotifier struct {
+	Path string
+	mu   sync.Mutex
+}
+
+func (notifier *FileNotifier) Notify(ctx context.Context, reminder Reminder) error {
+	line, err := json.Marshal(reminder)
+	if err != nil {
+		return fmt.Errorf("error encoding reminder: %v", err)
+	}
+
+	notifier.mu.Lock()
+	defer notifier.mu.Unlock()
+
+	file, err := os.OpenFile(notifier.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("error opening reminder file: %v", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("error writing reminder: %v", err)
+	}
+	return nil
+}
+
+// NewNotifier picks the notifier for local runs: reminders go to the file in
+// REMINDER_FILE when it is set, and to the log otherwise.
+func NewNotifier() Notifier {
+	if path := os.Getenv("REMINDER_FILE"); path != "" {
+		return &FileNotifier{Path: path}
+	}
+	return LogNotifier{}
+}
@@ -0,0 +1,92 @@
+package reminder
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/utah1280/backend-internship-2024/internal/storage"
+	"go.uber.org/fx"
+)
+
+const (
+	pollInterval = 30 * time.Second
+	batchSize    = 100
+)
+
+// Scheduler periodically claims tasks that became due and fires a reminder for each.
+type Scheduler struct {
+	Tasks    *storage.TaskStorage
+	Notifier Notifier
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func NewScheduler(lc fx.Lifecycle, tasks *storage.TaskStorage, notifier Notifier) *Scheduler {
+	scheduler := &Scheduler{Tasks: tasks, Notifier: notifier}
+
+	lc.Append(fx.Hook{
+		OnStart: func(ctx context.Context) error {
+			runCtx, cancel := context.WithCancel(context.Background())
+			scheduler.cancel = cancel
+			scheduler.done = make(chan struct{})
+			go scheduler.run(runCtx)
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			scheduler.cancel()
+			select {
+			case <-scheduler.done:
+			case <-ctx.Done():
+			}
+			return nil
+		},
+	})
+
+	return scheduler
+}
+
+func (scheduler *Scheduler) run(ctx context.Context) {
+	defer close(scheduler.done)
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		scheduler.tick(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// tick fires reminders for due tasks. A task whose notification fails is
+// released so the next tick retries it.
+func (scheduler *Scheduler) tick(ctx context.Context) {
+	tasks, err := scheduler.Tasks.ClaimDueTasks(batchSize)
+	if err != nil {
+		log.Printf("Error claiming due tasks: %v", err)
+		return
+	}
+
+	for _, task := range tasks {
+		err := scheduler.Notifier.Notify(ctx, Reminder{
+			TaskId:      task.Id,
+			ContactId:   task.ContactId,
+			ContactName: task.ContactName,
+			Title:       task.Title,
+			Assignee:    task.Assignee,
+			DueAt:       task.DueAt,
+		})
+		if err != nil {
+			log.Printf("Error sending reminder for task %d: %v", task.Id, err)
+			if err := scheduler.Tasks.ReleaseReminder(task.Id); err != nil {
+				log.Printf("Error releasing reminder for task %d: %v", task.Id, err)
+			}
+		}
+	}
+}
@@ -9,34 +9,129 @@ import (
 	"github.com/gofiber/fiber/v2/middleware/logger"
 	"github.com/gofiber/swagger"
 	_ "github.com/utah1280/backend-internship-2024/docs"
+	"github.com/utah1280/backend-internship-2024/internal/handlers/activity"
+	"github.com/utah1280/backend-internship-2024/internal/handlers/apiv2"
+	"github.com/utah1280/backend-internship-2024/internal/handlers/carddav"
 	"github.com/utah1280/backend-internship-2024/internal/handlers/category"
 	"github.com/utah1280/backend-internship-2024/internal/handlers/contact"
+	"github.com/utah1280/backend-internship-2024/internal/handlers/organization"
+	"github.com/utah1280/backend-internship-2024/internal/handlers/task"
+	"github.com/utah1280/backend-internship-2024/internal/idempotency"
 	"go.uber.org/fx"
 )
 
-func NewFiberServer(lc fx.Lifecycle, contactHandlers *contact.ContactHandler, categoryHandlers *category.CategoryHandler) *fiber.App {
+func NewFiberServer(lc fx.Lifecycle, contactHandlers *contact.ContactHandler, categoryHandlers *category.CategoryHandler, organizationHandlers *organization.OrganizationHandler, activityHandlers *activity.ActivityHandler, taskHandlers *task.TaskHandler, cardDAVHandlers *carddav.CardDAVHandler, apiHandlers *apiv2.APIHandler, idempotencyMiddleware *idempotency.Middleware) *fiber.App {
 	app := fiber.New(fiber.Config{
-		ReadTimeout:  time.Second * 4,
-		WriteTimeout: time.Second * 4,
-		IdleTimeout:  time.Second * 60,
+		ReadTimeout:    time.Second * 4,
+		WriteTimeout:   time.Second * 4,
+		IdleTimeout:    time.Second * 60,
+		RequestMethods: append(append([]string{}, fiber.DefaultMethods...), carddav.Methods...),
 	})
 	app.Use(logger.New())
+	app.Use(idempotencyMiddleware.Handler)
 
 	app.Get("/swagger/*", swagger.HandlerDefault)
 
+	v2Group := app.Group(apiv2.Prefix)
+	v2Group.Get("/contacts", apiHandlers.ListContacts)
+	v2Group.Post("/contacts", apiHandlers.CreateContact)
+	v2Group.Get("/contacts/:id", apiHandlers.GetContact)
+	v2Group.Patch("/contacts/:id", apiHandlers.UpdateContact)
+	v2Group.Delete("/contacts/:id", apiHandlers.DeleteContact)
+	v2Group.Get("/categories", apiHandlers.ListCategories)
+	v2Group.Post("/categories", apiHandlers.CreateCategory)
+	v2Group.Get("/categories/:id", apiHandlers.GetCategory)
+	v2Group.Patch("/categories/:id", apiHandlers.UpdateCategory)
+	v2Group.Delete("/categories/:id", apiHandlers.DeleteCategory)
+	v2Group.Get("/categories/:id/contacts", apiHandlers.ListCategoryContacts)
+	v2Group.Get("/saved-searches", apiHandlers.ListSavedSearches)
+	v2Group.Post("/saved-searches", apiHandlers.CreateSavedSearch)
+	v2Group.Get("/saved-searches/counts", apiHandlers.CountSavedSearches)
+	v2Group.Get("/saved-searches/:id", apiHandlers.GetSavedSearch)
+	v2Group.Patch("/saved-searches/:id", apiHandlers.UpdateSavedSearch)
+	v2Group.Delete("/saved-searches/:id", apiHandlers.DeleteSavedSearch)
+	v2Group.Get("/saved-searches/:id/contacts", apiHandlers.RunSavedSearch)
+
 	contactGroup := app.Group("/contacts")
-	contactGroup.Post("/new-contact", contactHandlers.CreateContact)
-	contactGroup.Get("/get-contact/:id", contactHandlers.GetContact)
-	contactGroup.Delete("/delete-contact/:id", contactHandlers.DeleteContact)
-	contactGroup.Get("/get-contacts", contactHandlers.GetContacts)
-	contactGroup.Patch("/update-contact/:id", contactHandlers.UpdateContact)
+	contactGroup.Post("/new-contact", apiv2.Deprecated("/contacts"), contactHandlers.CreateContact)
+	contactGroup.Get("/get-contact/:id", apiv2.Deprecated("/contacts/:id"), contactHandlers.GetContact)
+	contactGroup.Delete("/delete-contact/:id", apiv2.Deprecated("/contacts/:id"), contactHandlers.DeleteContact)
+	contactGroup.Get("/get-contacts", apiv2.Deprecated("/contacts"), contactHandlers.GetContacts)
+	contactGroup.Patch("/update-contact/:id", apiv2.Deprecated("/contacts/:id"), contactHandlers.UpdateContact)
+	contactGroup.Put("/set-contact-methods/:id", contactHandlers.SetContactMethods)
+	contactGroup.Put("/set-contact-location/:id", contactHandlers.SetContactLocation)
+	contactGroup.Delete("/delete-contact-location/:id", contactHandlers.DeleteContactLocation)
+	contactGroup.Post("/geocode-contacts", contactHandlers.GeocodeContacts)
+	contactGroup.Put("/set-contact-organization/:id", contactHandlers.SetContactOrganization)
+	contactGroup.Post("/add-contact-relationship/:id", contactHandlers.AddContactRelationship)
+	contactGroup.Delete("/delete-contact-relationship/:id/:relationshipId", contactHandlers.DeleteContactRelationship)
+	contactGroup.Post("/add-contact-date/:id", contactHandlers.AddContactDate)
+	contactGroup.Delete("/delete-contact-date/:id/:dateId", contactHandlers.DeleteContactDate)
+	contactGroup.Get("/get-upcoming-dates", contactHandlers.GetUpcomingDates)
+	contactGroup.Get("/get-duplicates", contactHandlers.GetDuplicates)
+	contactGroup.Get("/get-contact-duplicates/:id", contactHandlers.GetContactDuplicates)
+	contactGroup.Post("/merge", contactHandlers.MergeContacts)
+	contactGroup.Post("/import", contactHandlers.ImportContacts)
+	contactGroup.Get("/export", contactHandlers.ExportContacts)
+	contactGroup.Get("/export-vcard", contactHandlers.ExportContactsVCard)
+	contactGroup.Post("/import-vcard", contactHandlers.ImportContactsVCard)
+	contactGroup.Get("/:id.vcf", contactHandlers.GetContactVCard)
+	contactGroup.Post("/bulk-create-contacts", contactHandlers.BulkCreateContacts)
+	contactGroup.Patch("/bulk-update-contacts", contactHandlers.BulkUpdateContacts)
+	contactGroup.Delete("/bulk-delete-contacts", contactHandlers.BulkDeleteContacts)
+	contactGroup.Patch("/bulk-set-category", contactHandlers.BulkSetCategory)
+	contactGroup.Post("/add-contact-activity/:id", activityHandlers.AddActivity)
+	contactGroup.Get("/get-contact-activities/:id", activityHandlers.GetActivities)
+	contactGroup.Get("/get-contact-activity/:id/:activityId", activityHandlers.GetActivity)
+	contactGroup.Patch("/update-contact-activity/:id/:activityId", activityHandlers.UpdateActivity)
+	contactGroup.Delete("/delete-contact-activity/:id/:activityId", activityHandlers.DeleteActivity)
+	contactGroup.Get("/get-contact-timeline/:id", activityHandlers.GetTimeline)
+	contactGroup.Post("/add-contact-task/:id", taskHandlers.AddTask)
+	contactGroup.Get("/get-contact-tasks/:id", taskHandlers.GetContactTasks)
 
 	categoryGroup := app.Group("/categories")
-	categoryGroup.Post("/add-category", categoryHandlers.AddCategory)
-	categoryGroup.Get("/get-categories", categoryHandlers.GetCategoryList)
-	categoryGroup.Delete("/delete-category/:id", categoryHandlers.DeleteCategory)
-	categoryGroup.Patch("/update-category/:id", categoryHandlers.UpdateCategoryLabel)
-	categoryGroup.Get("/get-category/:id", categoryHandlers.GetCategory)
+	categoryGroup.Post("/add-category", apiv2.Deprecated("/categories"), categoryHandlers.AddCategory)
+	categoryGroup.Get("/get-categories", apiv2.Deprecated("/categories"), categoryHandlers.GetCategoryList)
+	categoryGroup.Delete("/delete-category/:id", apiv2.Deprecated("/categories/:id"), categoryHandlers.DeleteCategory)
+	categoryGroup.Patch("/update-category/:id", apiv2.Deprecated("/categories/:id"), categoryHandlers.UpdateCategoryLabel)
+	categoryGroup.Get("/get-category/:id", apiv2.Deprecated("/categories/:id"), categoryHandlers.GetCategory)
+	categoryGroup.Patch("/update-category-metadata/:id", apiv2.Deprecated("/categories/:id"), categoryHandlers.UpdateCategoryMetadata)
+	categoryGroup.Put("/reorder-categories", categoryHandlers.ReorderCategories)
+	categoryGroup.Get("/get-category-stats", categoryHandlers.GetCategoryStats)
+	categoryGroup.Post("/:id/merge-into/:target", categoryHandlers.MergeCategory)
+	categoryGroup.Post("/add-category-field/:id", categoryHandlers.AddCategoryField)
+	categoryGroup.Get("/get-category-fields/:id", categoryHandlers.GetCategoryFields)
+	categoryGroup.Delete("/delete-category-field/:id/:fieldId", categoryHandlers.DeleteCategoryField)
+
+	organizationGroup := app.Group("/organizations")
+	organizationGroup.Post("/add-organization", organizationHandlers.AddOrganization)
+	organizationGroup.Get("/get-organizations", organizationHandlers.GetOrganizations)
+	organizationGroup.Get("/get-organization/:id", organizationHandlers.GetOrganization)
+	organizationGroup.Patch("/update-organization/:id", organizationHandlers.UpdateOrganization)
+	organizationGroup.Delete("/delete-organization/:id", organizationHandlers.DeleteOrganization)
+	organizationGroup.Get("/get-organization-contacts/:id", organizationHandlers.GetOrganizationContacts)
+
+	taskGroup := app.Group("/tasks")
+	taskGroup.Get("/get-tasks", taskHandlers.GetTasks)
+	taskGroup.Get("/get-overdue-tasks", taskHandlers.GetOverdueTasks)
+	taskGroup.Get("/get-task/:id", taskHandlers.GetTask)
+	taskGroup.Patch("/update-task/:id", taskHandlers.UpdateTask)
+	taskGroup.Patch("/set-task-status/:id", taskHandlers.SetTaskStatus)
+	taskGroup.Delete("/delete-task/:id", taskHandlers.DeleteTask)
+
+	app.Get("/.well-known/carddav", cardDAVHandlers.WellKnown)
+	app.Add("PROPFIND", "/.well-known/carddav", cardDAVHandlers.WellKnown)
+
+	cardDAVGroup := app.Group("/carddav")
+	cardDAVGroup.Options("/*", cardDAVHandlers.Options)
+	cardDAVGroup.Add("PROPFIND", "/", cardDAVHandlers.PropfindPrincipal)
+	cardDAVGroup.Add("PROPFIND", "/addressbooks", cardDAVHandlers.PropfindHome)
+	cardDAVGroup.Add("PROPFIND", "/addressbooks/:book", cardDAVHandlers.PropfindBook)
+	cardDAVGroup.Add("REPORT", "/addressbooks/:book", cardDAVHandlers.Report)
+	cardDAVGroup.Add("PROPFIND", "/addressbooks/:book/:name", cardDAVHandlers.PropfindCard)
+	cardDAVGroup.Get("/addressbooks/:book/:name", cardDAVHandlers.GetCard)
+	cardDAVGroup.Put("/addressbooks/:book/:name", cardDAVHandlers.PutCard)
+	cardDAVGroup.Delete("/addressbooks/:book/:name", cardDAVHandlers.DeleteCard)
 
 	lc.Append(fx.Hook{
 		OnStart: func(ctx context.Context) error {
@@ -0,0 +1,315 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	ActivityNote    = "note"
+	ActivityCall    = "call"
+	ActivityMeeting = "meeting"
+	ActivityEmail   = "email"
+)
+
+var activityTypes = []string{ActivityNote, ActivityCall, ActivityMeeting, ActivityEmail}
+
+type Activity struct {
+	Id         int       `json:"id" db:"id"`
+	ContactId  int       `json:"contact_id" db:"contact_id"`
+	Type       string    `json:"type" db:"type"`
+	OccurredAt time.Time `json:"occurred_at" db:"occurred_at"`
+	Author     string    `json:"author" db:"author"`
+	Body       string    `json:"body" db:"body"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at" db:"updated_at"`
+}
+
+type NewActivityInput struct {
+	Type string
+	// OccurredAt defaults to now when zero.
+	OccurredAt time.Time
+	Author     string
+	Body       string
+}
+
+// UpdateActivityInput holds optional activity changes, nil fields are left untouched.
+type UpdateActivityInput struct {
+	Type       *string
+	OccurredAt *time.Time
+	Author     *string
+	Body       *string
+}
+
+// TimelineEntry is one event in the history of a contact: an activity or a
+// change recorded on the contact itself.
+type TimelineEntry struct {
+	Kind       string    `json:"kind" db:"kind"`
+	ActivityId *int      `json:"activity_id,omitempty" db:"activity_id"`
+	Type       string    `json:"type" db:"type"`
+	OccurredAt time.Time `json:"occurred_at" db:"occurred_at"`
+	Author     string    `json:"author" db:"author"`
+	Body       string    `json:"body" db:"body"`
+}
+
+const activityColumns = "id, contact_id, type, occurred_at, author, body, created_at, updated_at"
+
+type ActivityStorage struct {
+	DB *sqlx.DB
+}
+
+func NewActivityStorage(DB *sqlx.DB) *ActivityStorage {
+	return &ActivityStorage{DB: DB}
+}
+
+// refreshLastContacted derives contacts.last_contacted_at from the contact's
+// calls, meetings and emails; notes do not count as contacting someone.
+func refreshLastContacted(tx *sqlx.Tx, contactId int) error {
+	updateStmt := `
+		UPDATE contacts SET last_contacted_at = (
+			SELECT MAX(occurred_at) FROM contact_activities
+			WHERE contact_id = $1 AND type != 'note'
+		)
+		WHERE id = $1
+	`
+	if _, err := tx.Exec(updateStmt, contactId); err != nil {
+		return fmt.Errorf("error updating last contacted date: %v", err)
+	}
+	return nil
+}
+
+func (storage *ActivityStorage) AddActivity(contactId int, data NewActivityInput) (int, error) {
+	defer clearCategoryStats()
+
+	if !containsString(activityTypes, data.Type) {
+		return 0, fmt.Errorf("invalid activity type '%s', expected one of %v", data.Type, activityTypes)
+	}
+	if data.OccurredAt.IsZero() {
+		data.OccurredAt = time.Now()
+	}
+
+	tx, err := storage.DB.Beginx()
+	if err != nil {
+		return 0, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var exists int
+	if err := tx.QueryRow("SELECT id FROM contacts WHERE id = $1", contactId).Scan(&exists); err != nil {
+		if err == sql.ErrNoRows {
+			return 0, err
+		}
+		return 0, fmt.Errorf("error fetching contact: %v", err)
+	}
+
+	var id int
+	insertStmt := `
+		INSERT INTO contact_activities (contact_id, type, occurred_at, author, body)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id
+	`
+	err = tx.QueryRow(insertStmt, contactId, data.Type, data.OccurredAt, data.Author, data.Body).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("error adding activity: %v", err)
+	}
+
+	if err := refreshLastContacted(tx, contactId); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error committing activity: %v", err)
+	}
+
+	return id, nil
+}
+
+// GetActivities lists the activities of a contact, newest first, optionally of a single type.
+func (storage *ActivityStorage) GetActivities(contactId, limit, offset int, activityType string) ([]Activity, error) {
+	activities := []Activity{}
+	stmt := "SELECT " + activityColumns + " FROM contact_activities WHERE contact_id = $1"
+	args := []interface{}{contactId}
+
+	if activityType != "" {
+		args = append(args, activityType)
+		stmt += " AND type = $" + strconv.Itoa(len(args))
+	}
+
+	stmt += " ORDER BY occurred_at DESC, id DESC"
+
+	if limit > 0 {
+		args = append(args, limit)
+		stmt += " LIMIT $" + strconv.Itoa(len(args))
+	}
+
+	if offset > 0 {
+		if limit <= 0 {
+			return nil, fmt.Errorf("offset specified without limit")
+		}
+		args = append(args, offset)
+		stmt += " OFFSET $" + strconv.Itoa(len(args))
+	}
+
+	if err := storage.DB.Select(&activities, stmt, args...); err != nil {
+		return nil, fmt.Errorf("error fetching activities: %v", err)
+	}
+
+	return activities, nil
+}
+
+func (storage *ActivityStorage) GetActivity(contactId, id int) (Activity, error) {
+	var activity Activity
+	selectStmt := "SELECT " + activityColumns + " FROM contact_activities WHERE id = $1 AND contact_id = $2"
+	if err := storage.DB.Get(&activity, selectStmt, id, contactId); err != nil {
+		if err == sql.ErrNoRows {
+			return activity, err
+		}
+		return activity, fmt.Errorf("error fetching activity: %v", err)
+	}
+	return activity, nil
+}
+
+func (storage *ActivityStorage) UpdateActivity(contactId, id int, data UpdateActivityInput) error {
+	defer clearCategoryStats()
+
+	stmt := "UPDATE contact_activities SET updated_at = now(),"
+	args := []interface{}{id, contactId}
+
+	if data.Type != nil {
+		if !containsString(activityTypes, *data.Type) {
+			return fmt.Errorf("invalid activity type '%s', expected one of %v", *data.Type, activityTypes)
+		}
+		args = append(args, *data.Type)
+		stmt += " type = $" + strconv.Itoa(len(args)) + ","
+	}
+	if data.OccurredAt != nil {
+		args = append(args, *data.OccurredAt)
+		stmt += " occurred_at = $" + strconv.Itoa(len(args)) + ","
+	}
+	if data.Author != nil {
+		args = append(args, *data.Author)
+		stmt += " author = $" + strconv.Itoa(len(args)) + ","
+	}
+	if data.Body != nil {
+		args = append(args, *data.Body)
+		stmt += " body = $" + strconv.Itoa(len(args)) + ","
+	}
+
+	stmt = strings.TrimSuffix(stmt, ",")
+	stmt += " WHERE id = $1 AND contact_id = $2"
+
+	tx, err := storage.DB.Beginx()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	resp, err := tx.Exec(stmt, args...)
+	if err != nil {
+		return fmt.Errorf("error updating activity: %v", err)
+	}
+
+	rowsAffected, err := resp.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %v", err)
+	}
+
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	if err := refreshLastContacted(tx, contactId); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing activity: %v", err)
+	}
+
+	return nil
+}
+
+func (storage *ActivityStorage) DeleteActivity(contactId, id int) error {
+	defer clearCategoryStats()
+
+	tx, err := storage.DB.Beginx()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	deleteStmt := "DELETE FROM contact_activities WHERE id = $1 AND contact_id = $2"
+	resp, err := tx.Exec(deleteStmt, id, contactId)
+	if err != nil {
+		return fmt.Errorf("error deleting activity: %v", err)
+	}
+
+	rowsAffected, err := resp.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %v", err)
+	}
+
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	if err := refreshLastContacted(tx, contactId); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing activity: %v", err)
+	}
+
+	return nil
+}
+
+// GetTimeline merges the activities of a contact with the events recorded on
+// the contact itself into one list, newest first.
+func (storage *ActivityStorage) GetTimeline(contactId, limit, offset int) ([]TimelineEntry, error) {
+	var exists int
+	if err := storage.DB.QueryRow("SELECT id FROM contacts WHERE id = $1", contactId).Scan(&exists); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, err
+		}
+		return nil, fmt.Errorf("error fetching contact: %v", err)
+	}
+
+	entries := []TimelineEntry{}
+	stmt := `
+		SELECT 'activity' AS kind, id AS activity_id, type, occurred_at, author, body
+		FROM contact_activities WHERE contact_id = $1
+		UNION ALL
+		SELECT 'event' AS kind, NULL AS activity_id, 'created' AS type, created_at AS occurred_at, '' AS author, 'Contact created' AS body
+		FROM contacts WHERE id = $1 AND created_at IS NOT NULL
+		UNION ALL
+		SELECT 'event' AS kind, NULL AS activity_id, 'merged' AS type, created_at AS occurred_at, '' AS author, 'Merged contact ' || merged_name AS body
+		FROM contact_merges WHERE contact_id = $1
+		ORDER BY occurred_at DESC, activity_id DESC NULLS LAST
+	`
+	args := []interface{}{contactId}
+
+	if limit > 0 {
+		args = append(args, limit)
+		stmt += " LIMIT $" + strconv.Itoa(len(args))
+	}
+
+	if offset > 0 {
+		if limit <= 0 {
+			return nil, fmt.Errorf("offset specified without limit")
+		}
+		args = append(args, offset)
+		stmt += " OFFSET $" + strconv.Itoa(len(args))
+	}
+
+	if err := storage.DB.Select(&entries, stmt, args...); err != nil {
+		return nil, fmt.Errorf("error fetching timeline: %v", err)
+	}
+
+	return entries, nil
+}
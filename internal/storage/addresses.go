@@ -0,0 +1,72 @@
+package storage
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	postalCodePattern = regexp.MustCompile(`\b\d{4,6}\b`)
+	buildingPattern   = regexp.MustCompile(`\s(\d+[A-Za-z]?(/\d+)?)$`)
+)
+
+// PostalAddress holds the components of an address. NeedsReview is set when
+// the components were guessed from free text rather than entered.
+type PostalAddress struct {
+	Street      string `json:"street" db:"street"`
+	Building    string `json:"building" db:"building"`
+	City        string `json:"city" db:"city"`
+	Region      string `json:"region" db:"region"`
+	PostalCode  string `json:"postal_code" db:"postal_code"`
+	Country     string `json:"country" db:"country"`
+	NeedsReview bool   `json:"needs_review" db:"needs_review"`
+}
+
+// ParseAddress splits a free text address of the form
+// "street building, city[, region], [postal code] country" into components.
+// The result is a best effort guess and is always flagged for review.
+func ParseAddress(value string) PostalAddress {
+	address := PostalAddress{NeedsReview: true}
+
+	parts := strings.Split(strings.TrimSpace(value), ",")
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+	n := len(parts)
+
+	address.PostalCode = postalCodePattern.FindString(value)
+	stripPostal := func(part string) string {
+		return strings.TrimSpace(postalCodePattern.ReplaceAllString(part, ""))
+	}
+
+	address.Street = parts[0]
+	if match := buildingPattern.FindStringSubmatch(parts[0]); match != nil {
+		address.Building = match[1]
+		address.Street = strings.TrimSpace(strings.TrimSuffix(parts[0], match[0]))
+	}
+	if n >= 2 {
+		address.City = stripPostal(parts[1])
+	}
+	if n >= 4 {
+		address.Region = stripPostal(parts[2])
+	}
+	if n >= 3 {
+		address.Country = stripPostal(parts[n-1])
+	}
+
+	return address
+}
+
+// FormatAddress joins address components back into a single line.
+func FormatAddress(address PostalAddress) string {
+	parts := []string{}
+	if street := strings.TrimSpace(address.Street + " " + address.Building); street != "" {
+		parts = append(parts, street)
+	}
+	for _, part := range []string{address.City, address.Region, strings.TrimSpace(address.PostalCode + " " + address.Country)} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
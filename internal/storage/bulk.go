@@ -0,0 +1,242 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+const (
+	// BulkTransactional applies a batch only when every item succeeds.
+	BulkTransactional = "transactional"
+	// BulkBestEffort applies the items that succeed and reports the others.
+	BulkBestEffort = "best_effort"
+)
+
+var bulkModes = []string{BulkTransactional, BulkBestEffort}
+
+// MaxBulkItems is the largest batch accepted by the bulk operations.
+const MaxBulkItems = 500
+
+// BulkItemResult is the outcome of one item of a batch, Index being its
+// position in the request. Id is the affected contact, only set for created
+// contacts once the batch is committed.
+type BulkItemResult struct {
+	Index   int    `json:"index"`
+	Id      int    `json:"id,omitempty"`
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+}
+
+// BulkResult reports a batch. Committed is false when nothing was applied,
+// which in transactional mode happens as soon as one item fails.
+type BulkResult struct {
+	Mode      string           `json:"mode"`
+	Committed bool             `json:"committed"`
+	Succeeded int              `json:"succeeded"`
+	Failed    int              `json:"failed"`
+	Items     []BulkItemResult `json:"items"`
+}
+
+// UpdateContactInput holds the changes of one contact in a bulk update; empty
+// fields are left unchanged as with UpdateContact.
+type UpdateContactInput struct {
+	Id           int
+	Name         string
+	Phone        string
+	Email        string
+	Address      string
+	Category     string
+	CustomFields CustomFields
+}
+
+// runBulk applies every item of a batch in one transaction, each behind a
+// savepoint so a failing item is rolled back alone and every item gets a
+// result. The transaction is committed in best effort mode, or in
+// transactional mode when no item failed.
+func (storage *ContactStorage) runBulk(mode string, count int, apply func(tx *sqlx.Tx, i int) (int, error)) (BulkResult, error) {
+	if mode == "" {
+		mode = BulkTransactional
+	}
+	result := BulkResult{Mode: mode, Items: []BulkItemResult{}}
+
+	if !containsString(bulkModes, mode) {
+		return result, fmt.Errorf("invalid bulk mode '%s', expected one of %v", mode, bulkModes)
+	}
+	if count == 0 {
+		return result, fmt.Errorf("no items given")
+	}
+	if count > MaxBulkItems {
+		return result, fmt.Errorf("too many items, at most %d are accepted", MaxBulkItems)
+	}
+
+	tx, err := storage.DB.Beginx()
+	if err != nil {
+		return result, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	for i := 0; i < count; i++ {
+		if _, err := tx.Exec("SAVEPOINT bulk_item"); err != nil {
+			return result, fmt.Errorf("error starting bulk item: %v", err)
+		}
+
+		item := BulkItemResult{Index: i}
+		id, err := apply(tx, i)
+		if err != nil {
+			if _, err := tx.Exec("ROLLBACK TO SAVEPOINT bulk_item"); err != nil {
+				return result, fmt.Errorf("error rolling back bulk item: %v", err)
+			}
+			item.Error = err.Error()
+			if err == sql.ErrNoRows {
+				item.Error = "contact not found"
+			}
+			result.Failed++
+		} else {
+			if _, err := tx.Exec("RELEASE SAVEPOINT bulk_item"); err != nil {
+				return result, fmt.Errorf("error finishing bulk item: %v", err)
+			}
+			item.Id = id
+			item.Success = true
+			result.Succeeded++
+		}
+		result.Items = append(result.Items, item)
+	}
+
+	if mode == BulkTransactional && result.Failed > 0 {
+		for i := range result.Items {
+			result.Items[i].Id = 0
+		}
+		return result, nil
+	}
+
+	if err := tx.Commit(); err != nil {
+		return result, fmt.Errorf("error committing bulk operation: %v", err)
+	}
+	result.Committed = true
+
+	return result, nil
+}
+
+// BulkCreateContacts creates a batch of contacts.
+func (storage *ContactStorage) BulkCreateContacts(contacts []NewContactInput, mode string) (BulkResult, error) {
+	defer clearCategoryStats()
+
+	return storage.runBulk(mode, len(contacts), func(tx *sqlx.Tx, i int) (int, error) {
+		return storage.createContact(tx, contacts[i])
+	})
+}
+
+// BulkUpdateContacts updates a batch of contacts.
+func (storage *ContactStorage) BulkUpdateContacts(contacts []UpdateContactInput, mode string) (BulkResult, error) {
+	defer clearCategoryStats()
+
+	return storage.runBulk(mode, len(contacts), func(tx *sqlx.Tx, i int) (int, error) {
+		data := contacts[i]
+		err := storage.updateContact(tx, data.Id, data.Name, data.Phone, data.Email, data.Address, data.Category, data.CustomFields)
+		return data.Id, err
+	})
+}
+
+// BulkDeleteContacts deletes a batch of contacts by id.
+func (storage *ContactStorage) BulkDeleteContacts(ids []int, mode string) (BulkResult, error) {
+	defer clearCategoryStats()
+
+	return storage.runBulk(mode, len(ids), func(tx *sqlx.Tx, i int) (int, error) {
+		return ids[i], deleteContact(tx, ids[i])
+	})
+}
+
+// ErrCustomFieldsNotValid is returned when contacts cannot move to a category
+// because of their custom fields.
+var ErrCustomFieldsNotValid = errors.New("custom fields not valid for the category")
+
+// SetCategoryResult reports a bulk category reassignment. Matched counts the
+// contacts of the filter, Moved those not yet in the category.
+type SetCategoryResult struct {
+	CategoryId int  `json:"category_id"`
+	Matched    int  `json:"matched"`
+	Moved      int  `json:"moved"`
+	DryRun     bool `json:"dry_run"`
+	// InvalidContacts lists contacts whose custom fields do not fit the category.
+	InvalidContacts []int `json:"invalid_contacts"`
+}
+
+// SetCategoryByFilter moves every contact matching the filter to a category
+// with a single UPDATE. The filter must not be empty. Nothing is moved with
+// dryRun, or when some contact's custom fields do not fit the category: the
+// result then lists them, with ErrCustomFieldsNotValid.
+func (storage *ContactStorage) SetCategoryByFilter(filter ContactFilter, category string, dryRun bool) (SetCategoryResult, error) {
+	defer clearCategoryStats()
+
+	result := SetCategoryResult{DryRun: dryRun, InvalidContacts: []int{}}
+
+	args := []interface{}{}
+	conds := filter.conditions(&args)
+	if len(conds) == 0 {
+		return result, fmt.Errorf("a filter is required to reassign contacts")
+	}
+
+	tx, err := storage.DB.Beginx()
+	if err != nil {
+		return result, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	result.CategoryId, err = GetCategoryIdByLabel(tx, category)
+	if err != nil {
+		return result, err
+	}
+
+	where := strings.Join(conds, " AND ")
+	countStmt := "SELECT COUNT(*) FROM contacts c WHERE " + where
+	if err := tx.QueryRow(countStmt, args...).Scan(&result.Matched); err != nil {
+		return result, fmt.Errorf("error counting contacts: %v", err)
+	}
+
+	var contacts []Contact
+	args = append(args, result.CategoryId)
+	selectStmt := "SELECT c.id, c.custom_fields FROM contacts c WHERE " + where +
+		" AND c.category_id <> $" + strconv.Itoa(len(args)) + " ORDER BY c.id FOR UPDATE"
+	if err := tx.Select(&contacts, selectStmt, args...); err != nil {
+		return result, fmt.Errorf("error fetching contacts: %v", err)
+	}
+	result.Moved = len(contacts)
+
+	defs, err := getCategoryFields(tx, result.CategoryId)
+	if err != nil {
+		return result, err
+	}
+
+	ids := []int64{}
+	for _, contact := range contacts {
+		if _, err := ValidateCustomFields(defs, contact.CustomFields); err != nil {
+			result.InvalidContacts = append(result.InvalidContacts, contact.Id)
+		}
+		ids = append(ids, int64(contact.Id))
+	}
+
+	if dryRun {
+		return result, nil
+	}
+
+	if len(result.InvalidContacts) > 0 {
+		return result, fmt.Errorf("%w: %d contacts", ErrCustomFieldsNotValid, len(result.InvalidContacts))
+	}
+
+	updateStmt := "UPDATE contacts SET category_id = $1 WHERE id = ANY($2)"
+	if _, err := tx.Exec(updateStmt, result.CategoryId, pq.Array(ids)); err != nil {
+		return result, fmt.Errorf("error reassigning contacts: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return result, fmt.Errorf("error committing category reassignment: %v", err)
+	}
+
+	return result, nil
+}
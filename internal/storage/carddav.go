@@ -0,0 +1,391 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// ErrPreconditionFailed is returned when the If-Match or If-None-Match
+// condition of a CardDAV write does not hold.
+var ErrPreconditionFailed = errors.New("precondition failed")
+
+// ErrDavConflict is returned when a CardDAV write conflicts with the stored
+// contacts, such as a resource of another address book or a reserved name.
+var ErrDavConflict = errors.New("resource conflict")
+
+// ErrInvalidSyncToken is returned for sync tokens newer than the address book.
+var ErrInvalidSyncToken = errors.New("invalid sync token")
+
+// DavResource is a contact as served over CardDAV. Name is its resource name
+// in the address book and UID the vCard UID given by the client that created
+// it, empty for contacts created through the API.
+type DavResource struct {
+	Contact_
+	Name    string `db:"dav_name"`
+	UID     string `db:"dav_uid"`
+	Version int64  `db:"sync_version"`
+}
+
+// ETag returns the entity tag of the resource, which changes with every
+// change to the contact.
+func (resource DavResource) ETag() string {
+	return davETag(resource.Version)
+}
+
+func davETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// DavChanges lists what changed in an address book since a sync token.
+type DavChanges struct {
+	Token   int64
+	Changed []DavResource
+	Deleted []string
+}
+
+// DavPrecondition holds the If-Match and If-None-Match headers of a write:
+// a list of entity tags, "*" or empty.
+type DavPrecondition struct {
+	IfMatch     string
+	IfNoneMatch string
+}
+
+func etagListContains(list, etag string) bool {
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimPrefix(strings.TrimSpace(item), "W/")
+		if item == "*" || item == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func (precondition DavPrecondition) check(exists bool, etag string) error {
+	if precondition.IfMatch != "" && (!exists || !etagListContains(precondition.IfMatch, etag)) {
+		return ErrPreconditionFailed
+	}
+	if precondition.IfNoneMatch != "" && exists && etagListContains(precondition.IfNoneMatch, etag) {
+		return ErrPreconditionFailed
+	}
+	return nil
+}
+
+// DavWrite is a vCard stored through CardDAV. CategoryId is the address book,
+// zero for the whole contact book.
+type DavWrite struct {
+	CategoryId   int
+	Name         string
+	UID          string
+	Record       ImportRecord
+	Precondition DavPrecondition
+}
+
+// davColumns are the contact columns with the CardDAV resource name, contacts
+// created through the API being named after their id.
+const davColumns = contactColumns + `, COALESCE(c.dav_name, 'contact-' || c.id || '.vcf') AS dav_name, COALESCE(c.dav_uid, '') AS dav_uid, c.sync_version`
+
+// davNameId returns the contact id of a generated resource name. Clients
+// cannot create resources with such names, so they always refer to contacts
+// created through the API.
+func davNameId(name string) (int, bool) {
+	if !strings.HasPrefix(name, "contact-") || !strings.HasSuffix(name, ".vcf") {
+		return 0, false
+	}
+	id, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(name, "contact-"), ".vcf"))
+	return id, err == nil
+}
+
+func selectDavResources(DB sqlx.Queryer, where string, args ...interface{}) ([]DavResource, error) {
+	resources := []DavResource{}
+	stmt := "SELECT " + davColumns + " FROM contacts c" + contactJoins + " WHERE " + where + " ORDER BY c.id"
+	if err := sqlx.Select(DB, &resources, stmt, args...); err != nil {
+		return nil, fmt.Errorf("error fetching contacts: %v", err)
+	}
+
+	contacts := make([]Contact_, len(resources))
+	for i, resource := range resources {
+		contacts[i] = resource.Contact_
+	}
+	if err := attachContactMethods(DB, contacts); err != nil {
+		return nil, err
+	}
+	for i := range resources {
+		resources[i].Contact_ = contacts[i]
+	}
+	return resources, nil
+}
+
+// davToken returns the sync token of an address book: the transaction ids
+// of its changes that a sync since the token must return start at it. It is
+// the lowest transaction still running, so changes committed later are not
+// skipped, or one past the latest change of the book once every transaction
+// before it has finished, so the token only moves when the book changes.
+func davToken(DB sqlx.Queryer, categoryId int) (int64, error) {
+	var token int64
+	stmt := `
+		SELECT LEAST(
+			pg_snapshot_xmin(pg_current_snapshot())::text::bigint,
+			GREATEST(
+				(SELECT sync_xid::text::bigint FROM contacts WHERE $1 = 0 OR category_id = $1 ORDER BY sync_xid DESC LIMIT 1),
+				(SELECT sync_xid::text::bigint FROM contact_tombstones WHERE $1 = 0 OR category_id = $1 ORDER BY sync_xid DESC LIMIT 1),
+				0
+			) + 1
+		)
+	`
+	if err := DB.QueryRowx(stmt, categoryId).Scan(&token); err != nil {
+		return 0, fmt.Errorf("error fetching sync token: %v", err)
+	}
+	return token, nil
+}
+
+// GetDavToken returns the current sync token of an address book.
+func (storage *ContactStorage) GetDavToken(categoryId int) (int64, error) {
+	return davToken(storage.DB, categoryId)
+}
+
+// GetDavResources returns the contacts of an address book, or only those with
+// the given resource names when names is not nil.
+func (storage *ContactStorage) GetDavResources(categoryId int, names []string) ([]DavResource, error) {
+	if names == nil {
+		return selectDavResources(storage.DB, "($1 = 0 OR c.category_id = $1)", categoryId)
+	}
+
+	ids := []int64{}
+	named := []string{}
+	for _, name := range names {
+		if id, ok := davNameId(name); ok {
+			ids = append(ids, int64(id))
+		} else {
+			named = append(named, name)
+		}
+	}
+	where := "($1 = 0 OR c.category_id = $1) AND (c.dav_name = ANY($2) OR (c.dav_name IS NULL AND c.id = ANY($3)))"
+	return selectDavResources(storage.DB, where, categoryId, pq.Array(named), pq.Array(ids))
+}
+
+// GetDavChanges returns the contacts of an address book changed after the
+// since token and the names of those that left it, with the new token. A
+// zero since token returns the whole address book.
+func (storage *ContactStorage) GetDavChanges(categoryId int, since int64) (DavChanges, error) {
+	changes := DavChanges{Deleted: []string{}}
+
+	// The token and the changes are read from one snapshot. Changes of
+	// transactions it cannot see have ids at or above the token, so the
+	// next sync returns them; changes it can see may be returned twice.
+	tx, err := storage.DB.BeginTxx(context.Background(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return changes, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	changes.Token, err = davToken(tx, categoryId)
+	if err != nil {
+		return changes, err
+	}
+	if since > changes.Token || since < 0 {
+		return changes, ErrInvalidSyncToken
+	}
+
+	changes.Changed, err = selectDavResources(tx, "($1 = 0 OR c.category_id = $1) AND c.sync_xid >= $2::text::xid8", categoryId, since)
+	if err != nil {
+		return changes, err
+	}
+
+	if since > 0 {
+		deletedStmt := `
+			SELECT DISTINCT t.dav_name FROM contact_tombstones t
+			WHERE ($1 = 0 OR t.category_id = $1) AND t.sync_xid >= $2::text::xid8
+				AND NOT EXISTS (SELECT 1 FROM contacts c WHERE c.id = t.contact_id AND ($1 = 0 OR c.category_id = $1))
+			ORDER BY t.dav_name
+		`
+		if err := tx.Select(&changes.Deleted, deletedStmt, categoryId, since); err != nil {
+			return changes, fmt.Errorf("error fetching deleted contacts: %v", err)
+		}
+	}
+
+	return changes, nil
+}
+
+// davTarget is the contact a CardDAV write applies to.
+type davTarget struct {
+	Id           int          `db:"id"`
+	CategoryId   int          `db:"category_id"`
+	CustomFields CustomFields `db:"custom_fields"`
+	Version      int64        `db:"sync_version"`
+}
+
+// lockDavTarget locks the contact with a resource name. Contacts of another
+// address book are reported as missing, along with whether one was found.
+func lockDavTarget(tx *sqlx.Tx, categoryId int, name string) (davTarget, bool, bool, error) {
+	var target davTarget
+	selectStmt := "SELECT id, category_id, custom_fields, sync_version FROM contacts WHERE dav_name = $1 FOR UPDATE"
+	var arg interface{} = name
+	if id, ok := davNameId(name); ok {
+		selectStmt = "SELECT id, category_id, custom_fields, sync_version FROM contacts WHERE dav_name IS NULL AND id = $1 FOR UPDATE"
+		arg = id
+	}
+	if err := tx.Get(&target, selectStmt, arg); err != nil {
+		if err == sql.ErrNoRows {
+			return target, false, false, nil
+		}
+		return target, false, false, fmt.Errorf("error fetching contact: %v", err)
+	}
+	if categoryId != 0 && target.CategoryId != categoryId {
+		return target, false, true, nil
+	}
+	return target, true, false, nil
+}
+
+// PutDavResource creates or replaces the contact stored under a resource
+// name from a vCard and reports whether it was created. The name, phones,
+// emails, addresses and job title are replaced. In a category address book
+// contacts keep that category; in the whole book the vCard category is used
+// when it exists, falling back to the current or else the first category.
+// Custom fields are kept as far as the category defines them. Cards that
+// cannot be saved fail with ErrInvalidContact, emails used by other contacts
+// with ErrEmailExists and writes to resources of another address book or to
+// new resources with a generated name with ErrDavConflict.
+func (storage *ContactStorage) PutDavResource(data DavWrite) (bool, error) {
+	defer clearCategoryStats()
+
+	name := strings.TrimSpace(data.Record.Name)
+	if name == "" {
+		return false, fmt.Errorf("%w: vCard has no name", ErrInvalidContact)
+	}
+
+	tx, err := storage.DB.Beginx()
+	if err != nil {
+		return false, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	target, exists, elsewhere, err := lockDavTarget(tx, data.CategoryId, data.Name)
+	if err != nil {
+		return false, err
+	}
+	if elsewhere {
+		return false, fmt.Errorf("%w: resource '%s' belongs to another address book", ErrDavConflict, data.Name)
+	}
+	if _, generated := davNameId(data.Name); generated && !exists {
+		return false, fmt.Errorf("%w: resource names like '%s' are reserved", ErrDavConflict, data.Name)
+	}
+	if err := data.Precondition.check(exists, davETag(target.Version)); err != nil {
+		return false, err
+	}
+
+	categoryId := data.CategoryId
+	if categoryId == 0 && data.Record.Category != "" {
+		stmt := "SELECT id FROM categories WHERE " + labelKey + " = lower($1)"
+		err := tx.QueryRow(stmt, NormalizeLabel(data.Record.Category)).Scan(&categoryId)
+		if err != nil && err != sql.ErrNoRows {
+			return false, fmt.Errorf("error getting category ID: %v", err)
+		}
+	}
+	if categoryId == 0 && exists {
+		categoryId = target.CategoryId
+	}
+	if categoryId == 0 {
+		err := tx.QueryRow("SELECT id FROM categories ORDER BY position, id LIMIT 1").Scan(&categoryId)
+		if err == sql.ErrNoRows {
+			return false, fmt.Errorf("%w: no category to store the contact in", ErrDavConflict)
+		}
+		if err != nil {
+			return false, fmt.Errorf("error getting category ID: %v", err)
+		}
+	}
+
+	defs, err := getCategoryFields(tx, categoryId)
+	if err != nil {
+		return false, err
+	}
+	kept := CustomFields{}
+	for _, def := range defs {
+		if value, ok := target.CustomFields[def.Key]; ok {
+			kept[def.Key] = value
+		}
+	}
+	customFields, err := ValidateCustomFields(defs, kept)
+	if err != nil {
+		return false, invalidContact(err)
+	}
+
+	id := target.Id
+	if exists {
+		updateStmt := `
+			UPDATE contacts SET name = $2, category_id = $3, custom_fields = $4, job_title = $5, dav_uid = NULLIF($6, '')
+			WHERE id = $1
+		`
+		if _, err := tx.Exec(updateStmt, id, name, categoryId, customFields, data.Record.JobTitle, data.UID); err != nil {
+			return false, fmt.Errorf("error updating contact: %v", err)
+		}
+	} else {
+		insertStmt := `
+			INSERT INTO contacts (name, phone, email, address, category_id, custom_fields, job_title, dav_name, dav_uid)
+			VALUES ($1, '', '', '', $2, $3, $4, $5, NULLIF($6, ''))
+			RETURNING id
+		`
+		err := tx.QueryRow(insertStmt, name, categoryId, customFields, data.Record.JobTitle, data.Name, data.UID).Scan(&id)
+		if err != nil {
+			return false, fmt.Errorf("error creating contact: %v", err)
+		}
+	}
+
+	// Non-nil lists so every list is replaced, emptied ones included.
+	methods := ContactMethods{
+		Phones:    append([]ContactMethodInput{}, data.Record.Phones...),
+		Emails:    append([]ContactMethodInput{}, data.Record.Emails...),
+		Addresses: append([]ContactMethodInput{}, data.Record.Addresses...),
+	}
+	if err := replaceContactMethods(tx, id, methods); err != nil {
+		return false, err
+	}
+
+	if err := storage.geocodeContact(tx, id); err != nil {
+		return false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("error saving contact: %v", err)
+	}
+
+	return !exists, nil
+}
+
+// DeleteDavResource deletes the contact stored under a resource name.
+func (storage *ContactStorage) DeleteDavResource(categoryId int, name string, precondition DavPrecondition) error {
+	defer clearCategoryStats()
+
+	tx, err := storage.DB.Beginx()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	target, exists, _, err := lockDavTarget(tx, categoryId, name)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return sql.ErrNoRows
+	}
+	if err := precondition.check(exists, davETag(target.Version)); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("DELETE FROM contacts WHERE id = $1", target.Id); err != nil {
+		return fmt.Errorf("error deleting contact: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error deleting contact: %v", err)
+	}
+
+	return nil
+}
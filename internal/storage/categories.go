@@ -2,20 +2,68 @@ package storage
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 )
 
+// ErrUnknownCategory is returned when a category label or ID does not exist.
+var ErrUnknownCategory = errors.New("category does not exist")
+
+// ErrInvalidCategory is returned when category data cannot be saved as given.
+var ErrInvalidCategory = errors.New("invalid category")
+
+// ErrCategoryExists is returned when another category has the same label,
+// ignoring case and spacing.
+var ErrCategoryExists = errors.New("category already exists")
+
 type Category struct {
-	Id        int       `json:"id" db:"id"`
-	Label     string    `json:"label" db:"label"`
-	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	Id          int       `json:"id" db:"id"`
+	Label       string    `json:"label" db:"label"`
+	Description string    `json:"description" db:"description"`
+	Color       string    `json:"color" db:"color"`
+	Icon        string    `json:"icon" db:"icon"`
+	Position    int       `json:"position" db:"position"`
+	Archived    bool      `json:"archived" db:"archived"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
 }
 
 type NewCategoryInput struct {
-	Label string
+	Label       string
+	Description string
+	Color       string
+	Icon        string
+}
+
+// UpdateCategoryMetadataInput holds optional metadata changes, nil fields are left untouched.
+type UpdateCategoryMetadataInput struct {
+	Description *string
+	Color       *string
+	Icon        *string
+	Archived    *bool
+}
+
+// ColorPattern matches the #RRGGBB colors accepted for categories.
+var ColorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+const categoryColumns = "id, label, description, color, icon, position, archived, created_at"
+
+// labelKey is the SQL expression labels are compared by, so "Supplier " and
+// "supplier" are treated as the same category.
+const labelKey = `lower(regexp_replace(btrim(label), '\s+', ' ', 'g'))`
+
+// labelKeyIndex is the unique index on labelKey.
+const labelKeyIndex = "categories_label_key_idx"
+
+// NormalizeLabel trims a category label and collapses inner whitespace.
+func NormalizeLabel(label string) string {
+	return strings.Join(strings.Fields(label), " ")
 }
 
 type CategoryStorage struct {
@@ -26,14 +74,14 @@ func NewCategoryStorage(DB *sqlx.DB) *CategoryStorage {
 	return &CategoryStorage{DB: DB}
 }
 
-func GetCategoryIdByLabel(DB *sqlx.DB, label string) (int, error) {
+func GetCategoryIdByLabel(DB sqlx.Queryer, label string) (int, error) {
 	var id int
 
-	stmt := "SELECT id FROM categories WHERE label = $1"
-	err := DB.QueryRow(stmt, label).Scan(&id)
+	stmt := "SELECT id FROM categories WHERE " + labelKey + " = lower($1)"
+	err := DB.QueryRowx(stmt, NormalizeLabel(label)).Scan(&id)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return 0, fmt.Errorf("category '%s' does not exist", label)
+			return 0, fmt.Errorf("%w: '%s'", ErrUnknownCategory, label)
 		}
 		return 0, fmt.Errorf("error getting category ID: %v", err)
 	}
@@ -41,32 +89,68 @@ func GetCategoryIdByLabel(DB *sqlx.DB, label string) (int, error) {
 }
 
 func (storage *CategoryStorage) AddCategory(data NewCategoryInput) (int, error) {
+	defer clearCategoryStats()
+
 	var id int
+	data.Label = NormalizeLabel(data.Label)
+	if data.Label == "" {
+		return 0, fmt.Errorf("%w: label is empty", ErrInvalidCategory)
+	}
+
+	tx, err := storage.DB.Beginx()
+	if err != nil {
+		return 0, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	// The categories are locked as in ReorderCategories, so concurrent adds
+	// and reorders do not hand out the same position.
+	var existing []int
+	lockStmt := "SELECT id FROM categories ORDER BY id FOR UPDATE"
+	if err := tx.Select(&existing, lockStmt); err != nil {
+		return 0, fmt.Errorf("error locking categories: %v", err)
+	}
 
-	checkStmt := "SELECT id FROM categories WHERE label = $1"
-	err := storage.DB.QueryRow(checkStmt, data.Label).Scan(&id)
+	checkStmt := "SELECT id FROM categories WHERE " + labelKey + " = lower($1)"
+	err = tx.QueryRow(checkStmt, data.Label).Scan(&id)
 
 	if err == nil {
-		return 0, fmt.Errorf("category '%s' already exists", data.Label)
+		return 0, fmt.Errorf("%w: '%s'", ErrCategoryExists, data.Label)
 	}
 
 	if err != sql.ErrNoRows {
 		return 0, fmt.Errorf("error checking category existence: %v", err)
 	}
 
-	insertStmt := "INSERT INTO categories (label) VALUES ($1) RETURNING id"
-	err = storage.DB.QueryRow(insertStmt, data.Label).Scan(&id)
+	insertStmt := `
+		INSERT INTO categories (label, description, color, icon, position)
+		VALUES ($1, $2, $3, $4, (SELECT COALESCE(MAX(position), 0) + 1 FROM categories))
+		RETURNING id
+	`
+	err = tx.QueryRow(insertStmt, data.Label, data.Description, data.Color, data.Icon).Scan(&id)
 	if err != nil {
+		if isUniqueViolation(err, labelKeyIndex) {
+			return 0, fmt.Errorf("%w: '%s'", ErrCategoryExists, data.Label)
+		}
+		return 0, fmt.Errorf("error adding category: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
 		return 0, fmt.Errorf("error adding category: %v", err)
 	}
 
 	return id, nil
 }
 
-func (storage *CategoryStorage) GetCategoryList() ([]Category, error) {
+func (storage *CategoryStorage) GetCategoryList(includeArchived bool) ([]Category, error) {
 	var list []Category
 
-	stmt := "SELECT * FROM categories"
+	stmt := "SELECT " + categoryColumns + " FROM categories"
+	if !includeArchived {
+		stmt += " WHERE archived = false"
+	}
+	stmt += " ORDER BY position, id"
+
 	err := storage.DB.Select(&list, stmt)
 	if err != nil {
 		return nil, fmt.Errorf("error fetching category list: %v", err)
@@ -76,6 +160,8 @@ func (storage *CategoryStorage) GetCategoryList() ([]Category, error) {
 }
 
 func (storage *CategoryStorage) DeleteCategory(id int) error {
+	defer clearCategoryStats()
+
 	deleteStmt := "DELETE FROM categories WHERE id = $1"
 	if _, err := storage.DB.Exec(deleteStmt, id); err != nil {
 		return fmt.Errorf("error deleting category: %v", err)
@@ -83,19 +169,23 @@ func (storage *CategoryStorage) DeleteCategory(id int) error {
 	return nil
 }
 
+// UpdateCategoryLabel renames a category, failing with ErrCategoryExists when
+// another category has the label and sql.ErrNoRows when there is no category
+// with the id.
 func (storage *CategoryStorage) UpdateCategoryLabel(id int, label string) error {
-	stmt := `
-		UPDATE categories 
-		SET label = $1 
-		WHERE id = $2 
-		AND NOT EXISTS (
-    		SELECT 1 FROM categories 
-    		WHERE label = $1 
-    		AND id != $2
-		)
-	`
+	defer clearCategoryStats()
+
+	label = NormalizeLabel(label)
+	if label == "" {
+		return fmt.Errorf("%w: label is empty", ErrInvalidCategory)
+	}
+
+	stmt := "UPDATE categories SET label = $1 WHERE id = $2"
 	resp, err := storage.DB.Exec(stmt, label, id)
 	if err != nil {
+		if isUniqueViolation(err, labelKeyIndex) {
+			return fmt.Errorf("%w: '%s'", ErrCategoryExists, label)
+		}
 		return fmt.Errorf("error updating category label: %v", err)
 	}
 
@@ -105,7 +195,7 @@ func (storage *CategoryStorage) UpdateCategoryLabel(id int, label string) error
 	}
 
 	if rowsAffected == 0 {
-		return fmt.Errorf("category label '%s' already exists for another category", label)
+		return sql.ErrNoRows
 	}
 
 	return nil
@@ -113,9 +203,191 @@ func (storage *CategoryStorage) UpdateCategoryLabel(id int, label string) error
 
 func (storage *CategoryStorage) GetCategory(id int) (Category, error) {
 	var category Category
-	selectStmt := "SELECT id, label, created_at FROM categories WHERE id = $1"
+	selectStmt := "SELECT " + categoryColumns + " FROM categories WHERE id = $1"
 	if err := storage.DB.Get(&category, selectStmt, id); err != nil {
+		if err == sql.ErrNoRows {
+			return category, err
+		}
 		return category, fmt.Errorf("error fetching category: %v", err)
 	}
 	return category, nil
 }
+
+func (storage *CategoryStorage) UpdateCategoryMetadata(id int, data UpdateCategoryMetadataInput) error {
+	defer clearCategoryStats()
+
+	stmt := "UPDATE categories SET"
+	args := []interface{}{id}
+
+	if data.Description != nil {
+		args = append(args, *data.Description)
+		stmt += " description = $" + strconv.Itoa(len(args)) + ","
+	}
+	if data.Color != nil {
+		args = append(args, *data.Color)
+		stmt += " color = $" + strconv.Itoa(len(args)) + ","
+	}
+	if data.Icon != nil {
+		args = append(args, *data.Icon)
+		stmt += " icon = $" + strconv.Itoa(len(args)) + ","
+	}
+	if data.Archived != nil {
+		args = append(args, *data.Archived)
+		stmt += " archived = $" + strconv.Itoa(len(args)) + ","
+	}
+
+	if len(args) == 1 {
+		return fmt.Errorf("no category fields to update")
+	}
+
+	stmt = strings.TrimSuffix(stmt, ",")
+	stmt += " WHERE id = $1"
+
+	resp, err := storage.DB.Exec(stmt, args...)
+	if err != nil {
+		return fmt.Errorf("error updating category metadata: %v", err)
+	}
+
+	rowsAffected, err := resp.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %v", err)
+	}
+
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// ReorderCategories moves the categories of ids to the front in that order;
+// the categories left out follow in their previous order, so positions stay
+// unique. The whole reorder runs in a single transaction so the list is
+// never half-sorted.
+func (storage *CategoryStorage) ReorderCategories(ids []int) error {
+	tx, err := storage.DB.Beginx()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var existing []int
+	lockStmt := "SELECT id FROM categories ORDER BY id FOR UPDATE"
+	if err := tx.Select(&existing, lockStmt); err != nil {
+		return fmt.Errorf("error reordering categories: %v", err)
+	}
+
+	known := make(map[int]bool, len(existing))
+	for _, id := range existing {
+		known[id] = true
+	}
+	for _, id := range ids {
+		if !known[id] {
+			return fmt.Errorf("%w: %d", ErrUnknownCategory, id)
+		}
+	}
+
+	updateStmt := `
+		UPDATE categories c SET position = o.position
+		FROM (
+			SELECT id, row_number() OVER (ORDER BY array_position($1::int[], id) NULLS LAST, position, id) AS position
+			FROM categories
+		) o
+		WHERE c.id = o.id
+	`
+	if _, err := tx.Exec(updateStmt, pq.Array(ids)); err != nil {
+		return fmt.Errorf("error reordering categories: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing category order: %v", err)
+	}
+
+	return nil
+}
+
+type MergeCategoryResult struct {
+	SourceId      int  `json:"source_id"`
+	TargetId      int  `json:"target_id"`
+	MovedContacts int  `json:"moved_contacts"`
+	DryRun        bool `json:"dry_run"`
+	// InvalidContacts lists contacts whose custom fields do not fit the target category.
+	InvalidContacts []int `json:"invalid_contacts"`
+}
+
+// MergeCategory moves every contact of the source category to the target and
+// deletes the source, all in one transaction. With dryRun nothing is changed
+// and only the affected counts are reported. Contacts whose custom fields do
+// not fit the target block the merge: the result then lists them, with
+// ErrCustomFieldsNotValid.
+func (storage *CategoryStorage) MergeCategory(sourceId, targetId int, dryRun bool) (MergeCategoryResult, error) {
+	defer clearCategoryStats()
+
+	result := MergeCategoryResult{SourceId: sourceId, TargetId: targetId, DryRun: dryRun}
+
+	if sourceId == targetId {
+		return result, fmt.Errorf("cannot merge a category into itself")
+	}
+
+	tx, err := storage.DB.Beginx()
+	if err != nil {
+		return result, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var found int
+	lockStmt := "SELECT COUNT(*) FROM (SELECT id FROM categories WHERE id IN ($1, $2) FOR UPDATE) locked"
+	if err := tx.QueryRow(lockStmt, sourceId, targetId).Scan(&found); err != nil {
+		return result, fmt.Errorf("error locking categories: %v", err)
+	}
+	if found != 2 {
+		return result, sql.ErrNoRows
+	}
+
+	countStmt := "SELECT COUNT(*) FROM contacts WHERE category_id = $1"
+	if err := tx.QueryRow(countStmt, sourceId).Scan(&result.MovedContacts); err != nil {
+		return result, fmt.Errorf("error counting category contacts: %v", err)
+	}
+
+	defs, err := getCategoryFields(tx, targetId)
+	if err != nil {
+		return result, err
+	}
+
+	var contacts []Contact
+	contactsStmt := "SELECT id, custom_fields FROM contacts WHERE category_id = $1 ORDER BY id"
+	if err := tx.Select(&contacts, contactsStmt, sourceId); err != nil {
+		return result, fmt.Errorf("error fetching category contacts: %v", err)
+	}
+
+	result.InvalidContacts = []int{}
+	for _, contact := range contacts {
+		if _, err := ValidateCustomFields(defs, contact.CustomFields); err != nil {
+			result.InvalidContacts = append(result.InvalidContacts, contact.Id)
+		}
+	}
+
+	if dryRun {
+		return result, nil
+	}
+
+	if len(result.InvalidContacts) > 0 {
+		return result, fmt.Errorf("%w: %d contacts", ErrCustomFieldsNotValid, len(result.InvalidContacts))
+	}
+
+	moveStmt := "UPDATE contacts SET category_id = $1 WHERE category_id = $2"
+	if _, err := tx.Exec(moveStmt, targetId, sourceId); err != nil {
+		return result, fmt.Errorf("error moving contacts: %v", err)
+	}
+
+	deleteStmt := "DELETE FROM categories WHERE id = $1"
+	if _, err := tx.Exec(deleteStmt, sourceId); err != nil {
+		return result, fmt.Errorf("error deleting merged category: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return result, fmt.Errorf("error committing category merge: %v", err)
+	}
+
+	return result, nil
+}
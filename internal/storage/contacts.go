@@ -2,162 +2,506 @@ package storage
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/utah1280/backend-internship-2024/internal/filterexpr"
+	"github.com/utah1280/backend-internship-2024/internal/geocode"
 )
 
+// ErrInvalidContact is returned when contact data cannot be saved as given,
+// such as an empty name, an unknown category or invalid custom fields.
+var ErrInvalidContact = errors.New("invalid contact")
+
+// ErrEmailExists is returned when an email is already used by another contact.
+var ErrEmailExists = errors.New("email already exists")
+
+func invalidContact(err error) error {
+	return fmt.Errorf("%w: %v", ErrInvalidContact, err)
+}
+
 type Contact struct {
-	Id         int       `json:"id" db:"id"`
-	Name       string    `json:"name" db:"name"`
-	Phone      string    `json:"phone" db:"phone"`
-	Email      string    `json:"email" db:"email"`
-	Address    string    `json:"address" db:"address"`
-	CategoryId int       `json:"category_id" db:"category_id"`
-	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+	Id           int          `json:"id" db:"id"`
+	Name         string       `json:"name" db:"name"`
+	Phone        string       `json:"phone" db:"phone"`
+	Email        string       `json:"email" db:"email"`
+	Address      string       `json:"address" db:"address"`
+	CategoryId   int          `json:"category_id" db:"category_id"`
+	CustomFields CustomFields `json:"custom_fields" db:"custom_fields"`
+	CreatedAt    time.Time    `json:"created_at" db:"created_at"`
 }
 
 type NewContactInput struct {
-	Name    string
-	Phone   string
-	Email   string
-	Address string
-	Label   string
+	Name         string
+	Phone        string
+	Email        string
+	Address      string
+	Label        string
+	CustomFields CustomFields
+	Phones       []ContactMethodInput
+	Emails       []ContactMethodInput
+	Addresses    []ContactMethodInput
+	// OrganizationId links the contact to an organization when not zero.
+	OrganizationId int
+	JobTitle       string
 }
 
+// Contact_ is a contact as returned by the API, with its category label and
+// contact methods. LocationSource is "geocoded", "manual" or empty when the
+// contact has no location; DistanceKm is only set by proximity searches.
 type Contact_ struct {
-	Id         int       `json:"id" db:"id"`
-	Name       string    `json:"name" db:"name"`
-	Phone      string    `json:"phone" db:"phone"`
-	Email      string    `json:"email" db:"email"`
-	Address    string    `json:"address" db:"address"`
-	CategoryId int       `json:"category_id" db:"category_id"`
-	Category   string    `json:"category" db:"category"`
-	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+	Id              int                   `json:"id" db:"id"`
+	Name            string                `json:"name" db:"name"`
+	Phone           string                `json:"phone" db:"phone"`
+	Email           string                `json:"email" db:"email"`
+	Address         string                `json:"address" db:"address"`
+	CategoryId      int                   `json:"category_id" db:"category_id"`
+	Category        string                `json:"category" db:"category"`
+	CustomFields    CustomFields          `json:"custom_fields" db:"custom_fields"`
+	Phones          []ContactMethod       `json:"phones" db:"-"`
+	Emails          []ContactMethod       `json:"emails" db:"-"`
+	Addresses       []ContactMethod       `json:"addresses" db:"-"`
+	Latitude        *float64              `json:"latitude" db:"latitude"`
+	Longitude       *float64              `json:"longitude" db:"longitude"`
+	LocationSource  string                `json:"location_source" db:"location_source"`
+	OrganizationId  *int                  `json:"organization_id" db:"organization_id"`
+	Organization    *string               `json:"organization" db:"organization"`
+	JobTitle        string                `json:"job_title" db:"job_title"`
+	LastContactedAt *time.Time            `json:"last_contacted_at" db:"last_contacted_at"`
+	DistanceKm      *float64              `json:"distance_km,omitempty" db:"distance_km"`
+	Relationships   []ContactRelationship `json:"relationships,omitempty" db:"-"`
+	Dates           []ContactDate         `json:"dates,omitempty" db:"-"`
+	CreatedAt       time.Time             `json:"created_at" db:"created_at"`
 }
 
 type ContactStorage struct {
-	DB *sqlx.DB
+	DB       *sqlx.DB
+	Geocoder *geocode.Gazetteer
 }
 
-func NewContactStorage(DB *sqlx.DB) *ContactStorage {
-	return &ContactStorage{DB: DB}
+func NewContactStorage(DB *sqlx.DB, geocoder *geocode.Gazetteer) *ContactStorage {
+	return &ContactStorage{DB: DB, Geocoder: geocoder}
 }
 
+const contactColumns = "c.id, c.name, c.phone, c.email, c.address, c.category_id, cat.label as category, c.custom_fields, c.latitude, c.longitude, c.location_source, c.organization_id, org.name as organization, c.job_title, c.last_contacted_at, c.created_at"
+
+// contactJoins are the joins contactColumns relies on.
+const contactJoins = `
+		LEFT JOIN categories cat ON c.category_id = cat.id
+		LEFT JOIN organizations org ON c.organization_id = org.id
+`
+
+// CreateContact stores a new contact with its phones, emails and addresses.
+// When no lists are given the single phone, email and address become the
+// primary entries; otherwise the primary entries fill the single fields.
+// Data that cannot be saved fails with ErrInvalidContact, and emails used by
+// other contacts with ErrEmailExists.
 func (storage *ContactStorage) CreateContact(data NewContactInput) (int, error) {
-	categoryId, err := GetCategoryIdByLabel(storage.DB, data.Label)
+	defer clearCategoryStats()
+
+	tx, err := storage.DB.Beginx()
+	if err != nil {
+		return 0, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	id, err := storage.createContact(tx, data)
 	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error creating contact: %v", err)
+	}
+
+	return id, nil
+}
+
+func (storage *ContactStorage) createContact(tx *sqlx.Tx, data NewContactInput) (int, error) {
+	if strings.TrimSpace(data.Name) == "" {
+		return 0, fmt.Errorf("%w: name cannot be empty", ErrInvalidContact)
+	}
+
+	categoryId, err := GetCategoryIdByLabel(tx, data.Label)
+	if err != nil {
+		if errors.Is(err, ErrUnknownCategory) {
+			return 0, invalidContact(err)
+		}
 		return 0, fmt.Errorf("error fetching category id: %v", err)
 	}
 
-	var id int
-	checkStmt := "SELECT id FROM contacts WHERE email = $1"
-	err = storage.DB.QueryRow(checkStmt, data.Email).Scan(&id)
-
-	switch {
-	case err == sql.ErrNoRows:
-		insertStmt := `
-			INSERT INTO contacts (name, phone, email, address, category_id)
-			VALUES ($1, $2, $3, $4, $5)
-			RETURNING id
-		`
-		err = storage.DB.QueryRow(insertStmt, data.Name, data.Phone, data.Email, data.Address, categoryId).Scan(&id)
-		if err != nil {
-			return 0, fmt.Errorf("error creating contact: %v", err)
+	defs, err := getCategoryFields(tx, categoryId)
+	if err != nil {
+		return 0, err
+	}
+
+	customFields, err := ValidateCustomFields(defs, data.CustomFields)
+	if err != nil {
+		return 0, invalidContact(err)
+	}
+
+	phones, err := normalizeMethods("phone", data.Phones, data.Phone)
+	if err != nil {
+		return 0, invalidContact(err)
+	}
+	emails, err := normalizeMethods("email", data.Emails, data.Email)
+	if err != nil {
+		return 0, invalidContact(err)
+	}
+	addresses, err := normalizeMethods("address", data.Addresses, data.Address)
+	if err != nil {
+		return 0, invalidContact(err)
+	}
+
+	if err := checkEmailsAvailable(tx, emails, 0); err != nil {
+		return 0, err
+	}
+
+	var organizationId interface{}
+	if data.OrganizationId != 0 {
+		if err := checkOrganizationExists(tx, data.OrganizationId); err != nil {
+			return 0, err
 		}
-		return id, nil
-	case err != nil:
-		return 0, fmt.Errorf("error checking email existence: %v", err)
-	default:
-		return 0, fmt.Errorf("email '%s' already exists", data.Email)
+		organizationId = data.OrganizationId
+	}
+
+	var id int
+	insertStmt := `
+		INSERT INTO contacts (name, phone, email, address, category_id, custom_fields, organization_id, job_title)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id
+	`
+	err = tx.QueryRow(insertStmt, data.Name, primaryValue(phones), primaryValue(emails), primaryValue(addresses), categoryId, customFields, organizationId, data.JobTitle).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("error creating contact: %v", err)
+	}
+
+	if err := replaceMethods(tx, phonesTable, id, phones); err != nil {
+		return 0, err
 	}
+	if err := replaceMethods(tx, emailsTable, id, emails); err != nil {
+		return 0, err
+	}
+	if err := replaceMethods(tx, addressesTable, id, addresses); err != nil {
+		return 0, err
+	}
+
+	if err := storage.geocodeContact(tx, id); err != nil {
+		return 0, err
+	}
+
+	return id, nil
 }
 
 func (storage *ContactStorage) GetContact(id int) (Contact_, error) {
 	var contact Contact_
 	selectStmt := `
-		SELECT c.id, c.name, c.phone, c.email, c.address, c.category_id, cat.label as category, c.created_at 
-		FROM contacts c
-		LEFT JOIN categories cat ON c.category_id = cat.id
+		SELECT ` + contactColumns + `
+		FROM contacts c` + contactJoins + `
 		WHERE c.id = $1
 	`
-	if err := storage.DB.Get(&contact, selectStmt, id); err != nil {
+	err := storage.DB.Get(&contact, selectStmt, id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return contact, err
+		}
 		return contact, fmt.Errorf("error fetching contact: %v", err)
 	}
-	return contact, nil
+
+	contacts := []Contact_{contact}
+	if err := attachContactMethods(storage.DB, contacts); err != nil {
+		return contact, err
+	}
+
+	contacts[0].Relationships, err = getContactRelationships(storage.DB, id)
+	if err != nil {
+		return contact, err
+	}
+
+	contacts[0].Dates, err = getContactDates(storage.DB, id)
+	if err != nil {
+		return contact, err
+	}
+	return contacts[0], nil
 }
 
+// DeleteContact removes a contact; its contact methods, relationships and
+// dates are removed with it by the database cascades.
 func (storage *ContactStorage) DeleteContact(id int) error {
+	defer clearCategoryStats()
+
+	return deleteContact(storage.DB, id)
+}
+
+func deleteContact(DB sqlx.Execer, id int) error {
 	deleteStmt := "DELETE FROM contacts WHERE id = $1"
-	if _, err := storage.DB.Exec(deleteStmt, id); err != nil {
+	resp, err := DB.Exec(deleteStmt, id)
+	if err != nil {
 		return fmt.Errorf("error deleting contact: %v", err)
 	}
+
+	rowsAffected, err := resp.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %v", err)
+	}
+
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
 	return nil
 }
 
-func (storage *ContactStorage) GetContacts(limit, offset int, name, email, category, sortDir string) ([]Contact_, error) {
+// ContactFilter holds the search filters shared by contact listing and statistics queries.
+type ContactFilter struct {
+	Name     string
+	Phone    string
+	Email    string
+	Category string
+	// CategoryId restricts results to a single category when not zero.
+	CategoryId int
+	City       string
+	Region     string
+	Country    string
+	// Organization matches organization names, OrganizationId a single organization.
+	Organization   string
+	OrganizationId int
+	// Near restricts results to contacts within RadiusKm of the point.
+	Near     *GeoPoint
+	RadiusKm float64
+	// CustomFields matches custom field values by key, compared as text.
+	CustomFields map[string]string
+	// Expression is a parsed filter expression, see ParseFilterExpression.
+	Expression filterexpr.Expr
+}
+
+// customFieldPrefix marks query parameters that filter on custom field values, e.g. cf.tax_id=123.
+const customFieldPrefix = "cf."
+
+// NewContactFilter builds a filter from request query parameters.
+func NewContactFilter(queries map[string]string) (ContactFilter, error) {
+	filter := ContactFilter{
+		Name:         queries["name"],
+		Phone:        queries["phone"],
+		Email:        queries["email"],
+		Category:     queries["category"],
+		City:         queries["city"],
+		Region:       queries["region"],
+		Country:      queries["country"],
+		Organization: queries["organization"],
+		CustomFields: customFieldFilters(queries),
+	}
+
+	if near := queries["near"]; near != "" {
+		point, err := ParseGeoPoint(near)
+		if err != nil {
+			return filter, err
+		}
+		filter.Near = &point
+		filter.RadiusKm = defaultRadiusKm
+	}
+
+	if expression := queries["filter"]; expression != "" {
+		expr, err := ParseFilterExpression(expression)
+		if err != nil {
+			return filter, err
+		}
+		filter.Expression = expr
+	}
+
+	if radius := queries["radius"]; radius != "" {
+		if filter.Near == nil {
+			return filter, fmt.Errorf("radius specified without near")
+		}
+		radiusKm, err := strconv.ParseFloat(radius, 64)
+		if err != nil || radiusKm <= 0 {
+			return filter, fmt.Errorf("invalid radius '%s'", radius)
+		}
+		filter.RadiusKm = radiusKm
+	}
+
+	return filter, nil
+}
+
+// customFieldFilters picks the custom field filters out of request query parameters.
+func customFieldFilters(queries map[string]string) map[string]string {
+	filters := map[string]string{}
+	for key, value := range queries {
+		if strings.HasPrefix(key, customFieldPrefix) && len(key) > len(customFieldPrefix) {
+			filters[strings.TrimPrefix(key, customFieldPrefix)] = value
+		}
+	}
+	return filters
+}
+
+// conditions returns SQL conditions for the filter over contacts aliased as c,
+// appending their arguments to args.
+func (filter ContactFilter) conditions(args *[]interface{}) []string {
+	conds := []string{}
+
+	if filter.Name != "" {
+		*args = append(*args, "%"+filter.Name+"%")
+		conds = append(conds, "c.name ILIKE $"+strconv.Itoa(len(*args)))
+	}
+
+	if filter.Phone != "" {
+		*args = append(*args, "%"+filter.Phone+"%")
+		conds = append(conds, "EXISTS (SELECT 1 FROM contact_phones p WHERE p.contact_id = c.id AND p.value ILIKE $"+strconv.Itoa(len(*args))+")")
+	}
+
+	if filter.Email != "" {
+		*args = append(*args, "%"+filter.Email+"%")
+		conds = append(conds, "EXISTS (SELECT 1 FROM contact_emails e WHERE e.contact_id = c.id AND e.value ILIKE $"+strconv.Itoa(len(*args))+")")
+	}
+
+	if filter.Category != "" {
+		*args = append(*args, "%"+filter.Category+"%")
+		conds = append(conds, "c.category_id IN (SELECT id FROM categories WHERE label ILIKE $"+strconv.Itoa(len(*args))+")")
+	}
+
+	if filter.CategoryId != 0 {
+		*args = append(*args, filter.CategoryId)
+		conds = append(conds, "c.category_id = $"+strconv.Itoa(len(*args)))
+	}
+
+	if filter.Organization != "" {
+		*args = append(*args, "%"+filter.Organization+"%")
+		conds = append(conds, "c.organization_id IN (SELECT id FROM organizations WHERE name ILIKE $"+strconv.Itoa(len(*args))+")")
+	}
+
+	if filter.OrganizationId != 0 {
+		*args = append(*args, filter.OrganizationId)
+		conds = append(conds, "c.organization_id = $"+strconv.Itoa(len(*args)))
+	}
+
+	addressFilters := []struct {
+		column string
+		value  string
+	}{
+		{"city", filter.City},
+		{"region", filter.Region},
+		{"country", filter.Country},
+	}
+	for _, address := range addressFilters {
+		if address.value == "" {
+			continue
+		}
+		*args = append(*args, address.value)
+		conds = append(conds, "EXISTS (SELECT 1 FROM contact_addresses a WHERE a.contact_id = c.id AND lower(a."+address.column+") = lower($"+strconv.Itoa(len(*args))+"))")
+	}
+
+	if filter.Near != nil {
+		*args = append(*args, filter.Near.Latitude, filter.Near.Longitude, filter.RadiusKm*1000)
+		center := "ll_to_earth($" + strconv.Itoa(len(*args)-2) + ", $" + strconv.Itoa(len(*args)-1) + ")"
+		radius := "$" + strconv.Itoa(len(*args))
+		conds = append(conds, "earth_box("+center+", "+radius+") @> ll_to_earth(c.latitude, c.longitude)")
+		conds = append(conds, "earth_distance("+center+", ll_to_earth(c.latitude, c.longitude)) <= "+radius)
+	}
+
+	// Keys are sorted so that equal filters build the same statement.
+	keys := make([]string, 0, len(filter.CustomFields))
+	for key := range filter.CustomFields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		*args = append(*args, key, filter.CustomFields[key])
+		conds = append(conds, "c.custom_fields ->> $"+strconv.Itoa(len(*args)-1)+" = $"+strconv.Itoa(len(*args)))
+	}
+
+	if filter.Expression != nil {
+		// Expressions are checked when parsed; should one still fail to
+		// compile, it matches nothing rather than being dropped.
+		exprArgs := append([]interface{}{}, *args...)
+		cond, err := compileExpression(filter.Expression, &exprArgs)
+		if err != nil {
+			cond = "false"
+		} else {
+			*args = exprArgs
+		}
+		conds = append(conds, cond)
+	}
+
+	return conds
+}
+
+// contactSortColumns maps the sortBy values accepted by GetContacts to columns.
+var contactSortColumns = map[string]string{
+	"created_at":        "c.created_at",
+	"last_contacted_at": "c.last_contacted_at",
+	"name":              "c.name",
+}
+
+func (storage *ContactStorage) GetContacts(limit, offset int, filter ContactFilter, sortBy, sortDir string) ([]Contact_, error) {
+	contacts, err := storage.selectContacts(contactColumns, contactJoins, limit, offset, filter, sortBy, sortDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := attachContactMethods(storage.DB, contacts); err != nil {
+		return nil, err
+	}
+
+	return contacts, nil
+}
+
+// selectContacts reads the given columns of the contacts matching a filter,
+// adding distance_km for proximity searches.
+func (storage *ContactStorage) selectContacts(columns, joins string, limit, offset int, filter ContactFilter, sortBy, sortDir string) ([]Contact_, error) {
 	var contacts []Contact_
+	args := []interface{}{}
+
+	if filter.Near != nil {
+		args = append(args, filter.Near.Latitude, filter.Near.Longitude)
+		columns += ", earth_distance(ll_to_earth($1, $2), ll_to_earth(c.latitude, c.longitude)) / 1000 AS distance_km"
+	}
+
 	stmt := `
-		SELECT c.id, c.name, c.phone, c.email, c.address, c.category_id, cat.label as category, c.created_at 
-		FROM contacts c
-		LEFT JOIN categories cat ON c.category_id = cat.id
+		SELECT ` + columns + `
+		FROM contacts c` + joins + `
 		WHERE 1=1
 	`
 
-	args := []interface{}{}
-	argCount := 1
-
-	if name != "" {
-		stmt += " AND c.name ILIKE $" + strconv.Itoa(argCount)
-		args = append(args, "%"+name+"%")
-		argCount++
+	for _, cond := range filter.conditions(&args) {
+		stmt += " AND " + cond
 	}
 
-	if email != "" {
-		stmt += " AND c.email ILIKE $" + strconv.Itoa(argCount)
-		args = append(args, "%"+email+"%")
-		argCount++
+	switch strings.ToUpper(sortDir) {
+	case "", "ASC", "DESC":
+	default:
+		return nil, fmt.Errorf("invalid sort direction '%s'", sortDir)
 	}
 
-	if category != "" {
-		stmt += `
-			AND c.category_id IN (
-				SELECT id FROM categories WHERE label ILIKE $` + strconv.Itoa(argCount) + `
-			)
-		`
-		args = append(args, "%"+category+"%")
-		argCount++
+	if sortBy == "" {
+		sortBy = "created_at"
+	}
+	sortColumn, ok := contactSortColumns[sortBy]
+	if !ok {
+		return nil, fmt.Errorf("invalid sort field '%s'", sortBy)
 	}
 
-	if sortDir != "" {
-		stmt += " ORDER BY c.created_at " + sortDir
+	if filter.Near != nil {
+		stmt += " ORDER BY distance_km, c.id"
+	} else if sortDir != "" {
+		stmt += " ORDER BY " + sortColumn + " " + strings.ToUpper(sortDir) + " NULLS LAST, c.id"
 	}
 
 	if limit > 0 {
-		stmt += " LIMIT $" + strconv.Itoa(argCount)
 		args = append(args, limit)
-		argCount++
+		stmt += " LIMIT $" + strconv.Itoa(len(args))
 	}
 
 	if offset > 0 {
 		if limit <= 0 {
 			return nil, fmt.Errorf("offset specified without limit")
 		}
-		stmt += " OFFSET $" + strconv.Itoa(argCount)
 		args = append(args, offset)
-		argCount++
-	}
-
-	if offset > 0 {
-		if limit <= 0 {
-			return nil, fmt.Errorf("offset specified without limit")
-		}
-		stmt += " OFFSET $" + strconv.Itoa(argCount)
-		args = append(args, offset)
-		argCount++
+		stmt += " OFFSET $" + strconv.Itoa(len(args))
 	}
 
 	err := storage.DB.Select(&contacts, stmt, args...)
@@ -168,13 +512,64 @@ func (storage *ContactStorage) GetContacts(limit, offset int, name, email, categ
 	return contacts, nil
 }
 
-func (storage *ContactStorage) UpdateContact(id int, name, phone, email, address, category string) error {
-	var temp int
-	checkStmt := "SELECT id FROM contacts WHERE email = $1"
-	err := storage.DB.QueryRow(checkStmt, email).Scan(&temp)
+// CountContacts returns the number of contacts matching a filter.
+func (storage *ContactStorage) CountContacts(filter ContactFilter) (int, error) {
+	args := []interface{}{}
+	stmt := "SELECT COUNT(*) FROM contacts c WHERE 1=1"
+	for _, cond := range filter.conditions(&args) {
+		stmt += " AND " + cond
+	}
+
+	var count int
+	if err := storage.DB.QueryRow(stmt, args...).Scan(&count); err != nil {
+		return 0, fmt.Errorf("error counting contacts: %v", err)
+	}
+	return count, nil
+}
+
+// CountContactsByFilters returns the number of contacts matching each of the
+// filters, counted in a single pass over contacts.
+func (storage *ContactStorage) CountContactsByFilters(filters []ContactFilter) ([]int, error) {
+	if len(filters) == 0 {
+		return []int{}, nil
+	}
+
+	args := []interface{}{}
+	counts := []string{}
+	for _, filter := range filters {
+		conds := append([]string{"true"}, filter.conditions(&args)...)
+		counts = append(counts, "COUNT(*) FILTER (WHERE "+strings.Join(conds, " AND ")+")")
+	}
+
+	result := make([]int, len(filters))
+	dest := make([]interface{}, len(filters))
+	for i := range result {
+		dest[i] = &result[i]
+	}
+
+	stmt := "SELECT " + strings.Join(counts, ", ") + " FROM contacts c"
+	if err := storage.DB.QueryRow(stmt, args...).Scan(dest...); err != nil {
+		return nil, fmt.Errorf("error counting contacts: %v", err)
+	}
+	return result, nil
+}
+
+// updateContact changes the non-empty fields of a contact. Custom fields are
+// replaced when customFields is not nil, and re-validated whenever either they
+// or the category change.
+func (storage *ContactStorage) updateContact(tx *sqlx.Tx, id int, name, phone, email, address, category string, customFields CustomFields) error {
+	var exists int
+	if err := tx.QueryRow("SELECT id FROM contacts WHERE id = $1 FOR UPDATE", id).Scan(&exists); err != nil {
+		if err == sql.ErrNoRows {
+			return err
+		}
+		return fmt.Errorf("error fetching contact: %v", err)
+	}
 
-	if err == nil {
-		return fmt.Errorf("email '%s' already exists", email)
+	if email != "" {
+		if err := checkEmailsAvailable(tx, []ContactMethodInput{{Value: email}}, id); err != nil {
+			return err
+		}
 	}
 
 	stmt := "UPDATE contacts SET"
@@ -182,25 +577,52 @@ func (storage *ContactStorage) UpdateContact(id int, name, phone, email, address
 	args = append(args, id)
 
 	if name != "" {
-		stmt += " name = $2,"
+		stmt += " name = $" + strconv.Itoa(len(args)+1) + ","
 		args = append(args, name)
 	}
 	if phone != "" {
-		stmt += " phone = $3,"
+		stmt += " phone = $" + strconv.Itoa(len(args)+1) + ","
 		args = append(args, phone)
 	}
 	if address != "" {
-		stmt += " address = $4,"
+		stmt += " address = $" + strconv.Itoa(len(args)+1) + ","
 		args = append(args, address)
 	}
-	if category != "" {
-		categoryId, err := GetCategoryIdByLabel(storage.DB, category)
+	if category != "" || customFields != nil {
+		var current Contact
+		currentStmt := "SELECT category_id, custom_fields FROM contacts WHERE id = $1"
+		if err := tx.Get(&current, currentStmt, id); err != nil {
+			return fmt.Errorf("error fetching contact: %v", err)
+		}
+
+		categoryId := current.CategoryId
+		if category != "" {
+			var err error
+			categoryId, err = GetCategoryIdByLabel(tx, category)
+			if err != nil {
+				return fmt.Errorf("error fetching category id: %v", err)
+			}
+
+			stmt += " category_id = $" + strconv.Itoa(len(args)+1) + ","
+			args = append(args, categoryId)
+		}
+
+		if customFields == nil {
+			customFields = current.CustomFields
+		}
+
+		defs, err := getCategoryFields(tx, categoryId)
+		if err != nil {
+			return err
+		}
+
+		customFields, err = ValidateCustomFields(defs, customFields)
 		if err != nil {
-			return fmt.Errorf("error fetching category id: %v", err)
+			return err
 		}
 
-		stmt += " category_id = $" + strconv.Itoa(len(args)+1) + ","
-		args = append(args, categoryId)
+		stmt += " custom_fields = $" + strconv.Itoa(len(args)+1) + ","
+		args = append(args, customFields)
 	}
 	if email != "" {
 		stmt += " email = $" + strconv.Itoa(len(args)+1) + ","
@@ -210,9 +632,33 @@ func (storage *ContactStorage) UpdateContact(id int, name, phone, email, address
 	stmt = strings.TrimSuffix(stmt, ",")
 	stmt += " WHERE id = $1"
 
-	_, err = storage.DB.Exec(stmt, args...)
-	if err != nil {
-		return fmt.Errorf("error updating contact: %v", err)
+	if len(args) > 1 {
+		if _, err := tx.Exec(stmt, args...); err != nil {
+			return fmt.Errorf("error updating contact: %v", err)
+		}
+	}
+
+	primaries := []struct {
+		table string
+		value string
+	}{
+		{phonesTable, phone},
+		{emailsTable, email},
+		{addressesTable, address},
+	}
+	for _, primary := range primaries {
+		if primary.value == "" {
+			continue
+		}
+		if err := setPrimaryMethod(tx, primary.table, id, primary.value); err != nil {
+			return err
+		}
+	}
+
+	if address != "" {
+		if err := storage.geocodeContact(tx, id); err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -0,0 +1,166 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+var dateTypes = []string{"birthday", "anniversary", "contract_renewal"}
+
+// maxUpcomingDays bounds upcoming date queries to one full year.
+const maxUpcomingDays = 366
+
+// ContactDate is a recurring date of a contact. Year is nil when unknown.
+type ContactDate struct {
+	Id        int       `json:"id" db:"id"`
+	Type      string    `json:"type" db:"type" enums:"birthday,anniversary,contract_renewal"`
+	Label     string    `json:"label" db:"label"`
+	Month     int       `json:"month" db:"month"`
+	Day       int       `json:"day" db:"day"`
+	Year      *int      `json:"year" db:"year"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+type NewContactDateInput struct {
+	Type  string
+	Label string
+	Month int
+	Day   int
+	Year  *int
+}
+
+// UpcomingDate is the next occurrence of a contact date. February 29 falls on
+// February 28 in common years. Years counts the years since the original date
+// and is nil when its year is unknown.
+type UpcomingDate struct {
+	Id          int    `json:"id" db:"id"`
+	ContactId   int    `json:"contact_id" db:"contact_id"`
+	ContactName string `json:"contact_name" db:"contact_name"`
+	Type        string `json:"type" db:"type"`
+	Label       string `json:"label" db:"label"`
+	Month       int    `json:"month" db:"month"`
+	Day         int    `json:"day" db:"day"`
+	Year        *int   `json:"year" db:"year"`
+	Date        string `json:"date" db:"date"`
+	DaysUntil   int    `json:"days_until" db:"days_until"`
+	Years       *int   `json:"years" db:"years"`
+}
+
+// validateContactDate checks the date exists, in a leap year when its year is unknown.
+func validateContactDate(data NewContactDateInput) error {
+	if !containsString(dateTypes, data.Type) {
+		return fmt.Errorf("invalid date type '%s', expected one of %v", data.Type, dateTypes)
+	}
+
+	year := 2000
+	if data.Year != nil {
+		year = *data.Year
+		if year < 1 || year > 9999 {
+			return fmt.Errorf("invalid year %d", year)
+		}
+	}
+
+	date := time.Date(year, time.Month(data.Month), data.Day, 0, 0, 0, 0, time.UTC)
+	if data.Month < 1 || data.Month > 12 || date.Month() != time.Month(data.Month) || date.Day() != data.Day {
+		return fmt.Errorf("invalid date %04d-%02d-%02d", year, data.Month, data.Day)
+	}
+
+	return nil
+}
+
+func getContactDates(DB sqlx.Queryer, id int) ([]ContactDate, error) {
+	dates := []ContactDate{}
+	stmt := "SELECT id, type, label, month, day, year, created_at FROM contact_dates WHERE contact_id = $1 ORDER BY month, day, id"
+	if err := sqlx.Select(DB, &dates, stmt, id); err != nil {
+		return nil, fmt.Errorf("error fetching contact dates: %v", err)
+	}
+	return dates, nil
+}
+
+func (storage *ContactStorage) AddContactDate(id int, data NewContactDateInput) (int, error) {
+	if err := validateContactDate(data); err != nil {
+		return 0, err
+	}
+
+	var exists int
+	if err := storage.DB.QueryRow("SELECT id FROM contacts WHERE id = $1", id).Scan(&exists); err != nil {
+		if err == sql.ErrNoRows {
+			return 0, err
+		}
+		return 0, fmt.Errorf("error fetching contact: %v", err)
+	}
+
+	var dateId int
+	insertStmt := `
+		INSERT INTO contact_dates (contact_id, type, label, month, day, year)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id
+	`
+	err := storage.DB.QueryRow(insertStmt, id, data.Type, data.Label, data.Month, data.Day, data.Year).Scan(&dateId)
+	if err != nil {
+		return 0, fmt.Errorf("error adding contact date: %v", err)
+	}
+
+	return dateId, nil
+}
+
+func (storage *ContactStorage) DeleteContactDate(id, dateId int) error {
+	deleteStmt := "DELETE FROM contact_dates WHERE id = $1 AND contact_id = $2"
+	resp, err := storage.DB.Exec(deleteStmt, dateId, id)
+	if err != nil {
+		return fmt.Errorf("error deleting contact date: %v", err)
+	}
+
+	rowsAffected, err := resp.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %v", err)
+	}
+
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// GetUpcomingDates lists contact dates occurring within days after from,
+// from included, soonest first. Dates with a year do not recur before it.
+func (storage *ContactStorage) GetUpcomingDates(from time.Time, days int, dateType string) ([]UpcomingDate, error) {
+	if days < 0 || days > maxUpcomingDays {
+		return nil, fmt.Errorf("days must be between 0 and %d", maxUpcomingDays)
+	}
+	if dateType != "" && !containsString(dateTypes, dateType) {
+		return nil, fmt.Errorf("invalid date type '%s', expected one of %v", dateType, dateTypes)
+	}
+
+	upcoming := []UpcomingDate{}
+	stmt := `
+		SELECT d.id, d.contact_id, c.name AS contact_name, d.type, d.label, d.month, d.day, d.year,
+			to_char(n.date, 'YYYY-MM-DD') AS date,
+			n.date - $1::date AS days_until,
+			extract(year FROM n.date)::integer - d.year AS years
+		FROM contact_dates d
+		JOIN contacts c ON c.id = d.contact_id
+		CROSS JOIN LATERAL (SELECT next_anniversary(d.month, d.day, $1::date) AS date) n
+		WHERE n.date <= $1::date + $2::integer
+			AND (d.year IS NULL OR extract(year FROM n.date) >= d.year)
+	`
+	args := []interface{}{from.Format("2006-01-02"), days}
+
+	if dateType != "" {
+		args = append(args, dateType)
+		stmt += " AND d.type = $" + strconv.Itoa(len(args))
+	}
+
+	stmt += " ORDER BY n.date, c.name, d.id"
+
+	if err := storage.DB.Select(&upcoming, stmt, args...); err != nil {
+		return nil, fmt.Errorf("error fetching upcoming dates: %v", err)
+	}
+
+	return upcoming, nil
+}
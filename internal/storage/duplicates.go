@@ -0,0 +1,380 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/lib/pq"
+)
+
+// LikelyDuplicateScore is the score from which two contacts are reported as
+// likely duplicates.
+const LikelyDuplicateScore = 0.5
+
+// Weights of the duplicate signals, they add up to 1.
+const (
+	phoneWeight   = 0.35
+	emailWeight   = 0.25
+	nameWeight    = 0.3
+	addressWeight = 0.1
+)
+
+// minNameSimilarity is the similarity below which names count as different.
+const minNameSimilarity = 0.7
+
+// phoneDigits is the number of trailing digits compared, so numbers match
+// with or without a country code.
+const phoneDigits = 9
+
+// DuplicateMatch is a contact resembling another one. Reasons lists the
+// matching signals: phone, email, name and address.
+type DuplicateMatch struct {
+	ContactId   int      `json:"contact_id"`
+	ContactName string   `json:"contact_name"`
+	Score       float64  `json:"score"`
+	Reasons     []string `json:"reasons"`
+}
+
+// DuplicatePair is a scored pair of contacts inside a cluster.
+type DuplicatePair struct {
+	ContactId      int      `json:"contact_id"`
+	OtherContactId int      `json:"other_contact_id"`
+	Score          float64  `json:"score"`
+	Reasons        []string `json:"reasons"`
+}
+
+type DuplicateMember struct {
+	Id   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// DuplicateCluster groups contacts linked by likely duplicate pairs. Score is
+// the highest pair score of the cluster.
+type DuplicateCluster struct {
+	Score    float64           `json:"score"`
+	Contacts []DuplicateMember `json:"contacts"`
+	Pairs    []DuplicatePair   `json:"pairs"`
+}
+
+// duplicateRecord holds the normalized values a contact is compared on.
+type duplicateRecord struct {
+	Id        int            `db:"id"`
+	Name      string         `db:"name"`
+	Phones    pq.StringArray `db:"phones"`
+	Emails    pq.StringArray `db:"emails"`
+	Addresses pq.StringArray `db:"addresses"`
+}
+
+// duplicateRecordsStmt selects contacts with their normalized phones, email
+// local parts and addresses. Phones and emails are read from their match_key
+// columns, which the database derives like normalizePhone and emailLocalPart;
+// addresses are normalized like normalizeText.
+const duplicateRecordsStmt = `
+	SELECT c.id, c.name,
+		ARRAY(SELECT p.match_key FROM contact_phones p WHERE p.contact_id = c.id) AS phones,
+		ARRAY(SELECT e.match_key FROM contact_emails e WHERE e.contact_id = c.id) AS emails,
+		ARRAY(SELECT btrim(regexp_replace(lower(a.value), '[^[:alnum:]]+', ' ', 'g')) FROM contact_addresses a WHERE a.contact_id = c.id) AS addresses
+	FROM contacts c
+`
+
+// Phones and email local parts shorter than these are too common to link
+// contacts on their own.
+const (
+	minPhoneKeyLength = 7
+	minEmailKeyLength = 3
+)
+
+// duplicatePairsStmt lists the pairs of contacts worth scoring: those sharing
+// a phone or an email local part, and those with similar names by trigrams.
+// Every branch is served by an index, so the contact book is never compared
+// pairwise.
+const duplicatePairsStmt = `
+	SELECT p.contact_id AS contact_id, o.contact_id AS other_id
+	FROM contact_phones p
+	JOIN contact_phones o ON o.match_key = p.match_key AND o.contact_id > p.contact_id
+	WHERE length(p.match_key) >= $1
+	UNION
+	SELECT e.contact_id, o.contact_id
+	FROM contact_emails e
+	JOIN contact_emails o ON o.match_key = e.match_key AND o.contact_id > e.contact_id
+	WHERE length(e.match_key) >= $2
+	UNION
+	SELECT c.id, o.id
+	FROM contacts c
+	JOIN contacts o ON lower(o.name) % lower(c.name) AND o.id > c.id
+`
+
+func normalizePhone(phone string) string {
+	digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, phone)
+	if len(digits) > phoneDigits {
+		digits = digits[len(digits)-phoneDigits:]
+	}
+	return digits
+}
+
+// emailLocalPart returns the lowercased part before @ without +tags and dots.
+func emailLocalPart(email string) string {
+	local := strings.ToLower(email)
+	if i := strings.Index(local, "@"); i >= 0 {
+		local = local[:i]
+	}
+	if i := strings.Index(local, "+"); i >= 0 {
+		local = local[:i]
+	}
+	return strings.ReplaceAll(local, ".", "")
+}
+
+// normalizeText lowercases s and collapses everything but letters and digits
+// into single spaces.
+func normalizeText(s string) string {
+	return strings.Join(strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}), " ")
+}
+
+// nameKey normalizes a name with its words sorted, so "Doe John" equals "John Doe".
+func nameKey(name string) string {
+	words := strings.Fields(normalizeText(name))
+	sort.Strings(words)
+	return strings.Join(words, " ")
+}
+
+// levenshtein returns the edit distance between a and b in runes.
+func levenshtein(a, b []rune) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
+
+// nameSimilarity returns 1 for identical names down to 0 for unrelated ones.
+func nameSimilarity(a, b string) float64 {
+	ra, rb := []rune(nameKey(a)), []rune(nameKey(b))
+	longest := max(len(ra), len(rb))
+	if longest == 0 {
+		return 0
+	}
+	return 1 - float64(levenshtein(ra, rb))/float64(longest)
+}
+
+func sharesValue(a, b []string, minLength int) bool {
+	for _, x := range a {
+		if len(x) < minLength {
+			continue
+		}
+		for _, y := range b {
+			if x == y {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// scoreDuplicate scores how likely two contacts are the same person.
+func scoreDuplicate(a, b duplicateRecord) (float64, []string) {
+	score := 0.0
+	reasons := []string{}
+
+	if sharesValue(a.Phones, b.Phones, minPhoneKeyLength) {
+		score += phoneWeight
+		reasons = append(reasons, "phone")
+	}
+	if sharesValue(a.Emails, b.Emails, minEmailKeyLength) {
+		score += emailWeight
+		reasons = append(reasons, "email")
+	}
+	if similarity := nameSimilarity(a.Name, b.Name); similarity >= minNameSimilarity {
+		score += nameWeight * similarity
+		reasons = append(reasons, "name")
+	}
+	if sharesValue(a.Addresses, b.Addresses, 1) {
+		score += addressWeight
+		reasons = append(reasons, "address")
+	}
+
+	return float64(int(score*100+0.5)) / 100, reasons
+}
+
+// blockingValues returns the values at least minLength long.
+func blockingValues(values []string, minLength int) []string {
+	result := []string{}
+	for _, value := range values {
+		if len(value) >= minLength {
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
+// FindContactDuplicates returns the contacts resembling a contact with at
+// least minScore, best match first.
+func (storage *ContactStorage) FindContactDuplicates(id int, minScore float64) ([]DuplicateMatch, error) {
+	var records []duplicateRecord
+	if err := storage.DB.Select(&records, duplicateRecordsStmt+" WHERE c.id = $1", id); err != nil {
+		return nil, fmt.Errorf("error fetching contact: %v", err)
+	}
+	matches := []DuplicateMatch{}
+	if len(records) == 0 {
+		return matches, nil
+	}
+	contact := records[0]
+
+	candidatesStmt := duplicateRecordsStmt + `
+		WHERE c.id <> $1 AND c.id IN (
+			SELECT contact_id FROM contact_phones WHERE match_key = ANY($2)
+			UNION
+			SELECT contact_id FROM contact_emails WHERE match_key = ANY($3)
+			UNION
+			SELECT id FROM contacts WHERE lower(name) % lower($4)
+		)
+	`
+	phones := blockingValues(contact.Phones, minPhoneKeyLength)
+	emails := blockingValues(contact.Emails, minEmailKeyLength)
+	var candidates []duplicateRecord
+	err := storage.DB.Select(&candidates, candidatesStmt, id, pq.Array(phones), pq.Array(emails), contact.Name)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching duplicate candidates: %v", err)
+	}
+
+	for _, candidate := range candidates {
+		score, reasons := scoreDuplicate(contact, candidate)
+		if score >= minScore {
+			matches = append(matches, DuplicateMatch{
+				ContactId:   candidate.Id,
+				ContactName: candidate.Name,
+				Score:       score,
+				Reasons:     reasons,
+			})
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Score > matches[j].Score
+	})
+	return matches, nil
+}
+
+// FindDuplicateClusters scans the contact book for pairs scoring at least
+// minScore and groups them into clusters, highest score first. Only contacts
+// sharing a phone or an email local part, or with similar names, are
+// compared, and only those are loaded.
+func (storage *ContactStorage) FindDuplicateClusters(minScore float64) ([]DuplicateCluster, error) {
+	var candidatePairs []struct {
+		ContactId int `db:"contact_id"`
+		OtherId   int `db:"other_id"`
+	}
+	if err := storage.DB.Select(&candidatePairs, duplicatePairsStmt, minPhoneKeyLength, minEmailKeyLength); err != nil {
+		return nil, fmt.Errorf("error fetching duplicate candidates: %v", err)
+	}
+
+	ids := []int{}
+	seen := map[int]bool{}
+	for _, pair := range candidatePairs {
+		for _, id := range []int{pair.ContactId, pair.OtherId} {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+
+	var records []duplicateRecord
+	if err := storage.DB.Select(&records, duplicateRecordsStmt+" WHERE c.id = ANY($1) ORDER BY c.id", pq.Array(ids)); err != nil {
+		return nil, fmt.Errorf("error fetching contacts: %v", err)
+	}
+	index := make(map[int]int, len(records))
+	for i, record := range records {
+		index[record.Id] = i
+	}
+
+	// parent is a union-find forest over record indexes.
+	parent := make([]int, len(records))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	pairs := []DuplicatePair{}
+	pairRoots := []int{}
+	for _, candidate := range candidatePairs {
+		x, okX := index[candidate.ContactId]
+		y, okY := index[candidate.OtherId]
+		if !okX || !okY {
+			// Deleted since the pairs were listed.
+			continue
+		}
+
+		score, reasons := scoreDuplicate(records[x], records[y])
+		if score < minScore {
+			continue
+		}
+		parent[find(y)] = find(x)
+		pairs = append(pairs, DuplicatePair{
+			ContactId:      records[x].Id,
+			OtherContactId: records[y].Id,
+			Score:          score,
+			Reasons:        reasons,
+		})
+		pairRoots = append(pairRoots, x)
+	}
+
+	clusters := map[int]*DuplicateCluster{}
+	for i, pair := range pairs {
+		root := find(pairRoots[i])
+		cluster, ok := clusters[root]
+		if !ok {
+			cluster = &DuplicateCluster{}
+			clusters[root] = cluster
+		}
+		cluster.Pairs = append(cluster.Pairs, pair)
+		cluster.Score = max(cluster.Score, pair.Score)
+	}
+	for i, record := range records {
+		if cluster, ok := clusters[find(i)]; ok {
+			cluster.Contacts = append(cluster.Contacts, DuplicateMember{Id: record.Id, Name: record.Name})
+		}
+	}
+
+	result := []DuplicateCluster{}
+	for _, cluster := range clusters {
+		sort.Slice(cluster.Pairs, func(i, j int) bool {
+			return cluster.Pairs[i].Score > cluster.Pairs[j].Score
+		})
+		result = append(result, *cluster)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Score != result[j].Score {
+			return result[i].Score > result[j].Score
+		}
+		return result[i].Contacts[0].Id < result[j].Contacts[0].Id
+	})
+
+	return result, nil
+}
@@ -0,0 +1,132 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// exportColumns maps the columns an export can include to SQL expressions over
+// contacts aliased as c with contactJoins. Custom fields are exported with
+// their key prefixed by "cf.".
+var exportColumns = map[string]string{
+	"id":                "c.id::text",
+	"name":              "c.name",
+	"phone":             "c.phone",
+	"email":             "c.email",
+	"address":           "c.address",
+	"category":          "cat.label",
+	"organization":      "org.name",
+	"job_title":         "c.job_title",
+	"latitude":          "c.latitude::text",
+	"longitude":         "c.longitude::text",
+	"last_contacted_at": `to_char(c.last_contacted_at, 'YYYY-MM-DD"T"HH24:MI:SS')`,
+	"created_at":        `to_char(c.created_at, 'YYYY-MM-DD"T"HH24:MI:SS')`,
+}
+
+// DefaultExportColumns are exported when no columns are requested.
+var DefaultExportColumns = []string{"name", "phone", "email", "address", "category"}
+
+// ContactExport iterates over exported contacts one row at a time, so exports
+// never hold the whole list in memory. It must be closed.
+type ContactExport struct {
+	Columns []string
+	rows    *sql.Rows
+	values  []sql.NullString
+}
+
+// ExportContacts starts an export of the contacts matching the filter, ordered
+// by id, with the given columns.
+func (storage *ContactStorage) ExportContacts(filter ContactFilter, columns []string) (*ContactExport, error) {
+	if len(columns) == 0 {
+		columns = DefaultExportColumns
+	}
+
+	args := []interface{}{}
+	selects := make([]string, len(columns))
+	for i, column := range columns {
+		if key, ok := strings.CutPrefix(column, customFieldPrefix); ok && key != "" {
+			args = append(args, key)
+			selects[i] = "c.custom_fields ->> $" + strconv.Itoa(len(args))
+			continue
+		}
+		expr, ok := exportColumns[column]
+		if !ok {
+			return nil, fmt.Errorf("invalid export column '%s'", column)
+		}
+		selects[i] = expr
+	}
+
+	stmt := "SELECT " + strings.Join(selects, ", ") + " FROM contacts c" + contactJoins
+	if conds := filter.conditions(&args); len(conds) > 0 {
+		stmt += " WHERE " + strings.Join(conds, " AND ")
+	}
+	stmt += " ORDER BY c.id"
+
+	rows, err := storage.DB.Query(stmt, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error exporting contacts: %v", err)
+	}
+
+	return &ContactExport{
+		Columns: columns,
+		rows:    rows,
+		values:  make([]sql.NullString, len(columns)),
+	}, nil
+}
+
+// Next advances to the next contact, returning false at the end or on error.
+func (export *ContactExport) Next() bool {
+	return export.rows.Next()
+}
+
+// Row returns the values of the current contact, empty for missing values.
+func (export *ContactExport) Row() ([]string, error) {
+	dest := make([]interface{}, len(export.values))
+	for i := range export.values {
+		dest[i] = &export.values[i]
+	}
+	if err := export.rows.Scan(dest...); err != nil {
+		return nil, fmt.Errorf("error reading contact: %v", err)
+	}
+
+	row := make([]string, len(export.values))
+	for i, value := range export.values {
+		row[i] = value.String
+	}
+	return row, nil
+}
+
+// Err returns the error that stopped the iteration, if any.
+func (export *ContactExport) Err() error {
+	return export.rows.Err()
+}
+
+func (export *ContactExport) Close() error {
+	return export.rows.Close()
+}
+
+// ExportContactPage returns up to limit contacts matching the filter with an id
+// above afterId, ordered by id and with their methods attached. Paging on the id
+// keeps long exports consistent while contacts are added or removed.
+func (storage *ContactStorage) ExportContactPage(filter ContactFilter, afterId, limit int) ([]Contact_, error) {
+	args := []interface{}{afterId}
+	stmt := "SELECT " + contactColumns + " FROM contacts c" + contactJoins + " WHERE c.id > $1"
+	for _, cond := range filter.conditions(&args) {
+		stmt += " AND " + cond
+	}
+	args = append(args, limit)
+	stmt += " ORDER BY c.id LIMIT $" + strconv.Itoa(len(args))
+
+	var contacts []Contact_
+	if err := storage.DB.Select(&contacts, stmt, args...); err != nil {
+		return nil, fmt.Errorf("error exporting contacts: %v", err)
+	}
+
+	if err := attachContactMethods(storage.DB, contacts); err != nil {
+		return nil, err
+	}
+
+	return contacts, nil
+}
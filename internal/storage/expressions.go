@@ -0,0 +1,262 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/utah1280/backend-internship-2024/internal/filterexpr"
+)
+
+// expressionField is a contact field usable in filter expressions. Fields
+// with a table have one value per row of it, matching when any row does;
+// the others read column from contacts aliased as c.
+type expressionField struct {
+	kind   filterexpr.Kind
+	column string
+	table  string
+}
+
+var expressionFields = map[string]expressionField{
+	"id":                {kind: filterexpr.KindNumber, column: "c.id"},
+	"name":              {kind: filterexpr.KindString, column: "c.name"},
+	"category":          {kind: filterexpr.KindString, column: "(SELECT label FROM categories WHERE id = c.category_id)"},
+	"organization":      {kind: filterexpr.KindString, column: "(SELECT name FROM organizations WHERE id = c.organization_id)"},
+	"job_title":         {kind: filterexpr.KindString, column: "c.job_title"},
+	"location_source":   {kind: filterexpr.KindString, column: "c.location_source"},
+	"created_at":        {kind: filterexpr.KindTime, column: "c.created_at"},
+	"last_contacted_at": {kind: filterexpr.KindTime, column: "c.last_contacted_at"},
+	"phone":             {kind: filterexpr.KindString, column: "value", table: phonesTable},
+	"email":             {kind: filterexpr.KindString, column: "value", table: emailsTable},
+	"address":           {kind: filterexpr.KindString, column: "value", table: addressesTable},
+	"city":              {kind: filterexpr.KindString, column: "city", table: addressesTable},
+	"region":            {kind: filterexpr.KindString, column: "region", table: addressesTable},
+	"postal_code":       {kind: filterexpr.KindString, column: "postal_code", table: addressesTable},
+	"country":           {kind: filterexpr.KindString, column: "country", table: addressesTable},
+}
+
+// ParseFilterExpression parses a filter expression and checks its fields
+// and value types, so that it compiles to SQL.
+func ParseFilterExpression(input string) (filterexpr.Expr, error) {
+	expr, err := filterexpr.Parse(input)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := compileExpression(expr, &[]interface{}{}); err != nil {
+		return nil, err
+	}
+	return expr, nil
+}
+
+func expressionError(comparison filterexpr.Comparison, format string, args ...interface{}) error {
+	return &filterexpr.SyntaxError{Pos: comparison.Pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// escapeLike escapes the LIKE wildcards of a value.
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(value)
+}
+
+// compileExpression turns a filter expression into a SQL condition over
+// contacts aliased as c, appending its arguments to args. Comparisons on
+// missing values are false rather than NULL, so not inverts them.
+func compileExpression(expr filterexpr.Expr, args *[]interface{}) (string, error) {
+	switch node := expr.(type) {
+	case filterexpr.And:
+		left, err := compileExpression(node.Left, args)
+		if err != nil {
+			return "", err
+		}
+		right, err := compileExpression(node.Right, args)
+		if err != nil {
+			return "", err
+		}
+		return "(" + left + " AND " + right + ")", nil
+	case filterexpr.Or:
+		left, err := compileExpression(node.Left, args)
+		if err != nil {
+			return "", err
+		}
+		right, err := compileExpression(node.Right, args)
+		if err != nil {
+			return "", err
+		}
+		return "(" + left + " OR " + right + ")", nil
+	case filterexpr.Not:
+		inner, err := compileExpression(node.Expr, args)
+		if err != nil {
+			return "", err
+		}
+		return "NOT " + inner, nil
+	case filterexpr.Comparison:
+		return compileComparison(node, args)
+	default:
+		return "", fmt.Errorf("unknown filter expression %T", expr)
+	}
+}
+
+func compileComparison(comparison filterexpr.Comparison, args *[]interface{}) (string, error) {
+	kind := filterexpr.KindString
+	if len(comparison.Values) > 0 {
+		kind = comparison.Values[0].Kind
+	}
+	for _, value := range comparison.Values {
+		if value.Kind != kind {
+			return "", expressionError(comparison, "values of '%s' must all be of the same type", comparison.Field)
+		}
+	}
+
+	if strings.HasPrefix(comparison.Field, customFieldPrefix) {
+		return compileCustomField(comparison, kind, args)
+	}
+
+	field, ok := expressionFields[comparison.Field]
+	if !ok {
+		names := []string{}
+		for name := range expressionFields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return "", expressionError(comparison, "unknown field '%s', expected one of %s or %s<key>", comparison.Field, strings.Join(names, ", "), customFieldPrefix)
+	}
+	if len(comparison.Values) > 0 && kind != field.kind {
+		return "", expressionError(comparison, "'%s' is a %s and cannot be compared with a %s", comparison.Field, field.kind, kind)
+	}
+
+	operand := field.column
+	if field.table != "" {
+		operand = "m." + field.column
+	}
+
+	var cond string
+	switch comparison.Op {
+	case filterexpr.OpIsNull, filterexpr.OpIsNotNull:
+		cond = operand + " IS NOT NULL"
+		if field.kind == filterexpr.KindString {
+			cond = operand + " <> ''"
+		}
+	default:
+		var err error
+		cond, err = compileOperator(comparison, operand, field.kind, args)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if field.table != "" {
+		cond = "EXISTS (SELECT 1 FROM " + field.table + " m WHERE m.contact_id = c.id AND " + cond + ")"
+	} else {
+		cond = "COALESCE(" + cond + ", false)"
+	}
+	if comparison.Op == filterexpr.OpIsNull {
+		cond = "NOT " + cond
+	}
+	return cond, nil
+}
+
+// compileCustomField compares a custom field, read as the type of the values.
+func compileCustomField(comparison filterexpr.Comparison, kind filterexpr.Kind, args *[]interface{}) (string, error) {
+	key := strings.TrimPrefix(comparison.Field, customFieldPrefix)
+	if !fieldKeyPattern.MatchString(key) {
+		return "", expressionError(comparison, "invalid custom field key '%s'", key)
+	}
+
+	*args = append(*args, key)
+	param := "$" + strconv.Itoa(len(*args))
+	text := "(c.custom_fields ->> " + param + ")"
+
+	if comparison.Op == filterexpr.OpIsNull {
+		return "COALESCE(" + text + ", '') = ''", nil
+	}
+	if comparison.Op == filterexpr.OpIsNotNull {
+		return "COALESCE(" + text + ", '') <> ''", nil
+	}
+
+	operand := text
+	typeCheck := "jsonb_typeof(c.custom_fields -> " + param + ")"
+	switch kind {
+	case filterexpr.KindNumber:
+		operand = "(CASE WHEN " + typeCheck + " = 'number' THEN " + text + "::numeric END)"
+	case filterexpr.KindBool:
+		operand = "(CASE WHEN " + typeCheck + " = 'boolean' THEN " + text + "::boolean END)"
+	case filterexpr.KindTime:
+		operand = "(CASE WHEN " + text + ` ~ '^\d{4}-\d{2}-\d{2}$' THEN ` + text + "::date END)"
+	}
+
+	cond, err := compileOperator(comparison, operand, kind, args)
+	if err != nil {
+		return "", err
+	}
+	return "COALESCE(" + cond + ", false)", nil
+}
+
+// compileOperator compares an operand of the given kind with the values of a
+// comparison. Text is compared case-insensitively.
+func compileOperator(comparison filterexpr.Comparison, operand string, kind filterexpr.Kind, args *[]interface{}) (string, error) {
+	param := func(value filterexpr.Value) string {
+		argument := value.Interface()
+		if value.Kind == filterexpr.KindTime {
+			argument = value.Time.UTC().Format(time.RFC3339Nano)
+		}
+		*args = append(*args, argument)
+		placeholder := "$" + strconv.Itoa(len(*args))
+		switch kind {
+		case filterexpr.KindString:
+			return "lower(" + placeholder + ")"
+		case filterexpr.KindTime:
+			return placeholder + "::timestamp"
+		case filterexpr.KindNumber:
+			return placeholder + "::numeric"
+		default:
+			return placeholder + "::boolean"
+		}
+	}
+
+	if kind == filterexpr.KindString {
+		operand = "lower(" + operand + ")"
+	}
+
+	switch comparison.Op {
+	case filterexpr.OpEq, filterexpr.OpNe:
+		sqlOp := "="
+		if comparison.Op == filterexpr.OpNe {
+			sqlOp = "<>"
+		}
+		return operand + " " + sqlOp + " " + param(comparison.Values[0]), nil
+	case filterexpr.OpIn:
+		list := []string{}
+		for _, value := range comparison.Values {
+			list = append(list, param(value))
+		}
+		return operand + " IN (" + strings.Join(list, ", ") + ")", nil
+	}
+
+	if kind == filterexpr.KindBool {
+		return "", expressionError(comparison, "operator '%s' cannot be used with booleans", comparison.Op)
+	}
+
+	switch comparison.Op {
+	case filterexpr.OpLt, filterexpr.OpLe, filterexpr.OpGt, filterexpr.OpGe:
+		return operand + " " + string(comparison.Op) + " " + param(comparison.Values[0]), nil
+	}
+
+	if kind != filterexpr.KindString {
+		return "", expressionError(comparison, "operator '%s' can only be used with text", comparison.Op)
+	}
+
+	pattern := escapeLike(comparison.Values[0].Text)
+	switch comparison.Op {
+	case filterexpr.OpContains:
+		pattern = "%" + pattern + "%"
+	case filterexpr.OpStartsWith:
+		pattern = pattern + "%"
+	case filterexpr.OpEndsWith:
+		pattern = "%" + pattern
+	default:
+		return "", expressionError(comparison, "unknown operator '%s'", comparison.Op)
+	}
+	*args = append(*args, pattern)
+	return operand + " LIKE lower($" + strconv.Itoa(len(*args)) + ")", nil
+}
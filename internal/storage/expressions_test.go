@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/utah1280/backend-internship-2024/internal/filterexpr"
+)
+
+func TestCompileExpression(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+		args  []interface{}
+	}{
+		{
+			`name = "Ann"`,
+			`COALESCE(lower(c.name) = lower($1), false)`,
+			[]interface{}{"Ann"},
+		},
+		{
+			`id != 3`,
+			`COALESCE(c.id <> $1::numeric, false)`,
+			[]interface{}{3.0},
+		},
+		{
+			`created_at >= 2024-01-01`,
+			`COALESCE(c.created_at >= $1::timestamp, false)`,
+			[]interface{}{"2024-01-01T00:00:00Z"},
+		},
+		{
+			`name contains "50%_off"`,
+			`COALESCE(lower(c.name) LIKE lower($1), false)`,
+			[]interface{}{`%50\%\_off%`},
+		},
+		{
+			`email ends_with "@gmail.com"`,
+			`EXISTS (SELECT 1 FROM contact_emails m WHERE m.contact_id = c.id AND lower(m.value) LIKE lower($1))`,
+			[]interface{}{"%@gmail.com"},
+		},
+		{
+			`category in ("Suppliers", "Partners")`,
+			`COALESCE(lower((SELECT label FROM categories WHERE id = c.category_id)) IN (lower($1), lower($2)), false)`,
+			[]interface{}{"Suppliers", "Partners"},
+		},
+		{
+			`job_title is null`,
+			`NOT COALESCE(c.job_title <> '', false)`,
+			nil,
+		},
+		{
+			`last_contacted_at is not null`,
+			`COALESCE(c.last_contacted_at IS NOT NULL, false)`,
+			nil,
+		},
+		{
+			`city is null`,
+			`NOT EXISTS (SELECT 1 FROM contact_addresses m WHERE m.contact_id = c.id AND m.city <> '')`,
+			nil,
+		},
+		{
+			`cf.vip = true`,
+			`COALESCE((CASE WHEN jsonb_typeof(c.custom_fields -> $1) = 'boolean' THEN (c.custom_fields ->> $1)::boolean END) = $2::boolean, false)`,
+			[]interface{}{"vip", true},
+		},
+		{
+			`cf.renewal < 2025-06-30`,
+			`COALESCE((CASE WHEN (c.custom_fields ->> $1) ~ '^\d{4}-\d{2}-\d{2}$' THEN (c.custom_fields ->> $1)::date END) < $2::timestamp, false)`,
+			[]interface{}{"renewal", "2025-06-30T00:00:00Z"},
+		},
+		{
+			`cf.tier is not null`,
+			`COALESCE((c.custom_fields ->> $1), '') <> ''`,
+			[]interface{}{"tier"},
+		},
+		{
+			`name = "a" or id = 1 and not email is null`,
+			`(COALESCE(lower(c.name) = lower($1), false) OR (COALESCE(c.id = $2::numeric, false) AND NOT NOT EXISTS (SELECT 1 FROM contact_emails m WHERE m.contact_id = c.id AND m.value <> '')))`,
+			[]interface{}{"a", 1.0},
+		},
+	}
+
+	for _, test := range tests {
+		expr, err := ParseFilterExpression(test.input)
+		if err != nil {
+			t.Errorf("ParseFilterExpression(%q) failed: %v", test.input, err)
+			continue
+		}
+		var args []interface{}
+		got, err := compileExpression(expr, &args)
+		if err != nil {
+			t.Errorf("compileExpression(%q) failed: %v", test.input, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("compileExpression(%q) =\n%s\nwant\n%s", test.input, got, test.want)
+		}
+		if !reflect.DeepEqual(args, test.args) {
+			t.Errorf("compileExpression(%q) args = %#v, want %#v", test.input, args, test.args)
+		}
+	}
+}
+
+func TestParseFilterExpressionErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		pos   int
+		msg   string
+	}{
+		{`name = "a" and nickname = "b"`, 16, "unknown field 'nickname', expected one of address, category, city, country, created_at, email, id, job_title, last_contacted_at, location_source, name, organization, phone, postal_code, region or cf.<key>"},
+		{`id = "3"`, 1, "'id' is a number and cannot be compared with a string"},
+		{`created_at > 5`, 1, "'created_at' is a date and cannot be compared with a number"},
+		{`id in (1, "2")`, 1, "values of 'id' must all be of the same type"},
+		{`id contains 1`, 1, "operator 'contains' can only be used with text"},
+		{`cf.vip > true`, 1, "operator '>' cannot be used with booleans"},
+		{`cf.bad-key = 1`, 1, "invalid custom field key 'bad-key'"},
+	}
+
+	for _, test := range tests {
+		_, err := ParseFilterExpression(test.input)
+		var syntaxErr *filterexpr.SyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Errorf("ParseFilterExpression(%q) error = %v, want a SyntaxError", test.input, err)
+			continue
+		}
+		if syntaxErr.Pos != test.pos || syntaxErr.Msg != test.msg {
+			t.Errorf("ParseFilterExpression(%q) error = %d %q, want %d %q", test.input, syntaxErr.Pos, syntaxErr.Msg, test.pos, test.msg)
+		}
+	}
+}
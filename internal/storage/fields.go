@@ -0,0 +1,285 @@
+package storage
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+const (
+	FieldText    = "text"
+	FieldNumber  = "number"
+	FieldDate    = "date"
+	FieldEnum    = "enum"
+	FieldBoolean = "boolean"
+)
+
+var fieldKeyPattern = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// ErrInvalidField is returned when a field definition cannot be added as given.
+var ErrInvalidField = errors.New("invalid field")
+
+// ErrFieldExists is returned when the category already has a field with the key.
+var ErrFieldExists = errors.New("field already exists for this category")
+
+type CategoryField struct {
+	Id         int            `json:"id" db:"id"`
+	CategoryId int            `json:"category_id" db:"category_id"`
+	Key        string         `json:"key" db:"key"`
+	Label      string         `json:"label" db:"label"`
+	Type       string         `json:"type" db:"type"`
+	Options    pq.StringArray `json:"options" db:"options" swaggertype:"array,string"`
+	Required   bool           `json:"required" db:"required"`
+	CreatedAt  time.Time      `json:"created_at" db:"created_at"`
+}
+
+type NewCategoryFieldInput struct {
+	Key      string
+	Label    string
+	Type     string
+	Options  []string
+	Required bool
+	// Default is stored on the category's existing contacts that have no
+	// value for the field. It is needed to add a required field to a
+	// category that already has contacts.
+	Default interface{}
+}
+
+// CustomFields are the per-contact values of the fields defined on its category,
+// stored as a JSONB object.
+type CustomFields map[string]interface{}
+
+func (fields CustomFields) Value() (driver.Value, error) {
+	if fields == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(fields)
+}
+
+func (fields *CustomFields) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	case nil:
+		*fields = CustomFields{}
+		return nil
+	default:
+		return fmt.Errorf("unsupported custom fields type %T", src)
+	}
+	return json.Unmarshal(data, fields)
+}
+
+func getCategoryFields(DB sqlx.Queryer, categoryId int) ([]CategoryField, error) {
+	var fields []CategoryField
+
+	stmt := "SELECT id, category_id, key, label, type, options, required, created_at FROM category_fields WHERE category_id = $1 ORDER BY id"
+	if err := sqlx.Select(DB, &fields, stmt, categoryId); err != nil {
+		return nil, fmt.Errorf("error fetching category fields: %v", err)
+	}
+	return fields, nil
+}
+
+// ValidateCustomFields checks values against the field definitions of a category
+// and returns the cleaned values. Unknown keys, missing required fields and
+// values of the wrong type are rejected; null values are dropped.
+func ValidateCustomFields(defs []CategoryField, values CustomFields) (CustomFields, error) {
+	byKey := make(map[string]CategoryField, len(defs))
+	for _, def := range defs {
+		byKey[def.Key] = def
+	}
+
+	cleaned := CustomFields{}
+	for key, value := range values {
+		def, ok := byKey[key]
+		if !ok {
+			return nil, fmt.Errorf("custom field '%s' is not defined for this category", key)
+		}
+		if value == nil {
+			continue
+		}
+
+		switch def.Type {
+		case FieldText:
+			if _, ok := value.(string); !ok {
+				return nil, fmt.Errorf("custom field '%s' must be text", key)
+			}
+		case FieldNumber:
+			if _, ok := value.(float64); !ok {
+				return nil, fmt.Errorf("custom field '%s' must be a number", key)
+			}
+		case FieldDate:
+			str, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf("custom field '%s' must be a date (YYYY-MM-DD)", key)
+			}
+			if _, err := time.Parse(time.DateOnly, str); err != nil {
+				return nil, fmt.Errorf("custom field '%s' must be a date (YYYY-MM-DD)", key)
+			}
+		case FieldEnum:
+			str, ok := value.(string)
+			if !ok || !containsString(def.Options, str) {
+				return nil, fmt.Errorf("custom field '%s' must be one of %v", key, []string(def.Options))
+			}
+		case FieldBoolean:
+			if _, ok := value.(bool); !ok {
+				return nil, fmt.Errorf("custom field '%s' must be a boolean", key)
+			}
+		}
+		cleaned[key] = value
+	}
+
+	for _, def := range defs {
+		if _, ok := cleaned[def.Key]; def.Required && !ok {
+			return nil, fmt.Errorf("custom field '%s' is required", def.Key)
+		}
+	}
+
+	return cleaned, nil
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
+
+// AddCategoryField defines a custom field on a category. Definitions that
+// cannot be added fail with ErrInvalidField, keys already in use with
+// ErrFieldExists, and unknown categories with sql.ErrNoRows.
+func (storage *CategoryStorage) AddCategoryField(categoryId int, data NewCategoryFieldInput) (int, error) {
+	defer clearCategoryStats()
+
+	if !fieldKeyPattern.MatchString(data.Key) {
+		return 0, fmt.Errorf("%w: key '%s', expected lowercase letters, digits and underscores", ErrInvalidField, data.Key)
+	}
+
+	switch data.Type {
+	case FieldText, FieldNumber, FieldDate, FieldBoolean:
+		data.Options = nil
+	case FieldEnum:
+		if len(data.Options) == 0 {
+			return 0, fmt.Errorf("%w: enum field '%s' needs at least one option", ErrInvalidField, data.Key)
+		}
+	default:
+		return 0, fmt.Errorf("%w: unknown type '%s'", ErrInvalidField, data.Type)
+	}
+
+	if data.Label == "" {
+		data.Label = data.Key
+	}
+
+	if data.Default != nil {
+		def := CategoryField{Key: data.Key, Type: data.Type, Options: data.Options}
+		if _, err := ValidateCustomFields([]CategoryField{def}, CustomFields{data.Key: data.Default}); err != nil {
+			return 0, fmt.Errorf("%w: default: %v", ErrInvalidField, err)
+		}
+	}
+
+	tx, err := storage.DB.Beginx()
+	if err != nil {
+		return 0, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var id int
+	if err := tx.QueryRow("SELECT id FROM categories WHERE id = $1", categoryId).Scan(&id); err != nil {
+		if err == sql.ErrNoRows {
+			return 0, err
+		}
+		return 0, fmt.Errorf("error fetching category: %v", err)
+	}
+
+	insertStmt := `
+		INSERT INTO category_fields (category_id, key, label, type, options, required)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (category_id, key) DO NOTHING
+		RETURNING id
+	`
+	err = tx.QueryRow(insertStmt, categoryId, data.Key, data.Label, data.Type, pq.StringArray(data.Options), data.Required).Scan(&id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, fmt.Errorf("%w: '%s'", ErrFieldExists, data.Key)
+		}
+		return 0, fmt.Errorf("error adding category field: %v", err)
+	}
+
+	if data.Default != nil {
+		value, err := json.Marshal(data.Default)
+		if err != nil {
+			return 0, fmt.Errorf("error encoding default: %v", err)
+		}
+		backfillStmt := `
+			UPDATE contacts SET custom_fields = custom_fields || jsonb_build_object($1::text, $2::jsonb)
+			WHERE category_id = $3 AND COALESCE(custom_fields -> $1::text, 'null') = 'null'
+		`
+		if _, err := tx.Exec(backfillStmt, data.Key, string(value), categoryId); err != nil {
+			return 0, fmt.Errorf("error filling default: %v", err)
+		}
+	} else if data.Required {
+		// Without a value, every later update of these contacts would fail
+		// validation.
+		var missing int
+		countStmt := "SELECT COUNT(*) FROM contacts WHERE category_id = $1 AND COALESCE(custom_fields -> $2::text, 'null') = 'null'"
+		if err := tx.QueryRow(countStmt, categoryId, data.Key).Scan(&missing); err != nil {
+			return 0, fmt.Errorf("error counting contacts: %v", err)
+		}
+		if missing > 0 {
+			return 0, fmt.Errorf("%w: required field '%s' needs a default for the %d contacts of this category", ErrInvalidField, data.Key, missing)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error adding category field: %v", err)
+	}
+
+	return id, nil
+}
+
+func (storage *CategoryStorage) GetCategoryFields(categoryId int) ([]CategoryField, error) {
+	return getCategoryFields(storage.DB, categoryId)
+}
+
+// DeleteCategoryField removes a field definition together with the values
+// stored for it on the category's contacts.
+func (storage *CategoryStorage) DeleteCategoryField(categoryId, fieldId int) error {
+	defer clearCategoryStats()
+
+	tx, err := storage.DB.Beginx()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var key string
+	deleteStmt := "DELETE FROM category_fields WHERE id = $1 AND category_id = $2 RETURNING key"
+	if err := tx.QueryRow(deleteStmt, fieldId, categoryId).Scan(&key); err != nil {
+		if err == sql.ErrNoRows {
+			return err
+		}
+		return fmt.Errorf("error deleting category field: %v", err)
+	}
+
+	cleanupStmt := "UPDATE contacts SET custom_fields = custom_fields - $1::text WHERE category_id = $2 AND custom_fields ? $1::text"
+	if _, err := tx.Exec(cleanupStmt, key, categoryId); err != nil {
+		return fmt.Errorf("error removing field values: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing field deletion: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// IdempotencyRecord is the stored outcome of a request sent with an
+// idempotency key. Fingerprint identifies the request the key was first used
+// with; the response fields are only set once Completed.
+type IdempotencyRecord struct {
+	Fingerprint string          `db:"fingerprint"`
+	Completed   bool            `db:"completed"`
+	Status      int             `db:"status"`
+	ContentType string          `db:"content_type"`
+	Headers     ResponseHeaders `db:"headers"`
+	Body        []byte          `db:"body"`
+}
+
+// ResponseHeaders are the stored response headers replayed with the body,
+// by name and in the order they were sent.
+type ResponseHeaders map[string][]string
+
+func (headers ResponseHeaders) Value() (driver.Value, error) {
+	if headers == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(headers)
+}
+
+func (headers *ResponseHeaders) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	case nil:
+		*headers = ResponseHeaders{}
+		return nil
+	default:
+		return fmt.Errorf("unsupported response headers type %T", src)
+	}
+	return json.Unmarshal(data, headers)
+}
+
+type IdempotencyStorage struct {
+	DB *sqlx.DB
+}
+
+func NewIdempotencyStorage(DB *sqlx.DB) *IdempotencyStorage {
+	return &IdempotencyStorage{DB: DB}
+}
+
+// ClaimKey reserves a key of a principal for a request and returns nil when
+// the caller should run it: the key is new, expired or abandoned, i.e. its
+// request did not complete within claimTimeout. Otherwise the record of the
+// request already using the key is returned.
+func (storage *IdempotencyStorage) ClaimKey(principal, key, fingerprint string, ttl, claimTimeout time.Duration) (*IdempotencyRecord, error) {
+	claimStmt := `
+		INSERT INTO idempotency_keys (principal, key, fingerprint, expires_at)
+		VALUES ($1, $2, $3, now() + $4::float8 * interval '1 second')
+		ON CONFLICT (principal, key) DO UPDATE SET
+			fingerprint = EXCLUDED.fingerprint, completed = false, status = 0, content_type = '', headers = '{}', body = NULL,
+			created_at = now(), expires_at = EXCLUDED.expires_at
+		WHERE idempotency_keys.expires_at < now()
+			OR (NOT idempotency_keys.completed AND idempotency_keys.created_at < now() - $5::float8 * interval '1 second')
+		RETURNING key
+	`
+
+	// The existing record may expire between both statements, hence a second try.
+	for attempt := 0; attempt < 2; attempt++ {
+		var claimed string
+		err := storage.DB.QueryRow(claimStmt, principal, key, fingerprint, ttl.Seconds(), claimTimeout.Seconds()).Scan(&claimed)
+		if err == nil {
+			return nil, nil
+		}
+		if err != sql.ErrNoRows {
+			return nil, fmt.Errorf("error claiming idempotency key: %v", err)
+		}
+
+		var record IdempotencyRecord
+		selectStmt := "SELECT fingerprint, completed, status, content_type, headers, body FROM idempotency_keys WHERE principal = $1 AND key = $2"
+		err = storage.DB.Get(&record, selectStmt, principal, key)
+		if err == nil {
+			return &record, nil
+		}
+		if err != sql.ErrNoRows {
+			return nil, fmt.Errorf("error fetching idempotency key: %v", err)
+		}
+	}
+
+	return nil, fmt.Errorf("error claiming idempotency key: key changed concurrently")
+}
+
+// CompleteKey stores the response of the request holding a key.
+func (storage *IdempotencyStorage) CompleteKey(principal, key string, status int, contentType string, headers ResponseHeaders, body []byte) error {
+	updateStmt := `
+		UPDATE idempotency_keys SET completed = true, status = $3, content_type = $4, headers = $5, body = $6
+		WHERE principal = $1 AND key = $2
+	`
+	if _, err := storage.DB.Exec(updateStmt, principal, key, status, contentType, headers, body); err != nil {
+		return fmt.Errorf("error saving idempotent response: %v", err)
+	}
+	return nil
+}
+
+// ReleaseKey frees a key whose request failed so it can be retried.
+func (storage *IdempotencyStorage) ReleaseKey(principal, key string) error {
+	deleteStmt := "DELETE FROM idempotency_keys WHERE principal = $1 AND key = $2 AND NOT completed"
+	if _, err := storage.DB.Exec(deleteStmt, principal, key); err != nil {
+		return fmt.Errorf("error releasing idempotency key: %v", err)
+	}
+	return nil
+}
+
+// PurgeExpiredKeys deletes the keys past their TTL.
+func (storage *IdempotencyStorage) PurgeExpiredKeys() (int64, error) {
+	resp, err := storage.DB.Exec("DELETE FROM idempotency_keys WHERE expires_at < now()")
+	if err != nil {
+		return 0, fmt.Errorf("error purging idempotency keys: %v", err)
+	}
+	return resp.RowsAffected()
+}
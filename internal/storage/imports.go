@@ -0,0 +1,506 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/mail"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// ErrInvalidImportOptions is returned when the options of an import are not
+// valid, before any record is looked at.
+var ErrInvalidImportOptions = errors.New("invalid import options")
+
+// Category strategies of an import: match only accepts existing categories,
+// create adds the missing ones and default files unknown categories under the
+// default category.
+const (
+	CategoryMatch   = "match"
+	CategoryCreate  = "create"
+	CategoryDefault = "default"
+)
+
+var categoryStrategies = []string{CategoryMatch, CategoryCreate, CategoryDefault}
+
+// importBatchSize is the number of contacts inserted per statement.
+const importBatchSize = 500
+
+// ImportRecord is one contact read from an import file. Line is the position
+// of the record in the file, used in the report. As with NewContactInput, the
+// phone, email and address lists take precedence over the single values.
+// Custom fields hold raw text converted according to the field definitions of
+// the category.
+type ImportRecord struct {
+	Line         int
+	Name         string
+	Phone        string
+	Email        string
+	Address      string
+	Phones       []ContactMethodInput
+	Emails       []ContactMethodInput
+	Addresses    []ContactMethodInput
+	Category     string
+	JobTitle     string
+	CustomFields map[string]string
+}
+
+type ImportOptions struct {
+	CategoryStrategy string
+	// DefaultCategory is used for records without a category and, with the
+	// default strategy, for records with an unknown one.
+	DefaultCategory string
+	// SkipDuplicates leaves out valid records sharing a phone with an
+	// existing contact; they are counted as skipped.
+	SkipDuplicates bool
+	DryRun         bool
+}
+
+type ImportRowError struct {
+	Line   int      `json:"line"`
+	Errors []string `json:"errors"`
+}
+
+// ImportDuplicate flags a record sharing a phone with an existing contact.
+type ImportDuplicate struct {
+	Line      int    `json:"line"`
+	ContactId int    `json:"contact_id"`
+	Reason    string `json:"reason"`
+}
+
+// ImportReport describes an import. Valid counts the rows that are inserted,
+// so rows skipped as duplicates are not included. Created is zero on dry runs;
+// the other counts are the same for a dry run and the import applied right
+// after it.
+type ImportReport struct {
+	DryRun            bool              `json:"dry_run"`
+	Rows              int               `json:"rows"`
+	Valid             int               `json:"valid"`
+	Invalid           int               `json:"invalid"`
+	Skipped           int               `json:"skipped"`
+	Created           int               `json:"created"`
+	CreatedCategories []string          `json:"created_categories"`
+	Errors            []ImportRowError  `json:"errors"`
+	Duplicates        []ImportDuplicate `json:"duplicates"`
+}
+
+// importRow is a validated record ready to be inserted.
+type importRow struct {
+	record       ImportRecord
+	categoryKey  string
+	customFields CustomFields
+	phones       []ContactMethodInput
+	emails       []ContactMethodInput
+	addresses    []ContactMethodInput
+}
+
+// convertCustomField turns the text of a custom field into the value type of its definition.
+func convertCustomField(def CategoryField, text string) (interface{}, error) {
+	switch def.Type {
+	case FieldNumber:
+		number, err := strconv.ParseFloat(text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("custom field '%s' must be a number", def.Key)
+		}
+		return number, nil
+	case FieldBoolean:
+		boolean, err := strconv.ParseBool(text)
+		if err != nil {
+			return nil, fmt.Errorf("custom field '%s' must be a boolean", def.Key)
+		}
+		return boolean, nil
+	}
+	return text, nil
+}
+
+// ImportContacts validates the records and, unless it is a dry run, inserts
+// the valid ones in batches within a single transaction. Invalid records are
+// reported and never inserted. Invalid options fail with
+// ErrInvalidImportOptions.
+func (storage *ContactStorage) ImportContacts(records []ImportRecord, options ImportOptions) (ImportReport, error) {
+	defer clearCategoryStats()
+
+	report := ImportReport{
+		DryRun:            options.DryRun,
+		Rows:              len(records),
+		CreatedCategories: []string{},
+		Errors:            []ImportRowError{},
+		Duplicates:        []ImportDuplicate{},
+	}
+
+	if options.CategoryStrategy == "" {
+		options.CategoryStrategy = CategoryMatch
+	}
+	if !containsString(categoryStrategies, options.CategoryStrategy) {
+		return report, fmt.Errorf("%w: category strategy '%s', expected one of %v", ErrInvalidImportOptions, options.CategoryStrategy, categoryStrategies)
+	}
+
+	tx, err := storage.DB.Beginx()
+	if err != nil {
+		return report, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	// Categories are keyed by their normalized label; missing ones are only
+	// added when the import is applied.
+	var categories []Category
+	if err := tx.Select(&categories, "SELECT "+categoryColumns+" FROM categories"); err != nil {
+		return report, fmt.Errorf("error fetching categories: %v", err)
+	}
+	categoryIds := map[string]int{}
+	for _, category := range categories {
+		categoryIds[strings.ToLower(NormalizeLabel(category.Label))] = category.Id
+	}
+	fieldDefs := map[int][]CategoryField{}
+
+	defaultKey := strings.ToLower(NormalizeLabel(options.DefaultCategory))
+	if defaultKey != "" {
+		if _, ok := categoryIds[defaultKey]; !ok {
+			return report, fmt.Errorf("%w: default category '%s' does not exist", ErrInvalidImportOptions, options.DefaultCategory)
+		}
+	} else if options.CategoryStrategy == CategoryDefault {
+		return report, fmt.Errorf("%w: default category is required with the default strategy", ErrInvalidImportOptions)
+	}
+
+	newCategories := map[string]string{}
+	emailLines := map[string]int{}
+	rows := []importRow{}
+	for _, record := range records {
+		record.Name = strings.TrimSpace(record.Name)
+		errors := []string{}
+
+		if record.Name == "" {
+			errors = append(errors, "name is empty")
+		}
+
+		phones, err := normalizeMethods("phone", record.Phones, strings.TrimSpace(record.Phone))
+		if err != nil {
+			errors = append(errors, err.Error())
+		}
+		emails, err := normalizeMethods("email", record.Emails, strings.TrimSpace(record.Email))
+		if err != nil {
+			errors = append(errors, err.Error())
+		}
+		addresses, err := normalizeMethods("address", record.Addresses, strings.TrimSpace(record.Address))
+		if err != nil {
+			errors = append(errors, err.Error())
+		}
+
+		// Emails are only claimed once the row is valid, so that rows left
+		// out do not block later ones.
+		rowEmails := []string{}
+		for _, method := range emails {
+			email := strings.ToLower(method.Value)
+			if _, err := mail.ParseAddress(method.Value); err != nil {
+				errors = append(errors, fmt.Sprintf("invalid email '%s'", method.Value))
+			} else if line, ok := emailLines[email]; ok {
+				errors = append(errors, fmt.Sprintf("email '%s' is already used on line %d", method.Value, line))
+			} else if containsString(rowEmails, email) {
+				errors = append(errors, fmt.Sprintf("email '%s' is listed more than once", method.Value))
+			} else {
+				rowEmails = append(rowEmails, email)
+			}
+		}
+
+		label := NormalizeLabel(record.Category)
+		key := strings.ToLower(label)
+		categoryId, known := categoryIds[key]
+		switch {
+		case key == "" && defaultKey != "":
+			key, categoryId, known = defaultKey, categoryIds[defaultKey], true
+		case key == "":
+			errors = append(errors, "category is empty")
+		case known:
+		case options.CategoryStrategy == CategoryCreate:
+			// Added below once the row is known to be valid.
+		case options.CategoryStrategy == CategoryDefault:
+			key, categoryId, known = defaultKey, categoryIds[defaultKey], true
+		default:
+			errors = append(errors, fmt.Sprintf("category '%s' does not exist", record.Category))
+		}
+
+		var customFields CustomFields
+		if known {
+			defs, ok := fieldDefs[categoryId]
+			if !ok {
+				defs, err = getCategoryFields(tx, categoryId)
+				if err != nil {
+					return report, err
+				}
+				fieldDefs[categoryId] = defs
+			}
+
+			values := CustomFields{}
+			for fieldKey, text := range record.CustomFields {
+				text = strings.TrimSpace(text)
+				if text == "" {
+					continue
+				}
+				values[fieldKey] = text
+				for _, def := range defs {
+					if def.Key != fieldKey {
+						continue
+					}
+					value, err := convertCustomField(def, text)
+					if err != nil {
+						errors = append(errors, err.Error())
+						delete(values, fieldKey)
+						continue
+					}
+					values[fieldKey] = value
+				}
+			}
+			customFields, err = ValidateCustomFields(defs, values)
+			if err != nil {
+				errors = append(errors, err.Error())
+			}
+		} else if len(record.CustomFields) > 0 {
+			for fieldKey, text := range record.CustomFields {
+				if strings.TrimSpace(text) != "" {
+					errors = append(errors, fmt.Sprintf("custom field '%s' is not defined for this category", fieldKey))
+				}
+			}
+		}
+
+		if len(errors) > 0 {
+			sort.Strings(errors)
+			report.Errors = append(report.Errors, ImportRowError{Line: record.Line, Errors: errors})
+			continue
+		}
+
+		for _, email := range rowEmails {
+			emailLines[email] = record.Line
+		}
+		if !known {
+			if _, ok := newCategories[key]; !ok {
+				newCategories[key] = label
+				report.CreatedCategories = append(report.CreatedCategories, label)
+			}
+		}
+
+		rows = append(rows, importRow{
+			record:       record,
+			categoryKey:  key,
+			customFields: customFields,
+			phones:       phones,
+			emails:       emails,
+			addresses:    addresses,
+		})
+	}
+
+	// Emails must stay unique across the contact book.
+	emails := []string{}
+	for email := range emailLines {
+		emails = append(emails, email)
+	}
+	var taken []string
+	if err := tx.Select(&taken, "SELECT lower(value) FROM contact_emails WHERE lower(value) = ANY($1)", pq.Array(emails)); err != nil {
+		return report, fmt.Errorf("error checking email existence: %v", err)
+	}
+	takenEmails := map[string]bool{}
+	for _, email := range taken {
+		takenEmails[email] = true
+	}
+
+	phones := []string{}
+	for _, row := range rows {
+		for _, method := range row.phones {
+			if phone := normalizePhone(method.Value); len(phone) >= minPhoneKeyLength {
+				phones = append(phones, phone)
+			}
+		}
+	}
+	var matches []struct {
+		ContactId int    `db:"contact_id"`
+		Phone     string `db:"phone"`
+	}
+	phoneStmt := `
+		SELECT DISTINCT ON (match_key) contact_id, match_key AS phone
+		FROM contact_phones
+		WHERE match_key = ANY($1)
+		ORDER BY match_key, contact_id
+	`
+	if err := tx.Select(&matches, phoneStmt, pq.Array(phones)); err != nil {
+		return report, fmt.Errorf("error checking phone duplicates: %v", err)
+	}
+	phoneContacts := map[string]int{}
+	for _, match := range matches {
+		phoneContacts[match.Phone] = match.ContactId
+	}
+
+	valid := rows[:0]
+	for _, row := range rows {
+		errors := []string{}
+		for _, method := range row.emails {
+			if takenEmails[strings.ToLower(method.Value)] {
+				errors = append(errors, fmt.Sprintf("email '%s' already exists", method.Value))
+			}
+		}
+		if len(errors) > 0 {
+			report.Errors = append(report.Errors, ImportRowError{Line: row.record.Line, Errors: errors})
+			continue
+		}
+
+		duplicate := false
+		for _, method := range row.phones {
+			if contactId, ok := phoneContacts[normalizePhone(method.Value)]; ok && !duplicate {
+				duplicate = true
+				report.Duplicates = append(report.Duplicates, ImportDuplicate{
+					Line:      row.record.Line,
+					ContactId: contactId,
+					Reason:    "phone",
+				})
+			}
+		}
+		if duplicate && options.SkipDuplicates {
+			report.Skipped++
+			continue
+		}
+		valid = append(valid, row)
+	}
+	rows = valid
+
+	sort.Slice(report.Errors, func(i, j int) bool {
+		return report.Errors[i].Line < report.Errors[j].Line
+	})
+	report.Invalid = len(report.Errors)
+	report.Valid = len(rows)
+
+	if options.DryRun || len(rows) == 0 {
+		return report, nil
+	}
+
+	for key, label := range newCategories {
+		var id int
+		// A category added by someone else since the lookup is reused.
+		insertStmt := `
+			INSERT INTO categories (label, position)
+			VALUES ($1, (SELECT COALESCE(MAX(position), 0) + 1 FROM categories))
+			ON CONFLICT (` + labelKey + `) DO UPDATE SET label = categories.label
+			RETURNING id
+		`
+		if err := tx.QueryRow(insertStmt, label).Scan(&id); err != nil {
+			return report, fmt.Errorf("error adding category: %v", err)
+		}
+		categoryIds[key] = id
+	}
+
+	for start := 0; start < len(rows); start += importBatchSize {
+		batch := rows[start:min(start+importBatchSize, len(rows))]
+		if err := storage.insertImportBatch(tx, batch, categoryIds); err != nil {
+			return report, err
+		}
+		report.Created += len(batch)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return report, fmt.Errorf("error importing contacts: %v", err)
+	}
+
+	return report, nil
+}
+
+// insertImportBatch inserts a batch of contacts with one statement per table.
+// Identity values follow the insertion order, so the returned ids sorted
+// ascending line up with the batch.
+func (storage *ContactStorage) insertImportBatch(tx *sqlx.Tx, batch []importRow, categoryIds map[string]int) error {
+	var names, phones, emails, addresses, jobTitles, customFields []string
+	var categories []int64
+	for _, row := range batch {
+		encoded, err := json.Marshal(row.customFields)
+		if err != nil {
+			return fmt.Errorf("error encoding custom fields: %v", err)
+		}
+		names = append(names, row.record.Name)
+		phones = append(phones, primaryValue(row.phones))
+		emails = append(emails, primaryValue(row.emails))
+		addresses = append(addresses, primaryValue(row.addresses))
+		jobTitles = append(jobTitles, row.record.JobTitle)
+		customFields = append(customFields, string(encoded))
+		categories = append(categories, int64(categoryIds[row.categoryKey]))
+	}
+
+	var ids []int
+	insertStmt := `
+		INSERT INTO contacts (name, phone, email, address, category_id, custom_fields, job_title)
+		SELECT name, phone, email, address, category_id, custom_fields, job_title
+		FROM unnest($1::varchar[], $2::varchar[], $3::varchar[], $4::varchar[], $5::bigint[], $6::jsonb[], $7::varchar[])
+			WITH ORDINALITY AS rows (name, phone, email, address, category_id, custom_fields, job_title, ord)
+		ORDER BY ord
+		RETURNING id
+	`
+	err := tx.Select(&ids, insertStmt, pq.Array(names), pq.Array(phones), pq.Array(emails), pq.Array(addresses),
+		pq.Array(categories), pq.Array(customFields), pq.Array(jobTitles))
+	if err != nil {
+		return fmt.Errorf("error inserting contacts: %v", err)
+	}
+	sort.Ints(ids)
+
+	tables := []struct {
+		name    string
+		methods func(importRow) []ContactMethodInput
+	}{
+		{phonesTable, func(row importRow) []ContactMethodInput { return row.phones }},
+		{emailsTable, func(row importRow) []ContactMethodInput { return row.emails }},
+		{addressesTable, func(row importRow) []ContactMethodInput { return row.addresses }},
+	}
+	for _, table := range tables {
+		var contactIds, positions []int64
+		var types, values []string
+		var primaries []bool
+		var streets, buildings, cities, regions, postalCodes, countries []string
+		var needsReview []bool
+		for i, row := range batch {
+			for position, method := range table.methods(row) {
+				contactIds = append(contactIds, int64(ids[i]))
+				types = append(types, method.Type)
+				values = append(values, method.Value)
+				primaries = append(primaries, method.Primary)
+				positions = append(positions, int64(position))
+				if method.Components != nil {
+					streets = append(streets, method.Components.Street)
+					buildings = append(buildings, method.Components.Building)
+					cities = append(cities, method.Components.City)
+					regions = append(regions, method.Components.Region)
+					postalCodes = append(postalCodes, method.Components.PostalCode)
+					countries = append(countries, method.Components.Country)
+					needsReview = append(needsReview, method.Components.NeedsReview)
+				}
+			}
+		}
+		if len(contactIds) == 0 {
+			continue
+		}
+
+		args := []interface{}{pq.Array(contactIds), pq.Array(types), pq.Array(values), pq.Array(primaries), pq.Array(positions)}
+		methodStmt := `
+			INSERT INTO ` + table.name + ` (contact_id, type, value, is_primary, position)
+			SELECT * FROM unnest($1::bigint[], $2::varchar[], $3::varchar[], $4::boolean[], $5::int[])
+		`
+		if table.name == addressesTable {
+			args = append(args, pq.Array(streets), pq.Array(buildings), pq.Array(cities), pq.Array(regions),
+				pq.Array(postalCodes), pq.Array(countries), pq.Array(needsReview))
+			methodStmt = `
+				INSERT INTO ` + addressesTable + ` (contact_id, type, value, is_primary, position, ` + addressComponentColumns + `)
+				SELECT * FROM unnest($1::bigint[], $2::varchar[], $3::varchar[], $4::boolean[], $5::int[],
+					$6::varchar[], $7::varchar[], $8::varchar[], $9::varchar[], $10::varchar[], $11::varchar[], $12::boolean[])
+			`
+		}
+		if _, err := tx.Exec(methodStmt, args...); err != nil {
+			return fmt.Errorf("error saving %s: %v", table.name, err)
+		}
+	}
+
+	for _, id := range ids {
+		if err := storage.geocodeContact(tx, id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,174 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	LocationGeocoded = "geocoded"
+	LocationManual   = "manual"
+)
+
+// defaultRadiusKm is used by proximity search when no radius is given.
+const defaultRadiusKm = 5.0
+
+type GeoPoint struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+}
+
+// ParseGeoPoint parses a "lat,lng" pair.
+func ParseGeoPoint(value string) (GeoPoint, error) {
+	parts := strings.Split(value, ",")
+	if len(parts) != 2 {
+		return GeoPoint{}, fmt.Errorf("invalid point '%s', expected lat,lng", value)
+	}
+
+	latitude, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	if err != nil {
+		return GeoPoint{}, fmt.Errorf("invalid latitude '%s'", parts[0])
+	}
+	longitude, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if err != nil {
+		return GeoPoint{}, fmt.Errorf("invalid longitude '%s'", parts[1])
+	}
+
+	point := GeoPoint{Latitude: latitude, Longitude: longitude}
+	return point, point.validate()
+}
+
+func (point GeoPoint) validate() error {
+	if point.Latitude < -90 || point.Latitude > 90 {
+		return fmt.Errorf("latitude %v is out of range", point.Latitude)
+	}
+	if point.Longitude < -180 || point.Longitude > 180 {
+		return fmt.Errorf("longitude %v is out of range", point.Longitude)
+	}
+	return nil
+}
+
+// geocodeContact sets the coordinates of a contact from its primary address
+// using the bundled gazetteer. Manually set locations are never overwritten.
+func (storage *ContactStorage) geocodeContact(tx *sqlx.Tx, id int) error {
+	var address PostalAddress
+	addressStmt := "SELECT " + addressComponentColumns + " FROM contact_addresses WHERE contact_id = $1 AND is_primary"
+	err := tx.Get(&address, addressStmt, id)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("error fetching contact address: %v", err)
+	}
+
+	var latitude, longitude interface{}
+	source := ""
+	if place, ok := storage.Geocoder.Lookup(address.City, address.Country); ok {
+		latitude, longitude, source = place.Latitude, place.Longitude, LocationGeocoded
+	}
+
+	updateStmt := `
+		UPDATE contacts SET latitude = $2, longitude = $3, location_source = $4
+		WHERE id = $1 AND location_source != 'manual'
+	`
+	if _, err := tx.Exec(updateStmt, id, latitude, longitude, source); err != nil {
+		return fmt.Errorf("error saving contact location: %v", err)
+	}
+	return nil
+}
+
+// SetContactLocation stores manually entered coordinates which take precedence over geocoding.
+func (storage *ContactStorage) SetContactLocation(id int, point GeoPoint) error {
+	defer clearCategoryStats()
+
+	if err := point.validate(); err != nil {
+		return err
+	}
+
+	updateStmt := "UPDATE contacts SET latitude = $2, longitude = $3, location_source = 'manual' WHERE id = $1"
+	resp, err := storage.DB.Exec(updateStmt, id, point.Latitude, point.Longitude)
+	if err != nil {
+		return fmt.Errorf("error saving contact location: %v", err)
+	}
+
+	rowsAffected, err := resp.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %v", err)
+	}
+
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// ClearContactLocation drops a manual override and geocodes the contact again.
+func (storage *ContactStorage) ClearContactLocation(id int) error {
+	defer clearCategoryStats()
+
+	tx, err := storage.DB.Beginx()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	resp, err := tx.Exec("UPDATE contacts SET location_source = '' WHERE id = $1", id)
+	if err != nil {
+		return fmt.Errorf("error clearing contact location: %v", err)
+	}
+
+	rowsAffected, err := resp.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %v", err)
+	}
+
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	if err := storage.geocodeContact(tx, id); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing contact location: %v", err)
+	}
+
+	return nil
+}
+
+// GeocodeContacts geocodes every contact without a manual location and
+// returns how many of them got coordinates.
+func (storage *ContactStorage) GeocodeContacts() (int, error) {
+	defer clearCategoryStats()
+
+	var ids []int
+	if err := storage.DB.Select(&ids, "SELECT id FROM contacts WHERE location_source != 'manual' ORDER BY id"); err != nil {
+		return 0, fmt.Errorf("error fetching contacts: %v", err)
+	}
+
+	tx, err := storage.DB.Beginx()
+	if err != nil {
+		return 0, fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	for _, id := range ids {
+		if err := storage.geocodeContact(tx, id); err != nil {
+			return 0, err
+		}
+	}
+
+	var located int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM contacts WHERE location_source = 'geocoded'").Scan(&located); err != nil {
+		return 0, fmt.Errorf("error counting geocoded contacts: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error committing contact locations: %v", err)
+	}
+
+	return located, nil
+}
@@ -0,0 +1,287 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// mergeFields are the fields that can be taken from any of the merged contacts.
+var mergeFields = []string{"name", "phone", "email", "address", "category", "organization", "location", "custom_fields"}
+
+// MergeContactsInput merges the secondary contacts into the primary one.
+// Fields maps a merge field to the id of the contact whose value is kept;
+// fields left out keep the value of the primary contact.
+type MergeContactsInput struct {
+	PrimaryId    int
+	SecondaryIds []int
+	Fields       map[string]int
+}
+
+// MergeContacts folds the secondary contacts into the primary one in a single
+// transaction. Phones, emails and addresses of all contacts are kept, the
+// chosen contact's entry becoming primary, and duplicate phones and addresses
+// are dropped. Activities, tasks, dates and relationships move to the primary
+// contact, custom fields missing on the chosen contact are filled from the
+// others. Each secondary is recorded in contact_merges with a snapshot before
+// it is deleted, and the merges recorded on it move to the primary contact.
+func (storage *ContactStorage) MergeContacts(data MergeContactsInput) error {
+	defer clearCategoryStats()
+
+	if len(data.SecondaryIds) == 0 {
+		return fmt.Errorf("no contacts to merge")
+	}
+
+	ids := []int64{int64(data.PrimaryId)}
+	secondaryIds := []int64{}
+	seen := map[int]bool{data.PrimaryId: true}
+	for _, id := range data.SecondaryIds {
+		if seen[id] {
+			return fmt.Errorf("contact %d is listed more than once", id)
+		}
+		seen[id] = true
+		ids = append(ids, int64(id))
+		secondaryIds = append(secondaryIds, int64(id))
+	}
+
+	choices := map[string]int{}
+	for _, field := range mergeFields {
+		choices[field] = data.PrimaryId
+	}
+	for field, id := range data.Fields {
+		if !containsString(mergeFields, field) {
+			return fmt.Errorf("invalid merge field '%s', expected one of %v", field, mergeFields)
+		}
+		if !seen[id] {
+			return fmt.Errorf("contact %d chosen for '%s' is not part of the merge", id, field)
+		}
+		choices[field] = id
+	}
+
+	tx, err := storage.DB.Beginx()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var locked []int
+	if err := tx.Select(&locked, "SELECT id FROM contacts WHERE id = ANY($1) ORDER BY id FOR UPDATE", pq.Array(ids)); err != nil {
+		return fmt.Errorf("error locking contacts: %v", err)
+	}
+	if len(locked) != len(ids) {
+		return sql.ErrNoRows
+	}
+
+	var contacts []Contact_
+	selectStmt := "SELECT " + contactColumns + " FROM contacts c" + contactJoins + " WHERE c.id = ANY($1)"
+	if err := tx.Select(&contacts, selectStmt, pq.Array(ids)); err != nil {
+		return fmt.Errorf("error fetching contacts: %v", err)
+	}
+	if err := attachContactMethods(tx, contacts); err != nil {
+		return err
+	}
+	byId := make(map[int]Contact_, len(contacts))
+	for _, contact := range contacts {
+		byId[contact.Id] = contact
+	}
+
+	name := byId[choices["name"]].Name
+	category := byId[choices["category"]]
+	organization := byId[choices["organization"]]
+	location := byId[choices["location"]]
+
+	defs, err := getCategoryFields(tx, category.CategoryId)
+	if err != nil {
+		return err
+	}
+	defined := map[string]bool{}
+	for _, def := range defs {
+		defined[def.Key] = true
+	}
+
+	// The chosen contact's custom fields win, the others only fill the gaps.
+	order := []int{choices["custom_fields"], data.PrimaryId}
+	order = append(order, data.SecondaryIds...)
+	merged := CustomFields{}
+	for _, id := range order {
+		for key, value := range byId[id].CustomFields {
+			if _, ok := merged[key]; !ok && defined[key] && value != nil {
+				merged[key] = value
+			}
+		}
+	}
+	customFields, err := ValidateCustomFields(defs, merged)
+	if err != nil {
+		return err
+	}
+
+	updateStmt := `
+		UPDATE contacts SET name = $2, category_id = $3, organization_id = $4, job_title = $5,
+			latitude = $6, longitude = $7, location_source = $8, custom_fields = $9
+		WHERE id = $1
+	`
+	_, err = tx.Exec(updateStmt, data.PrimaryId, name, category.CategoryId, organization.OrganizationId, organization.JobTitle,
+		location.Latitude, location.Longitude, location.LocationSource, customFields)
+	if err != nil {
+		return fmt.Errorf("error updating merged contact: %v", err)
+	}
+
+	// dedupe is the SQL expression two entries are compared on, empty to keep all.
+	methods := []struct {
+		table, column, field, dedupe string
+	}{
+		{phonesTable, "phone", "phone", `right(regexp_replace(value, '\D', '', 'g'), 9)`},
+		{emailsTable, "email", "email", ""},
+		{addressesTable, "address", "address", `btrim(regexp_replace(lower(value), '[^[:alnum:]]+', ' ', 'g'))`},
+	}
+	for _, method := range methods {
+		if err := mergeMethods(tx, method.table, method.dedupe, data.PrimaryId, choices[method.field], ids); err != nil {
+			return err
+		}
+
+		syncStmt := "UPDATE contacts SET " + method.column + " = COALESCE((SELECT value FROM " + method.table + " WHERE contact_id = $1 AND is_primary), '') WHERE id = $1"
+		if _, err := tx.Exec(syncStmt, data.PrimaryId); err != nil {
+			return fmt.Errorf("error updating merged contact: %v", err)
+		}
+	}
+
+	for _, table := range []string{"contact_activities", "contact_tasks", "contact_dates"} {
+		moveStmt := "UPDATE " + table + " SET contact_id = $1 WHERE contact_id = ANY($2)"
+		if _, err := tx.Exec(moveStmt, data.PrimaryId, pq.Array(secondaryIds)); err != nil {
+			return fmt.Errorf("error moving %s: %v", table, err)
+		}
+	}
+
+	if err := mergeRelationships(tx, data.PrimaryId, ids, secondaryIds); err != nil {
+		return err
+	}
+
+	if err := refreshLastContacted(tx, data.PrimaryId); err != nil {
+		return err
+	}
+
+	if _, chosen := data.Fields["location"]; !chosen && choices["address"] != data.PrimaryId {
+		if err := storage.geocodeContact(tx, data.PrimaryId); err != nil {
+			return err
+		}
+	}
+
+	fields, err := json.Marshal(choices)
+	if err != nil {
+		return fmt.Errorf("error encoding merge fields: %v", err)
+	}
+	// Merges into the secondaries stay in the history of the merged contact.
+	historyStmt := "UPDATE contact_merges SET contact_id = $1 WHERE contact_id = ANY($2)"
+	if _, err := tx.Exec(historyStmt, data.PrimaryId, pq.Array(secondaryIds)); err != nil {
+		return fmt.Errorf("error moving merge history: %v", err)
+	}
+
+	for _, id := range data.SecondaryIds {
+		snapshot, err := json.Marshal(byId[id])
+		if err != nil {
+			return fmt.Errorf("error encoding contact %d: %v", id, err)
+		}
+
+		insertStmt := "INSERT INTO contact_merges (contact_id, merged_contact_id, merged_name, snapshot, fields) VALUES ($1, $2, $3, $4, $5)"
+		if _, err := tx.Exec(insertStmt, data.PrimaryId, id, byId[id].Name, string(snapshot), string(fields)); err != nil {
+			return fmt.Errorf("error recording merge: %v", err)
+		}
+	}
+
+	if _, err := tx.Exec("DELETE FROM contacts WHERE id = ANY($1)", pq.Array(secondaryIds)); err != nil {
+		return fmt.Errorf("error deleting merged contacts: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error merging contacts: %v", err)
+	}
+
+	return nil
+}
+
+// mergeMethods moves the phones, emails or addresses of the merged contacts to
+// the primary one. The chosen contact's primary entry stays primary, falling
+// back to the primary contact's. Entries equal on the dedupe expression are
+// dropped, keeping the primary one or else the first in merge order.
+func mergeMethods(tx *sqlx.Tx, table, dedupe string, primaryId, chosenId int, ids []int64) error {
+	var primaryMethod int
+	selectStmt := "SELECT id FROM " + table + " WHERE contact_id = ANY($1) AND is_primary ORDER BY contact_id = $2 DESC, contact_id = $3 DESC LIMIT 1"
+	err := tx.QueryRow(selectStmt, pq.Array(ids), chosenId, primaryId).Scan(&primaryMethod)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("error fetching %s: %v", table, err)
+	}
+
+	if _, err := tx.Exec("UPDATE "+table+" SET is_primary = false WHERE contact_id = ANY($1)", pq.Array(ids)); err != nil {
+		return fmt.Errorf("error merging %s: %v", table, err)
+	}
+	if _, err := tx.Exec("UPDATE "+table+" SET is_primary = true WHERE id = $1", primaryMethod); err != nil {
+		return fmt.Errorf("error merging %s: %v", table, err)
+	}
+
+	if dedupe != "" {
+		dedupeStmt := `
+			DELETE FROM ` + table + ` WHERE id IN (
+				SELECT id FROM (
+					SELECT id, row_number() OVER (
+						PARTITION BY ` + dedupe + `
+						ORDER BY is_primary DESC, array_position($1, contact_id), position, id
+					) AS rank
+					FROM ` + table + ` WHERE contact_id = ANY($1)
+				) ranked WHERE rank > 1
+			)
+		`
+		if _, err := tx.Exec(dedupeStmt, pq.Array(ids)); err != nil {
+			return fmt.Errorf("error merging %s: %v", table, err)
+		}
+	}
+
+	moveStmt := `
+		UPDATE ` + table + ` m SET contact_id = $2, position = ordered.position
+		FROM (
+			SELECT id, row_number() OVER (ORDER BY is_primary DESC, array_position($1, contact_id), position, id) - 1 AS position
+			FROM ` + table + ` WHERE contact_id = ANY($1)
+		) ordered
+		WHERE m.id = ordered.id
+	`
+	if _, err := tx.Exec(moveStmt, pq.Array(ids), primaryId); err != nil {
+		return fmt.Errorf("error merging %s: %v", table, err)
+	}
+
+	return nil
+}
+
+// mergeRelationships points the relationships of the secondary contacts to the
+// primary one. Links between merged contacts are dropped, as are links the
+// primary contact would then hold twice.
+func mergeRelationships(tx *sqlx.Tx, primaryId int, ids, secondaryIds []int64) error {
+	all, secondaries := pq.Array(ids), pq.Array(secondaryIds)
+	stmts := []struct {
+		stmt string
+		args []interface{}
+	}{
+		{"DELETE FROM contact_relationships WHERE contact_id = ANY($1) AND related_contact_id = ANY($1)", []interface{}{all}},
+		{`DELETE FROM contact_relationships r USING contact_relationships o
+		WHERE r.contact_id = ANY($3) AND o.contact_id = ANY($2) AND o.id <> r.id
+			AND o.related_contact_id = r.related_contact_id AND o.type = r.type
+			AND (o.contact_id = $1 OR o.id < r.id)`, []interface{}{primaryId, all, secondaries}},
+		{"UPDATE contact_relationships SET contact_id = $1 WHERE contact_id = ANY($2)", []interface{}{primaryId, secondaries}},
+		{`DELETE FROM contact_relationships r USING contact_relationships o
+		WHERE r.related_contact_id = ANY($3) AND o.related_contact_id = ANY($2) AND o.id <> r.id
+			AND o.contact_id = r.contact_id AND o.type = r.type
+			AND (o.related_contact_id = $1 OR o.id < r.id)`, []interface{}{primaryId, all, secondaries}},
+		{"UPDATE contact_relationships SET related_contact_id = $1 WHERE related_contact_id = ANY($2)", []interface{}{primaryId, secondaries}},
+		{`DELETE FROM contact_relationships r USING contact_relationships o
+		WHERE r.bidirectional AND o.bidirectional AND o.type = r.type
+			AND o.contact_id = r.related_contact_id AND o.related_contact_id = r.contact_id
+			AND o.id < r.id AND $1 IN (r.contact_id, r.related_contact_id)`, []interface{}{primaryId}},
+	}
+	for _, item := range stmts {
+		if _, err := tx.Exec(item.stmt, item.args...); err != nil {
+			return fmt.Errorf("error merging relationships: %v", err)
+		}
+	}
+	return nil
+}
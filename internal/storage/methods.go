@@ -0,0 +1,319 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+const (
+	phonesTable    = "contact_phones"
+	emailsTable    = "contact_emails"
+	addressesTable = "contact_addresses"
+)
+
+// emailValueIndex keeps an email from being stored for two contacts.
+const emailValueIndex = "contact_emails_value_key"
+
+var methodTypes = []string{"work", "mobile", "home", "other"}
+
+// ContactMethod is one phone number, email or address of a contact.
+type ContactMethod struct {
+	Id        int    `json:"id" db:"id"`
+	ContactId int    `json:"-" db:"contact_id"`
+	Type      string `json:"type" db:"type"`
+	Value     string `json:"value" db:"value"`
+	Primary   bool   `json:"primary" db:"is_primary"`
+	Position  int    `json:"position" db:"position"`
+	// Components are only set for addresses.
+	Components *PostalAddress `json:"components,omitempty" db:"components"`
+}
+
+type ContactMethodInput struct {
+	Type    string `json:"type" enums:"work,mobile,home,other"`
+	Value   string `json:"value"`
+	Primary bool   `json:"primary"`
+	// Components of an address; when omitted they are parsed from the value.
+	Components *PostalAddress `json:"components,omitempty"`
+}
+
+// addressComponentColumns are the contact_addresses columns holding PostalAddress.
+const addressComponentColumns = "street, building, city, region, postal_code, country, needs_review"
+
+// ContactMethods holds the lists of a contact. A nil list is left unchanged on update.
+type ContactMethods struct {
+	Phones    []ContactMethodInput
+	Emails    []ContactMethodInput
+	Addresses []ContactMethodInput
+}
+
+// normalizeMethods validates a list of contact methods, falling back to a
+// single primary entry with the legacy value when the list is empty. The
+// first entry becomes primary if none is marked.
+func normalizeMethods(kind string, list []ContactMethodInput, fallback string) ([]ContactMethodInput, error) {
+	if len(list) == 0 {
+		if fallback == "" {
+			return nil, nil
+		}
+		list = []ContactMethodInput{{Type: "work", Value: fallback, Primary: true}}
+	}
+
+	primaries := 0
+	normalized := make([]ContactMethodInput, 0, len(list))
+	for _, method := range list {
+		method.Value = strings.TrimSpace(method.Value)
+		if kind == "address" {
+			if method.Components != nil {
+				components := *method.Components
+				components.NeedsReview = false
+				method.Components = &components
+				if method.Value == "" {
+					method.Value = FormatAddress(components)
+				}
+			} else {
+				components := ParseAddress(method.Value)
+				method.Components = &components
+			}
+		}
+		if method.Value == "" {
+			return nil, fmt.Errorf("%s value is empty", kind)
+		}
+		if method.Type == "" {
+			method.Type = "work"
+		}
+		if !containsString(methodTypes, method.Type) {
+			return nil, fmt.Errorf("invalid %s type '%s', expected one of %v", kind, method.Type, methodTypes)
+		}
+		if method.Primary {
+			primaries++
+		}
+		normalized = append(normalized, method)
+	}
+
+	if primaries > 1 {
+		return nil, fmt.Errorf("only one %s can be primary", kind)
+	}
+	if primaries == 0 {
+		normalized[0].Primary = true
+	}
+
+	return normalized, nil
+}
+
+func primaryValue(list []ContactMethodInput) string {
+	for _, method := range list {
+		if method.Primary {
+			return method.Value
+		}
+	}
+	return ""
+}
+
+// checkEmailsAvailable fails with ErrEmailExists if any of the emails is
+// already stored for a contact other than exceptContactId.
+func checkEmailsAvailable(DB sqlx.Queryer, emails []ContactMethodInput, exceptContactId int) error {
+	seen := map[string]bool{}
+	for _, email := range emails {
+		key := strings.ToLower(email.Value)
+		if seen[key] {
+			return fmt.Errorf("%w: email '%s' is listed more than once", ErrInvalidContact, email.Value)
+		}
+		seen[key] = true
+
+		var temp int
+		checkStmt := "SELECT contact_id FROM contact_emails WHERE lower(value) = lower($1) AND contact_id != $2"
+		err := DB.QueryRowx(checkStmt, email.Value, exceptContactId).Scan(&temp)
+		if err == nil {
+			return fmt.Errorf("%w: '%s'", ErrEmailExists, email.Value)
+		}
+		if err != sql.ErrNoRows {
+			return fmt.Errorf("error checking email existence: %v", err)
+		}
+	}
+	return nil
+}
+
+func replaceMethods(tx *sqlx.Tx, table string, contactId int, list []ContactMethodInput) error {
+	deleteStmt := "DELETE FROM " + table + " WHERE contact_id = $1"
+	if _, err := tx.Exec(deleteStmt, contactId); err != nil {
+		return fmt.Errorf("error clearing %s: %v", table, err)
+	}
+
+	insertStmt := "INSERT INTO " + table + " (contact_id, type, value, is_primary, position) VALUES ($1, $2, $3, $4, $5) RETURNING id"
+	for i, method := range list {
+		var id int
+		if err := tx.QueryRow(insertStmt, contactId, method.Type, method.Value, method.Primary, i).Scan(&id); err != nil {
+			if isUniqueViolation(err, emailValueIndex) {
+				return fmt.Errorf("%w: '%s'", ErrEmailExists, method.Value)
+			}
+			return fmt.Errorf("error saving %s: %v", table, err)
+		}
+
+		if table == addressesTable && method.Components != nil {
+			if err := setAddressComponents(tx, id, *method.Components); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func setAddressComponents(tx *sqlx.Tx, id int, address PostalAddress) error {
+	updateStmt := `
+		UPDATE contact_addresses
+		SET street = $2, building = $3, city = $4, region = $5, postal_code = $6, country = $7, needs_review = $8
+		WHERE id = $1
+	`
+	_, err := tx.Exec(updateStmt, id, address.Street, address.Building, address.City, address.Region, address.PostalCode, address.Country, address.NeedsReview)
+	if err != nil {
+		return fmt.Errorf("error saving address components: %v", err)
+	}
+	return nil
+}
+
+// setPrimaryMethod replaces the value of the primary entry, creating it if the
+// contact has none yet.
+func setPrimaryMethod(tx *sqlx.Tx, table string, contactId int, value string) error {
+	var id int
+	updateStmt := "UPDATE " + table + " SET value = $2 WHERE contact_id = $1 AND is_primary RETURNING id"
+	err := tx.QueryRow(updateStmt, contactId, value).Scan(&id)
+	if err == sql.ErrNoRows {
+		insertStmt := "INSERT INTO " + table + " (contact_id, value, is_primary) VALUES ($1, $2, true) RETURNING id"
+		err = tx.QueryRow(insertStmt, contactId, value).Scan(&id)
+	}
+	if err != nil {
+		return fmt.Errorf("error saving %s: %v", table, err)
+	}
+
+	if table == addressesTable {
+		return setAddressComponents(tx, id, ParseAddress(value))
+	}
+	return nil
+}
+
+// attachContactMethods loads the phones, emails and addresses of the contacts in one query per table.
+func attachContactMethods(DB sqlx.Queryer, contacts []Contact_) error {
+	if len(contacts) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, len(contacts))
+	index := make(map[int]int, len(contacts))
+	for i := range contacts {
+		ids[i] = int64(contacts[i].Id)
+		index[contacts[i].Id] = i
+		contacts[i].Phones = []ContactMethod{}
+		contacts[i].Emails = []ContactMethod{}
+		contacts[i].Addresses = []ContactMethod{}
+	}
+
+	for _, table := range []string{phonesTable, emailsTable, addressesTable} {
+		var methods []ContactMethod
+		columns := "id, contact_id, type, value, is_primary, position"
+		if table == addressesTable {
+			for _, column := range strings.Split(addressComponentColumns, ", ") {
+				columns += `, ` + column + ` AS "components.` + column + `"`
+			}
+		}
+
+		stmt := "SELECT " + columns + " FROM " + table + " WHERE contact_id = ANY($1) ORDER BY contact_id, position, id"
+		if err := sqlx.Select(DB, &methods, stmt, pq.Array(ids)); err != nil {
+			return fmt.Errorf("error fetching %s: %v", table, err)
+		}
+
+		for _, method := range methods {
+			contact := &contacts[index[method.ContactId]]
+			switch table {
+			case phonesTable:
+				contact.Phones = append(contact.Phones, method)
+			case emailsTable:
+				contact.Emails = append(contact.Emails, method)
+			case addressesTable:
+				contact.Addresses = append(contact.Addresses, method)
+			}
+		}
+	}
+	return nil
+}
+
+// SetContactMethods replaces the given lists of a contact and keeps the
+// contact's single phone, email and address columns pointing at the primary entries.
+func (storage *ContactStorage) SetContactMethods(id int, methods ContactMethods) error {
+	defer clearCategoryStats()
+
+	tx, err := storage.DB.Beginx()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var exists int
+	if err := tx.QueryRow("SELECT id FROM contacts WHERE id = $1 FOR UPDATE", id).Scan(&exists); err != nil {
+		if err == sql.ErrNoRows {
+			return err
+		}
+		return fmt.Errorf("error fetching contact: %v", err)
+	}
+
+	if err := replaceContactMethods(tx, id, methods); err != nil {
+		return err
+	}
+
+	if methods.Addresses != nil {
+		if err := storage.geocodeContact(tx, id); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing contact methods: %v", err)
+	}
+
+	return nil
+}
+
+// replaceContactMethods replaces the given lists of a contact inside a
+// transaction and syncs the single phone, email and address columns.
+func replaceContactMethods(tx *sqlx.Tx, id int, methods ContactMethods) error {
+	lists := []struct {
+		kind   string
+		table  string
+		column string
+		list   []ContactMethodInput
+	}{
+		{"phone", phonesTable, "phone", methods.Phones},
+		{"email", emailsTable, "email", methods.Emails},
+		{"address", addressesTable, "address", methods.Addresses},
+	}
+
+	for _, item := range lists {
+		if item.list == nil {
+			continue
+		}
+
+		list, err := normalizeMethods(item.kind, item.list, "")
+		if err != nil {
+			return invalidContact(err)
+		}
+
+		if item.table == emailsTable {
+			if err := checkEmailsAvailable(tx, list, id); err != nil {
+				return err
+			}
+		}
+
+		if err := replaceMethods(tx, item.table, id, list); err != nil {
+			return err
+		}
+
+		syncStmt := "UPDATE contacts SET " + item.column + " = $1 WHERE id = $2"
+		if _, err := tx.Exec(syncStmt, primaryValue(list), id); err != nil {
+			return fmt.Errorf("error updating contact: %v", err)
+		}
+	}
+
+	return nil
+}
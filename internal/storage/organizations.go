@@ -0,0 +1,264 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type Organization struct {
+	Id           int       `json:"id" db:"id"`
+	Name         string    `json:"name" db:"name"`
+	TaxId        string    `json:"tax_id" db:"tax_id"`
+	Website      string    `json:"website" db:"website"`
+	Address      string    `json:"address" db:"address"`
+	ContactCount int       `json:"contact_count" db:"contact_count"`
+	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+}
+
+type NewOrganizationInput struct {
+	Name    string
+	TaxId   string
+	Website string
+	Address string
+}
+
+// UpdateOrganizationInput holds optional organization changes, nil fields are left untouched.
+type UpdateOrganizationInput struct {
+	Name    *string
+	TaxId   *string
+	Website *string
+	Address *string
+}
+
+const organizationColumns = `
+	o.id, o.name, o.tax_id, o.website, o.address, o.created_at,
+	(SELECT COUNT(*) FROM contacts c WHERE c.organization_id = o.id) AS contact_count
+`
+
+// ErrOrganizationExists is returned when another organization has the tax ID.
+var ErrOrganizationExists = errors.New("organization with this tax ID already exists")
+
+// taxIdIndex keeps non-empty tax IDs unique.
+const taxIdIndex = "organizations_tax_id_key"
+
+type OrganizationStorage struct {
+	DB *sqlx.DB
+}
+
+func NewOrganizationStorage(DB *sqlx.DB) *OrganizationStorage {
+	return &OrganizationStorage{DB: DB}
+}
+
+func (storage *OrganizationStorage) AddOrganization(data NewOrganizationInput) (int, error) {
+	data.Name = strings.TrimSpace(data.Name)
+	if data.Name == "" {
+		return 0, fmt.Errorf("organization name is empty")
+	}
+
+	if data.TaxId != "" {
+		var id int
+		checkStmt := "SELECT id FROM organizations WHERE tax_id = $1"
+		err := storage.DB.QueryRow(checkStmt, data.TaxId).Scan(&id)
+		if err == nil {
+			return 0, fmt.Errorf("%w: '%s'", ErrOrganizationExists, data.TaxId)
+		}
+		if err != sql.ErrNoRows {
+			return 0, fmt.Errorf("error checking tax ID existence: %v", err)
+		}
+	}
+
+	var id int
+	insertStmt := "INSERT INTO organizations (name, tax_id, website, address) VALUES ($1, $2, $3, $4) RETURNING id"
+	err := storage.DB.QueryRow(insertStmt, data.Name, data.TaxId, data.Website, data.Address).Scan(&id)
+	if err != nil {
+		if isUniqueViolation(err, taxIdIndex) {
+			return 0, fmt.Errorf("%w: '%s'", ErrOrganizationExists, data.TaxId)
+		}
+		return 0, fmt.Errorf("error adding organization: %v", err)
+	}
+
+	return id, nil
+}
+
+func (storage *OrganizationStorage) GetOrganization(id int) (Organization, error) {
+	var organization Organization
+	selectStmt := "SELECT " + organizationColumns + " FROM organizations o WHERE o.id = $1"
+	if err := storage.DB.Get(&organization, selectStmt, id); err != nil {
+		if err == sql.ErrNoRows {
+			return organization, err
+		}
+		return organization, fmt.Errorf("error fetching organization: %v", err)
+	}
+	return organization, nil
+}
+
+// GetOrganizations lists organizations whose name or tax ID matches search.
+func (storage *OrganizationStorage) GetOrganizations(limit, offset int, search string) ([]Organization, error) {
+	organizations := []Organization{}
+	stmt := "SELECT " + organizationColumns + " FROM organizations o"
+	args := []interface{}{}
+
+	if search != "" {
+		args = append(args, "%"+search+"%")
+		stmt += " WHERE o.name ILIKE $1 OR o.tax_id ILIKE $1"
+	}
+
+	stmt += " ORDER BY o.name, o.id"
+
+	if limit > 0 {
+		args = append(args, limit)
+		stmt += " LIMIT $" + strconv.Itoa(len(args))
+	}
+
+	if offset > 0 {
+		if limit <= 0 {
+			return nil, fmt.Errorf("offset specified without limit")
+		}
+		args = append(args, offset)
+		stmt += " OFFSET $" + strconv.Itoa(len(args))
+	}
+
+	if err := storage.DB.Select(&organizations, stmt, args...); err != nil {
+		return nil, fmt.Errorf("error fetching organizations: %v", err)
+	}
+
+	return organizations, nil
+}
+
+func (storage *OrganizationStorage) UpdateOrganization(id int, data UpdateOrganizationInput) error {
+	defer clearCategoryStats()
+
+	stmt := "UPDATE organizations SET"
+	args := []interface{}{id}
+
+	if data.Name != nil {
+		name := strings.TrimSpace(*data.Name)
+		if name == "" {
+			return fmt.Errorf("organization name is empty")
+		}
+		args = append(args, name)
+		stmt += " name = $" + strconv.Itoa(len(args)) + ","
+	}
+	if data.TaxId != nil {
+		if *data.TaxId != "" {
+			var temp int
+			checkStmt := "SELECT id FROM organizations WHERE tax_id = $1 AND id != $2"
+			err := storage.DB.QueryRow(checkStmt, *data.TaxId, id).Scan(&temp)
+			if err == nil {
+				return fmt.Errorf("%w: '%s'", ErrOrganizationExists, *data.TaxId)
+			}
+			if err != sql.ErrNoRows {
+				return fmt.Errorf("error checking tax ID existence: %v", err)
+			}
+		}
+		args = append(args, *data.TaxId)
+		stmt += " tax_id = $" + strconv.Itoa(len(args)) + ","
+	}
+	if data.Website != nil {
+		args = append(args, *data.Website)
+		stmt += " website = $" + strconv.Itoa(len(args)) + ","
+	}
+	if data.Address != nil {
+		args = append(args, *data.Address)
+		stmt += " address = $" + strconv.Itoa(len(args)) + ","
+	}
+
+	if len(args) == 1 {
+		return fmt.Errorf("no organization fields to update")
+	}
+
+	stmt = strings.TrimSuffix(stmt, ",")
+	stmt += " WHERE id = $1"
+
+	resp, err := storage.DB.Exec(stmt, args...)
+	if err != nil {
+		if isUniqueViolation(err, taxIdIndex) {
+			return fmt.Errorf("%w: '%s'", ErrOrganizationExists, *data.TaxId)
+		}
+		return fmt.Errorf("error updating organization: %v", err)
+	}
+
+	rowsAffected, err := resp.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %v", err)
+	}
+
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// DeleteOrganization removes an organization, its contacts are kept and unlinked.
+func (storage *OrganizationStorage) DeleteOrganization(id int) error {
+	defer clearCategoryStats()
+
+	deleteStmt := "DELETE FROM organizations WHERE id = $1"
+	resp, err := storage.DB.Exec(deleteStmt, id)
+	if err != nil {
+		return fmt.Errorf("error deleting organization: %v", err)
+	}
+
+	rowsAffected, err := resp.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %v", err)
+	}
+
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// checkOrganizationExists fails with ErrInvalidContact when there is no
+// organization with the id.
+func checkOrganizationExists(DB sqlx.Queryer, id int) error {
+	var temp int
+	checkStmt := "SELECT id FROM organizations WHERE id = $1"
+	if err := DB.QueryRowx(checkStmt, id).Scan(&temp); err != nil {
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("%w: organization %d does not exist", ErrInvalidContact, id)
+		}
+		return fmt.Errorf("error checking organization existence: %v", err)
+	}
+	return nil
+}
+
+// SetContactOrganization links a contact to an organization with a job title,
+// or unlinks it when organizationId is nil.
+func (storage *ContactStorage) SetContactOrganization(id int, organizationId *int, jobTitle string) error {
+	defer clearCategoryStats()
+
+	if organizationId == nil {
+		jobTitle = ""
+	} else {
+		if err := checkOrganizationExists(storage.DB, *organizationId); err != nil {
+			return err
+		}
+	}
+
+	updateStmt := "UPDATE contacts SET organization_id = $2, job_title = $3 WHERE id = $1"
+	resp, err := storage.DB.Exec(updateStmt, id, organizationId, jobTitle)
+	if err != nil {
+		return fmt.Errorf("error updating contact organization: %v", err)
+	}
+
+	rowsAffected, err := resp.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %v", err)
+	}
+
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
@@ -0,0 +1,293 @@
+package storage
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ContactDocument is the editable part of a contact, the JSON document that
+// patches apply to. Once patched, missing and null members clear their field,
+// except name and category which cannot be cleared. Phone, email and address
+// are the primary entries of the phones, emails and addresses lists.
+type ContactDocument struct {
+	Name           *string              `json:"name"`
+	Phone          *string              `json:"phone"`
+	Email          *string              `json:"email"`
+	Address        *string              `json:"address"`
+	Category       *string              `json:"category"`
+	CustomFields   CustomFields         `json:"custom_fields"`
+	OrganizationId *int                 `json:"organization_id"`
+	JobTitle       *string              `json:"job_title"`
+	Phones         []ContactMethodInput `json:"phones"`
+	Emails         []ContactMethodInput `json:"emails"`
+	Addresses      []ContactMethodInput `json:"addresses"`
+}
+
+func stringValue(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
+
+func methodInputs(list []ContactMethod) []ContactMethodInput {
+	inputs := make([]ContactMethodInput, len(list))
+	for i, method := range list {
+		inputs[i] = ContactMethodInput{
+			Type:       method.Type,
+			Value:      method.Value,
+			Primary:    method.Primary,
+			Components: method.Components,
+		}
+	}
+	return inputs
+}
+
+// setPrimaryValue changes the value of the primary entry of a list, adding it
+// when missing, or removes it when value is empty.
+func setPrimaryValue(list []ContactMethodInput, value string) []ContactMethodInput {
+	index := -1
+	for i, method := range list {
+		if method.Primary {
+			index = i
+			break
+		}
+	}
+	if index == -1 && len(list) > 0 {
+		index = 0
+	}
+
+	switch {
+	case value == "" && index >= 0:
+		return append(list[:index:index], list[index+1:]...)
+	case value == "":
+		return list
+	case index >= 0:
+		list[index].Value = value
+		list[index].Components = nil
+		return list
+	default:
+		return append(list, ContactMethodInput{Type: "work", Value: value, Primary: true})
+	}
+}
+
+// dropStaleComponents clears the address components that no longer describe
+// their address, so they get parsed again from the value: guessed components
+// and components carried over from an address whose value was changed.
+func dropStaleComponents(before, after []ContactMethodInput) {
+	for i := range after {
+		components := after[i].Components
+		if components == nil {
+			continue
+		}
+		if components.NeedsReview {
+			after[i].Components = nil
+			continue
+		}
+		for _, old := range before {
+			if old.Components != nil && *old.Components == *components && old.Value != after[i].Value {
+				after[i].Components = nil
+				break
+			}
+		}
+	}
+}
+
+func methodsEqual(a, b []ContactMethodInput) bool {
+	if len(a) == 0 || len(b) == 0 {
+		return len(a) == len(b)
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func decodeContactDocument(document []byte) (ContactDocument, error) {
+	var doc ContactDocument
+	decoder := json.NewDecoder(bytes.NewReader(document))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&doc); err != nil {
+		return doc, invalidContact(err)
+	}
+	return doc, nil
+}
+
+// PatchContact applies a patch to the ContactDocument of a contact and saves
+// the fields it changed. The patch function receives the current document as
+// JSON and returns the patched one; its errors are returned unchanged.
+// Patched documents that cannot be saved fail with ErrInvalidContact, and
+// emails used by other contacts with ErrEmailExists; emails are only checked
+// for uniqueness when they change.
+func (storage *ContactStorage) PatchContact(id int, patch func(document []byte) ([]byte, error)) error {
+	defer clearCategoryStats()
+
+	tx, err := storage.DB.Beginx()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var current Contact_
+	selectStmt := `
+		SELECT ` + contactColumns + `
+		FROM contacts c` + contactJoins + `
+		WHERE c.id = $1
+		FOR UPDATE OF c
+	`
+	if err := tx.Get(&current, selectStmt, id); err != nil {
+		if err == sql.ErrNoRows {
+			return err
+		}
+		return fmt.Errorf("error fetching contact: %v", err)
+	}
+
+	contacts := []Contact_{current}
+	if err := attachContactMethods(tx, contacts); err != nil {
+		return err
+	}
+	current = contacts[0]
+
+	document, err := json.Marshal(ContactDocument{
+		Name:           &current.Name,
+		Phone:          &current.Phone,
+		Email:          &current.Email,
+		Address:        &current.Address,
+		Category:       &current.Category,
+		CustomFields:   current.CustomFields,
+		OrganizationId: current.OrganizationId,
+		JobTitle:       &current.JobTitle,
+		Phones:         methodInputs(current.Phones),
+		Emails:         methodInputs(current.Emails),
+		Addresses:      methodInputs(current.Addresses),
+	})
+	if err != nil {
+		return fmt.Errorf("error encoding contact: %v", err)
+	}
+
+	patched, err := patch(document)
+	if err != nil {
+		return err
+	}
+
+	// Both sides are decoded the same way so that they compare equal when unchanged.
+	before, err := decodeContactDocument(document)
+	if err != nil {
+		return err
+	}
+	after, err := decodeContactDocument(patched)
+	if err != nil {
+		return err
+	}
+
+	if err := storage.patchContact(tx, id, current, before, after); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error updating contact: %v", err)
+	}
+
+	return nil
+}
+
+func (storage *ContactStorage) patchContact(tx *sqlx.Tx, id int, current Contact_, before, after ContactDocument) error {
+	name := strings.TrimSpace(stringValue(after.Name))
+	if name == "" {
+		return fmt.Errorf("%w: name cannot be empty", ErrInvalidContact)
+	}
+	category := stringValue(after.Category)
+	if category == "" {
+		return fmt.Errorf("%w: category cannot be empty", ErrInvalidContact)
+	}
+
+	categoryId := current.CategoryId
+	if category != stringValue(before.Category) {
+		var err error
+		categoryId, err = GetCategoryIdByLabel(tx, category)
+		if err != nil {
+			if errors.Is(err, ErrUnknownCategory) {
+				return invalidContact(err)
+			}
+			return fmt.Errorf("error fetching category id: %v", err)
+		}
+	}
+
+	customFields := current.CustomFields
+	if categoryId != current.CategoryId || !reflect.DeepEqual(before.CustomFields, after.CustomFields) {
+		defs, err := getCategoryFields(tx, categoryId)
+		if err != nil {
+			return err
+		}
+		customFields, err = ValidateCustomFields(defs, after.CustomFields)
+		if err != nil {
+			return invalidContact(err)
+		}
+	}
+
+	organizationId := after.OrganizationId
+	if organizationId != nil && !reflect.DeepEqual(organizationId, before.OrganizationId) {
+		if err := checkOrganizationExists(tx, *organizationId); err != nil {
+			return err
+		}
+	}
+
+	jobTitle := stringValue(after.JobTitle)
+	if organizationId == nil {
+		jobTitle = ""
+	}
+
+	if name != current.Name || categoryId != current.CategoryId || !reflect.DeepEqual(customFields, current.CustomFields) ||
+		!reflect.DeepEqual(organizationId, current.OrganizationId) || jobTitle != current.JobTitle {
+		updateStmt := `
+			UPDATE contacts
+			SET name = $2, category_id = $3, custom_fields = $4, organization_id = $5, job_title = $6
+			WHERE id = $1
+		`
+		if _, err := tx.Exec(updateStmt, id, name, categoryId, customFields, organizationId, jobTitle); err != nil {
+			return fmt.Errorf("error updating contact: %v", err)
+		}
+	}
+
+	var methods ContactMethods
+	lists := []struct {
+		before, after []ContactMethodInput
+		primaryBefore *string
+		primaryAfter  *string
+		target        *[]ContactMethodInput
+	}{
+		{before.Phones, after.Phones, before.Phone, after.Phone, &methods.Phones},
+		{before.Emails, after.Emails, before.Email, after.Email, &methods.Emails},
+		{before.Addresses, after.Addresses, before.Address, after.Address, &methods.Addresses},
+	}
+	for _, item := range lists {
+		list := item.after
+		if value := stringValue(item.primaryAfter); value != stringValue(item.primaryBefore) {
+			list = setPrimaryValue(list, strings.TrimSpace(value))
+		}
+		if methodsEqual(item.before, list) {
+			continue
+		}
+		if list == nil {
+			list = []ContactMethodInput{}
+		}
+		*item.target = list
+	}
+	dropStaleComponents(before.Addresses, methods.Addresses)
+
+	if err := replaceContactMethods(tx, id, methods); err != nil {
+		return err
+	}
+
+	if methods.Addresses != nil {
+		if err := storage.geocodeContact(tx, id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
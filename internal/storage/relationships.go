@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+var relationshipTypes = []string{"manager", "assistant", "spouse", "referrer"}
+
+// ErrInvalidRelationship is returned for an unknown relationship type or a
+// contact linked to itself.
+var ErrInvalidRelationship = errors.New("invalid relationship")
+
+// ErrRelationshipExists is returned when the contacts are already linked that way.
+var ErrRelationshipExists = errors.New("relationship already exists")
+
+// ContactRelationship is a link as seen from one contact. A stored link
+// (contact A, related B, "manager") reads "B is the manager of A": A sees it
+// as outgoing, B as incoming. Bidirectional links read the same both ways.
+type ContactRelationship struct {
+	Id            int       `json:"id" db:"id"`
+	Type          string    `json:"type" db:"type"`
+	Direction     string    `json:"direction" db:"direction" enums:"outgoing,incoming,mutual"`
+	Bidirectional bool      `json:"bidirectional" db:"bidirectional"`
+	ContactId     int       `json:"contact_id" db:"other_id"`
+	ContactName   string    `json:"contact_name" db:"other_name"`
+	CreatedAt     time.Time `json:"created_at" db:"created_at"`
+}
+
+type NewRelationshipInput struct {
+	RelatedContactId int
+	Type             string
+	Bidirectional    bool
+}
+
+func getContactRelationships(DB sqlx.Queryer, id int) ([]ContactRelationship, error) {
+	relationships := []ContactRelationship{}
+	stmt := `
+		SELECT r.id, r.type, r.bidirectional, r.created_at,
+			CASE WHEN r.bidirectional THEN 'mutual' WHEN r.contact_id = $1 THEN 'outgoing' ELSE 'incoming' END AS direction,
+			other.id AS other_id, other.name AS other_name
+		FROM contact_relationships r
+		JOIN contacts other ON other.id = CASE WHEN r.contact_id = $1 THEN r.related_contact_id ELSE r.contact_id END
+		WHERE r.contact_id = $1 OR r.related_contact_id = $1
+		ORDER BY r.type, other.name, r.id
+	`
+	if err := sqlx.Select(DB, &relationships, stmt, id); err != nil {
+		return nil, fmt.Errorf("error fetching contact relationships: %v", err)
+	}
+	return relationships, nil
+}
+
+func (storage *ContactStorage) GetContactRelationships(id int) ([]ContactRelationship, error) {
+	return getContactRelationships(storage.DB, id)
+}
+
+func (storage *ContactStorage) AddContactRelationship(id int, data NewRelationshipInput) (int, error) {
+	if !containsString(relationshipTypes, data.Type) {
+		return 0, fmt.Errorf("%w: type '%s', expected one of %v", ErrInvalidRelationship, data.Type, relationshipTypes)
+	}
+	if id == data.RelatedContactId {
+		return 0, fmt.Errorf("%w: a contact cannot be related to itself", ErrInvalidRelationship)
+	}
+
+	var found int
+	checkStmt := "SELECT COUNT(*) FROM contacts WHERE id IN ($1, $2)"
+	if err := storage.DB.QueryRow(checkStmt, id, data.RelatedContactId).Scan(&found); err != nil {
+		return 0, fmt.Errorf("error checking contact existence: %v", err)
+	}
+	if found != 2 {
+		return 0, sql.ErrNoRows
+	}
+
+	var existing int
+	duplicateStmt := `
+		SELECT id FROM contact_relationships
+		WHERE type = $3 AND (
+			(contact_id = $1 AND related_contact_id = $2)
+			OR (contact_id = $2 AND related_contact_id = $1 AND (bidirectional OR $4))
+		)
+	`
+	err := storage.DB.QueryRow(duplicateStmt, id, data.RelatedContactId, data.Type, data.Bidirectional).Scan(&existing)
+	if err == nil {
+		return 0, ErrRelationshipExists
+	}
+	if err != sql.ErrNoRows {
+		return 0, fmt.Errorf("error checking relationship existence: %v", err)
+	}
+
+	var relationshipId int
+	insertStmt := `
+		INSERT INTO contact_relationships (contact_id, related_contact_id, type, bidirectional)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id
+	`
+	err = storage.DB.QueryRow(insertStmt, id, data.RelatedContactId, data.Type, data.Bidirectional).Scan(&relationshipId)
+	if err != nil {
+		return 0, fmt.Errorf("error adding relationship: %v", err)
+	}
+
+	return relationshipId, nil
+}
+
+// DeleteContactRelationship removes a link the contact takes part in, from either side.
+func (storage *ContactStorage) DeleteContactRelationship(id, relationshipId int) error {
+	deleteStmt := "DELETE FROM contact_relationships WHERE id = $1 AND (contact_id = $2 OR related_contact_id = $2)"
+	resp, err := storage.DB.Exec(deleteStmt, relationshipId, id)
+	if err != nil {
+		return fmt.Errorf("error deleting relationship: %v", err)
+	}
+
+	rowsAffected, err := resp.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %v", err)
+	}
+
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
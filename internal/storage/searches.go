@@ -0,0 +1,322 @@
+package storage
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// ErrInvalidSavedSearch is returned when saved search data cannot be saved as
+// given.
+var ErrInvalidSavedSearch = errors.New("invalid saved search")
+
+// ErrSavedSearchExists is returned when an owner already has a saved search
+// with the same name.
+var ErrSavedSearchExists = errors.New("saved search with this name already exists")
+
+// ErrNotSearchOwner is returned when a user changes a search shared by someone else.
+var ErrNotSearchOwner = errors.New("only the owner can change a saved search")
+
+// searchNameIndex keeps the search names of an owner unique, ignoring case.
+const searchNameIndex = "saved_searches_owner_name_idx"
+
+// SearchQuery holds the contact list query parameters of a saved search, as
+// accepted by NewContactFilter, stored as a JSONB object.
+type SearchQuery map[string]string
+
+func (query SearchQuery) Value() (driver.Value, error) {
+	if query == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(query)
+}
+
+func (query *SearchQuery) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	case nil:
+		*query = SearchQuery{}
+		return nil
+	default:
+		return fmt.Errorf("unsupported search query type %T", src)
+	}
+	return json.Unmarshal(data, query)
+}
+
+// searchQueryKeys are the filter parameters a saved search may store, besides
+// cf.<key> custom field filters.
+var searchQueryKeys = map[string]bool{
+	"name": true, "phone": true, "email": true, "category": true, "organization": true,
+	"city": true, "region": true, "country": true, "near": true, "radius": true, "filter": true,
+}
+
+// SavedSearch is a named contact query of a user. Shared searches are visible
+// to everyone, but only their owner can change them.
+type SavedSearch struct {
+	Id        int         `json:"id" db:"id"`
+	Owner     string      `json:"owner" db:"owner"`
+	Name      string      `json:"name" db:"name"`
+	Shared    bool        `json:"shared" db:"shared"`
+	Query     SearchQuery `json:"query" db:"query" swaggertype:"object,string"`
+	SortBy    string      `json:"sort_by" db:"sort_by"`
+	SortDir   string      `json:"sort_dir" db:"sort_dir"`
+	CreatedAt time.Time   `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time   `json:"updated_at" db:"updated_at"`
+}
+
+// SavedSearchCount is the number of contacts currently matching a saved search.
+type SavedSearchCount struct {
+	Id    int    `json:"id"`
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+type NewSavedSearchInput struct {
+	Name    string
+	Shared  bool
+	Query   SearchQuery
+	SortBy  string
+	SortDir string
+}
+
+// UpdateSavedSearchInput holds optional saved search changes, nil fields are left untouched.
+type UpdateSavedSearchInput struct {
+	Name    *string
+	Shared  *bool
+	Query   *SearchQuery
+	SortBy  *string
+	SortDir *string
+}
+
+const savedSearchColumns = "id, owner, name, shared, query, sort_by, sort_dir, created_at, updated_at"
+
+type SavedSearchStorage struct {
+	DB *sqlx.DB
+}
+
+func NewSavedSearchStorage(DB *sqlx.DB) *SavedSearchStorage {
+	return &SavedSearchStorage{DB: DB}
+}
+
+// Filter builds the contact filter of a saved search.
+func (search SavedSearch) Filter() (ContactFilter, error) {
+	return NewContactFilter(search.Query)
+}
+
+// validateSearchQuery checks that a query only holds known filter parameters
+// that parse, so the search can be run later.
+func validateSearchQuery(query SearchQuery) error {
+	for key := range query {
+		if !searchQueryKeys[key] && !(strings.HasPrefix(key, customFieldPrefix) && len(key) > len(customFieldPrefix)) {
+			return fmt.Errorf("%w: unknown search parameter '%s'", ErrInvalidSavedSearch, key)
+		}
+	}
+	if _, err := NewContactFilter(query); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSavedSearch, err)
+	}
+	return nil
+}
+
+// normalizeSort checks a sort field and direction, returning the direction in
+// upper case.
+func normalizeSort(sortBy, sortDir string) (string, error) {
+	if _, ok := contactSortColumns[sortBy]; !ok {
+		return "", fmt.Errorf("%w: invalid sort field '%s'", ErrInvalidSavedSearch, sortBy)
+	}
+	sortDir = strings.ToUpper(sortDir)
+	if sortDir != "ASC" && sortDir != "DESC" {
+		return "", fmt.Errorf("%w: invalid sort direction '%s'", ErrInvalidSavedSearch, sortDir)
+	}
+	return sortDir, nil
+}
+
+// checkSearchName fails with ErrSavedSearchExists when owner has another
+// search named name, ignoring case. It gives a clear error up front, while
+// searchNameIndex catches concurrent saves.
+func (storage *SavedSearchStorage) checkSearchName(owner, name string, id int) error {
+	var temp int
+	checkStmt := "SELECT id FROM saved_searches WHERE owner = $1 AND lower(name) = lower($2) AND id != $3"
+	err := storage.DB.QueryRow(checkStmt, owner, name, id).Scan(&temp)
+	if err == nil {
+		return ErrSavedSearchExists
+	}
+	if err != sql.ErrNoRows {
+		return fmt.Errorf("error checking saved search name: %v", err)
+	}
+	return nil
+}
+
+func (storage *SavedSearchStorage) AddSavedSearch(owner string, data NewSavedSearchInput) (int, error) {
+	owner = strings.TrimSpace(owner)
+	if owner == "" {
+		return 0, fmt.Errorf("%w: owner is empty", ErrInvalidSavedSearch)
+	}
+	data.Name = strings.TrimSpace(data.Name)
+	if data.Name == "" {
+		return 0, fmt.Errorf("%w: name is empty", ErrInvalidSavedSearch)
+	}
+	if data.SortBy == "" {
+		data.SortBy = "created_at"
+	}
+	if data.SortDir == "" {
+		data.SortDir = "ASC"
+	}
+
+	sortDir, err := normalizeSort(data.SortBy, data.SortDir)
+	if err != nil {
+		return 0, err
+	}
+	if err := validateSearchQuery(data.Query); err != nil {
+		return 0, err
+	}
+	if err := storage.checkSearchName(owner, data.Name, 0); err != nil {
+		return 0, err
+	}
+
+	var id int
+	insertStmt := `
+		INSERT INTO saved_searches (owner, name, shared, query, sort_by, sort_dir)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id
+	`
+	err = storage.DB.QueryRow(insertStmt, owner, data.Name, data.Shared, data.Query, data.SortBy, sortDir).Scan(&id)
+	if err != nil {
+		if isUniqueViolation(err, searchNameIndex) {
+			return 0, ErrSavedSearchExists
+		}
+		return 0, fmt.Errorf("error inserting saved search: %v", err)
+	}
+
+	return id, nil
+}
+
+// GetSavedSearch returns a saved search visible to user: one of theirs or a
+// shared one. Other searches are reported as sql.ErrNoRows.
+func (storage *SavedSearchStorage) GetSavedSearch(id int, user string) (SavedSearch, error) {
+	var search SavedSearch
+	stmt := "SELECT " + savedSearchColumns + " FROM saved_searches WHERE id = $1 AND (owner = $2 OR shared)"
+	err := storage.DB.Get(&search, stmt, id, user)
+	if err != nil && err != sql.ErrNoRows {
+		return search, fmt.Errorf("error fetching saved search: %v", err)
+	}
+	return search, err
+}
+
+// GetSavedSearches returns the searches of user followed by the searches
+// shared by others, each ordered by name.
+func (storage *SavedSearchStorage) GetSavedSearches(user string) ([]SavedSearch, error) {
+	var searches []SavedSearch
+	stmt := `
+		SELECT ` + savedSearchColumns + `
+		FROM saved_searches
+		WHERE owner = $1 OR shared
+		ORDER BY owner <> $1, lower(name), id
+	`
+	if err := storage.DB.Select(&searches, stmt, user); err != nil {
+		return nil, fmt.Errorf("error retrieving saved searches: %v", err)
+	}
+	return searches, nil
+}
+
+// UpdateSavedSearch changes a saved search of owner. Searches shared by other
+// users fail with ErrNotSearchOwner, unshared ones with sql.ErrNoRows.
+func (storage *SavedSearchStorage) UpdateSavedSearch(id int, owner string, data UpdateSavedSearchInput) error {
+	current, err := storage.GetSavedSearch(id, owner)
+	if err != nil {
+		return err
+	}
+	if current.Owner != owner {
+		return ErrNotSearchOwner
+	}
+
+	stmt := "UPDATE saved_searches SET"
+	args := []interface{}{id}
+
+	if data.Name != nil {
+		name := strings.TrimSpace(*data.Name)
+		if name == "" {
+			return fmt.Errorf("%w: name is empty", ErrInvalidSavedSearch)
+		}
+		if err := storage.checkSearchName(owner, name, id); err != nil {
+			return err
+		}
+		args = append(args, name)
+		stmt += " name = $" + strconv.Itoa(len(args)) + ","
+	}
+	if data.Shared != nil {
+		args = append(args, *data.Shared)
+		stmt += " shared = $" + strconv.Itoa(len(args)) + ","
+	}
+	if data.Query != nil {
+		if err := validateSearchQuery(*data.Query); err != nil {
+			return err
+		}
+		args = append(args, *data.Query)
+		stmt += " query = $" + strconv.Itoa(len(args)) + ","
+	}
+	if data.SortBy != nil || data.SortDir != nil {
+		sortBy, sortDir := current.SortBy, current.SortDir
+		if data.SortBy != nil {
+			sortBy = *data.SortBy
+		}
+		if data.SortDir != nil {
+			sortDir = *data.SortDir
+		}
+		sortDir, err := normalizeSort(sortBy, sortDir)
+		if err != nil {
+			return err
+		}
+		args = append(args, sortBy, sortDir)
+		stmt += " sort_by = $" + strconv.Itoa(len(args)-1) + ", sort_dir = $" + strconv.Itoa(len(args)) + ","
+	}
+
+	if len(args) == 1 {
+		return fmt.Errorf("%w: no fields to update", ErrInvalidSavedSearch)
+	}
+
+	stmt += " updated_at = now() WHERE id = $1"
+	if _, err := storage.DB.Exec(stmt, args...); err != nil {
+		if isUniqueViolation(err, searchNameIndex) {
+			return ErrSavedSearchExists
+		}
+		return fmt.Errorf("error updating saved search: %v", err)
+	}
+	return nil
+}
+
+// DeleteSavedSearch removes a saved search of owner. Searches shared by other
+// users fail with ErrNotSearchOwner, unshared ones with sql.ErrNoRows.
+func (storage *SavedSearchStorage) DeleteSavedSearch(id int, owner string) error {
+	current, err := storage.GetSavedSearch(id, owner)
+	if err != nil {
+		return err
+	}
+	if current.Owner != owner {
+		return ErrNotSearchOwner
+	}
+
+	resp, err := storage.DB.Exec("DELETE FROM saved_searches WHERE id = $1 AND owner = $2", id, owner)
+	if err != nil {
+		return fmt.Errorf("error deleting saved search: %v", err)
+	}
+
+	rows, err := resp.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %v", err)
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
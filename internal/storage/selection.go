@@ -0,0 +1,190 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// contactFieldColumns maps the fields of Contact_ that can be selected in a
+// listing to the columns they are read from. Phones, emails and addresses
+// have no column and are loaded separately.
+var contactFieldColumns = map[string]string{
+	"id":                "c.id",
+	"name":              "c.name",
+	"phone":             "c.phone",
+	"email":             "c.email",
+	"address":           "c.address",
+	"category_id":       "c.category_id",
+	"category":          "cat.label as category",
+	"custom_fields":     "c.custom_fields",
+	"latitude":          "c.latitude",
+	"longitude":         "c.longitude",
+	"location_source":   "c.location_source",
+	"organization_id":   "c.organization_id",
+	"organization":      "org.name as organization",
+	"job_title":         "c.job_title",
+	"last_contacted_at": "c.last_contacted_at",
+	"created_at":        "c.created_at",
+	"distance_km":       "",
+	"phones":            "",
+	"emails":            "",
+	"addresses":         "",
+}
+
+// contactFieldOrder is the order of the selected columns.
+var contactFieldOrder = []string{
+	"id", "name", "phone", "email", "address", "category_id", "category", "custom_fields",
+	"latitude", "longitude", "location_source", "organization_id", "organization", "job_title",
+	"last_contacted_at", "created_at",
+}
+
+// IncludeCategory is the include value embedding the category of each contact.
+const IncludeCategory = "category"
+
+// ContactSelection picks what a contact listing returns. Fields are JSON
+// field names of Contact_, every field when empty. IncludeCategory replaces
+// the category label with the whole category, and selects it.
+type ContactSelection struct {
+	Fields          []string
+	IncludeCategory bool
+}
+
+// ContactView is a contact trimmed to the selected fields.
+type ContactView map[string]interface{}
+
+// NewContactSelection parses the comma separated fields and include query
+// parameters of a listing.
+func NewContactSelection(fields, include string) (ContactSelection, error) {
+	var selection ContactSelection
+
+	for _, field := range strings.Split(fields, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if _, ok := contactFieldColumns[field]; !ok {
+			return selection, fmt.Errorf("unknown field '%s'", field)
+		}
+		if !containsString(selection.Fields, field) {
+			selection.Fields = append(selection.Fields, field)
+		}
+	}
+
+	for _, relation := range strings.Split(include, ",") {
+		switch strings.TrimSpace(relation) {
+		case "":
+		case IncludeCategory:
+			selection.IncludeCategory = true
+		default:
+			return selection, fmt.Errorf("unknown include '%s', expected %s", relation, IncludeCategory)
+		}
+	}
+
+	return selection, nil
+}
+
+// IsZero reports whether the selection returns contacts unchanged.
+func (selection ContactSelection) IsZero() bool {
+	return len(selection.Fields) == 0 && !selection.IncludeCategory
+}
+
+// selects reports whether a field is part of the output.
+func (selection ContactSelection) selects(field string) bool {
+	if len(selection.Fields) == 0 {
+		return true
+	}
+	return containsString(selection.Fields, field) || (field == "category" && selection.IncludeCategory)
+}
+
+// GetSelectedContacts lists contacts like GetContacts, only reading the
+// columns of the selected fields. The id is always read to load contact
+// methods and categories, but only returned when selected.
+func (storage *ContactStorage) GetSelectedContacts(limit, offset int, filter ContactFilter, sortBy, sortDir string, selection ContactSelection) ([]ContactView, error) {
+	read := func(field string) bool {
+		switch field {
+		case "id":
+			return true
+		case "category_id":
+			return selection.IncludeCategory || selection.selects(field)
+		case "category":
+			// An included category is loaded from the categories table instead.
+			return !selection.IncludeCategory && selection.selects(field)
+		}
+		return selection.selects(field)
+	}
+
+	columns := []string{}
+	joins := ""
+	for _, field := range contactFieldOrder {
+		if !read(field) {
+			continue
+		}
+		columns = append(columns, contactFieldColumns[field])
+		switch field {
+		case "category":
+			joins += "\n\t\tLEFT JOIN categories cat ON c.category_id = cat.id"
+		case "organization":
+			joins += "\n\t\tLEFT JOIN organizations org ON c.organization_id = org.id"
+		}
+	}
+
+	contacts, err := storage.selectContacts(strings.Join(columns, ", "), joins+"\n", limit, offset, filter, sortBy, sortDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if selection.selects("phones") || selection.selects("emails") || selection.selects("addresses") {
+		if err := attachContactMethods(storage.DB, contacts); err != nil {
+			return nil, err
+		}
+	}
+
+	categories := map[int]Category{}
+	if selection.IncludeCategory && len(contacts) > 0 {
+		ids := []int64{}
+		for _, contact := range contacts {
+			ids = append(ids, int64(contact.CategoryId))
+		}
+
+		var list []Category
+		selectStmt := "SELECT " + categoryColumns + " FROM categories WHERE id = ANY($1)"
+		if err := storage.DB.Select(&list, selectStmt, pq.Array(ids)); err != nil {
+			return nil, fmt.Errorf("error fetching categories: %v", err)
+		}
+		for _, category := range list {
+			categories[category.Id] = category
+		}
+	}
+
+	views := make([]ContactView, 0, len(contacts))
+	for _, contact := range contacts {
+		encoded, err := json.Marshal(contact)
+		if err != nil {
+			return nil, fmt.Errorf("error encoding contact: %v", err)
+		}
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(encoded, &fields); err != nil {
+			return nil, fmt.Errorf("error encoding contact: %v", err)
+		}
+
+		view := ContactView{}
+		for key, value := range fields {
+			if selection.selects(key) {
+				view[key] = value
+			}
+		}
+		if selection.IncludeCategory {
+			if category, ok := categories[contact.CategoryId]; ok {
+				view["category"] = category
+			} else {
+				view["category"] = nil
+			}
+		}
+		views = append(views, view)
+	}
+
+	return views, nil
+}
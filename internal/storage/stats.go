@@ -0,0 +1,144 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// statsCacheTTL is how long computed category statistics are served from memory.
+const statsCacheTTL = 30 * time.Second
+
+// maxStatsCacheEntries bounds the number of filters cached at once.
+const maxStatsCacheEntries = 1000
+
+type CategoryStats struct {
+	CategoryId        int        `json:"category_id" db:"category_id"`
+	Label             string     `json:"label" db:"label"`
+	Archived          bool       `json:"archived" db:"archived"`
+	ContactCount      int        `json:"contact_count" db:"contact_count"`
+	CreatedLast7Days  int        `json:"created_last_7_days" db:"created_last_7_days"`
+	CreatedLast30Days int        `json:"created_last_30_days" db:"created_last_30_days"`
+	NewestContactAt   *time.Time `json:"newest_contact_at" db:"newest_contact_at"`
+}
+
+type statsCacheEntry struct {
+	stats     []CategoryStats
+	expiresAt time.Time
+}
+
+// statsCache holds computed statistics by filter. Its generation changes on
+// every clear, so results computed before a write are not stored after it.
+type statsCache struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	entries    map[string]statsCacheEntry
+	generation int
+}
+
+func newStatsCache(ttl time.Duration) *statsCache {
+	return &statsCache{ttl: ttl, entries: make(map[string]statsCacheEntry)}
+}
+
+// get returns the cached statistics for key, along with the generation to
+// pass to set when they have to be computed.
+func (cache *statsCache) get(key string) ([]CategoryStats, int, bool) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	entry, ok := cache.entries[key]
+	if !ok || time.Now().After(entry.expiresAt) {
+		delete(cache.entries, key)
+		return nil, cache.generation, false
+	}
+	return entry.stats, cache.generation, true
+}
+
+func (cache *statsCache) set(key string, stats []CategoryStats, generation int) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	if generation != cache.generation {
+		return
+	}
+
+	now := time.Now()
+	if len(cache.entries) >= maxStatsCacheEntries {
+		for other, entry := range cache.entries {
+			if now.After(entry.expiresAt) {
+				delete(cache.entries, other)
+			}
+		}
+		if len(cache.entries) >= maxStatsCacheEntries {
+			return
+		}
+	}
+	cache.entries[key] = statsCacheEntry{stats: stats, expiresAt: now.Add(cache.ttl)}
+}
+
+func (cache *statsCache) clear() {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	cache.entries = make(map[string]statsCacheEntry)
+	cache.generation++
+}
+
+// categoryStats is shared by every storage so that writes to contacts or
+// categories can drop statistics they make stale.
+var categoryStats = newStatsCache(statsCacheTTL)
+
+// clearCategoryStats drops the cached statistics. Writes that may change
+// which contacts a filter matches, or the categories themselves, call it.
+func clearCategoryStats() {
+	categoryStats.clear()
+}
+
+// GetCategoryStats returns per-category contact counts for contacts matching filter.
+// Results are cached for a short time unless fresh is set; writes through the
+// storages clear the cache, so only changes made outside them can be missed.
+func (storage *CategoryStorage) GetCategoryStats(filter ContactFilter, includeArchived, fresh bool) ([]CategoryStats, error) {
+	args := []interface{}{}
+	join := "c.category_id = cat.id"
+	for _, cond := range filter.conditions(&args) {
+		join += " AND " + cond
+	}
+
+	// The conditions and their arguments describe the filter by value, unlike
+	// the filter itself which holds pointers.
+	encodedArgs, err := json.Marshal(args)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding category stats filter: %v", err)
+	}
+	key := fmt.Sprintf("%s|%s|%t", join, encodedArgs, includeArchived)
+	cached, generation, ok := categoryStats.get(key)
+	if ok && !fresh {
+		return cached, nil
+	}
+
+	stmt := `
+		SELECT cat.id AS category_id, cat.label, cat.archived,
+			COUNT(c.id) AS contact_count,
+			COUNT(c.id) FILTER (WHERE c.created_at >= now() - interval '7 days') AS created_last_7_days,
+			COUNT(c.id) FILTER (WHERE c.created_at >= now() - interval '30 days') AS created_last_30_days,
+			MAX(c.created_at) AS newest_contact_at
+		FROM categories cat
+		LEFT JOIN contacts c ON ` + join + `
+	`
+	if !includeArchived {
+		stmt += " WHERE cat.archived = false"
+	}
+	stmt += `
+		GROUP BY cat.id, cat.label, cat.archived, cat.position
+		ORDER BY cat.position, cat.id
+	`
+
+	var stats []CategoryStats
+	if err := storage.DB.Select(&stats, stmt, args...); err != nil {
+		return nil, fmt.Errorf("error fetching category stats: %v", err)
+	}
+
+	categoryStats.set(key, stats, generation)
+	return stats, nil
+}
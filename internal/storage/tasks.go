@@ -0,0 +1,305 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	TaskOpen       = "open"
+	TaskInProgress = "in_progress"
+	TaskDone       = "done"
+	TaskCancelled  = "cancelled"
+)
+
+// ErrInvalidTask is returned when task data or a status change is not valid.
+var ErrInvalidTask = errors.New("invalid task")
+
+// taskTransitions lists the statuses a task may move to from each status.
+var taskTransitions = map[string][]string{
+	TaskOpen:       {TaskInProgress, TaskDone, TaskCancelled},
+	TaskInProgress: {TaskOpen, TaskDone, TaskCancelled},
+	TaskDone:       {TaskOpen},
+	TaskCancelled:  {TaskOpen},
+}
+
+type Task struct {
+	Id          int        `json:"id" db:"id"`
+	ContactId   int        `json:"contact_id" db:"contact_id"`
+	ContactName string     `json:"contact_name" db:"contact_name"`
+	Title       string     `json:"title" db:"title"`
+	Description string     `json:"description" db:"description"`
+	DueAt       time.Time  `json:"due_at" db:"due_at"`
+	Assignee    string     `json:"assignee" db:"assignee"`
+	Status      string     `json:"status" db:"status"`
+	RemindedAt  *time.Time `json:"reminded_at" db:"reminded_at"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+type NewTaskInput struct {
+	Title       string
+	Description string
+	DueAt       time.Time
+	Assignee    string
+}
+
+// UpdateTaskInput holds optional task changes, nil fields are left untouched.
+type UpdateTaskInput struct {
+	Title       *string
+	Description *string
+	DueAt       *time.Time
+	Assignee    *string
+}
+
+const taskColumns = "t.id, t.contact_id, c.name AS contact_name, t.title, t.description, t.due_at, t.assignee, t.status, t.reminded_at, t.created_at, t.updated_at"
+
+type TaskStorage struct {
+	DB *sqlx.DB
+}
+
+func NewTaskStorage(DB *sqlx.DB) *TaskStorage {
+	return &TaskStorage{DB: DB}
+}
+
+func (storage *TaskStorage) AddTask(contactId int, data NewTaskInput) (int, error) {
+	data.Title = strings.TrimSpace(data.Title)
+	if data.Title == "" {
+		return 0, fmt.Errorf("%w: title is empty", ErrInvalidTask)
+	}
+	if data.DueAt.IsZero() {
+		return 0, fmt.Errorf("%w: due date is required", ErrInvalidTask)
+	}
+
+	var exists int
+	if err := storage.DB.QueryRow("SELECT id FROM contacts WHERE id = $1", contactId).Scan(&exists); err != nil {
+		if err == sql.ErrNoRows {
+			return 0, err
+		}
+		return 0, fmt.Errorf("error fetching contact: %v", err)
+	}
+
+	var id int
+	insertStmt := `
+		INSERT INTO contact_tasks (contact_id, title, description, due_at, assignee)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id
+	`
+	err := storage.DB.QueryRow(insertStmt, contactId, data.Title, data.Description, data.DueAt, data.Assignee).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("error adding task: %v", err)
+	}
+
+	return id, nil
+}
+
+func (storage *TaskStorage) GetTask(id int) (Task, error) {
+	var task Task
+	selectStmt := "SELECT " + taskColumns + " FROM contact_tasks t JOIN contacts c ON c.id = t.contact_id WHERE t.id = $1"
+	if err := storage.DB.Get(&task, selectStmt, id); err != nil {
+		if err == sql.ErrNoRows {
+			return task, err
+		}
+		return task, fmt.Errorf("error fetching task: %v", err)
+	}
+	return task, nil
+}
+
+// TaskFilter narrows task listings; zero values match everything.
+type TaskFilter struct {
+	ContactId int
+	Assignee  string
+	Status    string
+	// Overdue only matches open or in progress tasks past their due date.
+	Overdue bool
+}
+
+// GetTasks lists tasks ordered by due date.
+func (storage *TaskStorage) GetTasks(limit, offset int, filter TaskFilter) ([]Task, error) {
+	tasks := []Task{}
+	stmt := "SELECT " + taskColumns + " FROM contact_tasks t JOIN contacts c ON c.id = t.contact_id WHERE 1=1"
+	args := []interface{}{}
+
+	if filter.ContactId != 0 {
+		args = append(args, filter.ContactId)
+		stmt += " AND t.contact_id = $" + strconv.Itoa(len(args))
+	}
+	if filter.Assignee != "" {
+		args = append(args, filter.Assignee)
+		stmt += " AND t.assignee = $" + strconv.Itoa(len(args))
+	}
+	if filter.Status != "" {
+		args = append(args, filter.Status)
+		stmt += " AND t.status = $" + strconv.Itoa(len(args))
+	}
+	if filter.Overdue {
+		stmt += " AND t.status IN ('open', 'in_progress') AND t.due_at < now()"
+	}
+
+	stmt += " ORDER BY t.due_at, t.id"
+
+	if limit > 0 {
+		args = append(args, limit)
+		stmt += " LIMIT $" + strconv.Itoa(len(args))
+	}
+
+	if offset > 0 {
+		if limit <= 0 {
+			return nil, fmt.Errorf("offset specified without limit")
+		}
+		args = append(args, offset)
+		stmt += " OFFSET $" + strconv.Itoa(len(args))
+	}
+
+	if err := storage.DB.Select(&tasks, stmt, args...); err != nil {
+		return nil, fmt.Errorf("error fetching tasks: %v", err)
+	}
+
+	return tasks, nil
+}
+
+// UpdateTask changes task details. Moving the due date re-arms its reminder.
+func (storage *TaskStorage) UpdateTask(id int, data UpdateTaskInput) error {
+	stmt := "UPDATE contact_tasks SET updated_at = now(),"
+	args := []interface{}{id}
+
+	if data.Title != nil {
+		title := strings.TrimSpace(*data.Title)
+		if title == "" {
+			return fmt.Errorf("%w: title is empty", ErrInvalidTask)
+		}
+		args = append(args, title)
+		stmt += " title = $" + strconv.Itoa(len(args)) + ","
+	}
+	if data.Description != nil {
+		args = append(args, *data.Description)
+		stmt += " description = $" + strconv.Itoa(len(args)) + ","
+	}
+	if data.DueAt != nil {
+		args = append(args, *data.DueAt)
+		stmt += " due_at = $" + strconv.Itoa(len(args)) + ", reminded_at = NULL,"
+	}
+	if data.Assignee != nil {
+		args = append(args, *data.Assignee)
+		stmt += " assignee = $" + strconv.Itoa(len(args)) + ","
+	}
+
+	stmt = strings.TrimSuffix(stmt, ",")
+	stmt += " WHERE id = $1"
+
+	resp, err := storage.DB.Exec(stmt, args...)
+	if err != nil {
+		return fmt.Errorf("error updating task: %v", err)
+	}
+
+	rowsAffected, err := resp.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %v", err)
+	}
+
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// SetTaskStatus moves a task to a new status if the transition is allowed.
+// Reopening a task re-arms its reminder.
+func (storage *TaskStorage) SetTaskStatus(id int, status string) error {
+	if _, ok := taskTransitions[status]; !ok {
+		return fmt.Errorf("%w: status '%s'", ErrInvalidTask, status)
+	}
+
+	tx, err := storage.DB.Beginx()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var current string
+	if err := tx.QueryRow("SELECT status FROM contact_tasks WHERE id = $1 FOR UPDATE", id).Scan(&current); err != nil {
+		if err == sql.ErrNoRows {
+			return err
+		}
+		return fmt.Errorf("error fetching task: %v", err)
+	}
+
+	if !containsString(taskTransitions[current], status) {
+		return fmt.Errorf("%w: cannot move task from '%s' to '%s'", ErrInvalidTask, current, status)
+	}
+
+	updateStmt := "UPDATE contact_tasks SET status = $2, updated_at = now() WHERE id = $1"
+	if status == TaskOpen {
+		updateStmt = "UPDATE contact_tasks SET status = $2, updated_at = now(), reminded_at = NULL WHERE id = $1"
+	}
+	if _, err := tx.Exec(updateStmt, id, status); err != nil {
+		return fmt.Errorf("error updating task status: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing task status: %v", err)
+	}
+
+	return nil
+}
+
+func (storage *TaskStorage) DeleteTask(id int) error {
+	deleteStmt := "DELETE FROM contact_tasks WHERE id = $1"
+	resp, err := storage.DB.Exec(deleteStmt, id)
+	if err != nil {
+		return fmt.Errorf("error deleting task: %v", err)
+	}
+
+	rowsAffected, err := resp.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %v", err)
+	}
+
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// ClaimDueTasks marks pending tasks that became due as reminded and returns
+// them. Rows locked by another instance are skipped, so each reminder is
+// claimed once.
+func (storage *TaskStorage) ClaimDueTasks(limit int) ([]Task, error) {
+	tasks := []Task{}
+	stmt := `
+		WITH due AS (
+			UPDATE contact_tasks SET reminded_at = now()
+			WHERE id IN (
+				SELECT id FROM contact_tasks
+				WHERE status IN ('open', 'in_progress') AND due_at <= now() AND reminded_at IS NULL
+				ORDER BY due_at
+				LIMIT $1
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING *
+		)
+		SELECT ` + taskColumns + `
+		FROM due t JOIN contacts c ON c.id = t.contact_id
+		ORDER BY t.due_at
+	`
+	if err := storage.DB.Select(&tasks, stmt, limit); err != nil {
+		return nil, fmt.Errorf("error claiming due tasks: %v", err)
+	}
+	return tasks, nil
+}
+
+// ReleaseReminder re-arms the reminder of a task whose notification failed.
+func (storage *TaskStorage) ReleaseReminder(id int) error {
+	if _, err := storage.DB.Exec("UPDATE contact_tasks SET reminded_at = NULL WHERE id = $1", id); err != nil {
+		return fmt.Errorf("error releasing task reminder: %v", err)
+	}
+	return nil
+}
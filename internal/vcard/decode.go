@@ -0,0 +1,327 @@
+package vcard
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/utah1280/backend-internship-2024/internal/storage"
+)
+
+// Property is one content line of a vCard. Names and parameter names are
+// uppercased, values are kept escaped.
+type Property struct {
+	Name   string
+	Params map[string][]string
+	Value  string
+}
+
+// Card is a decoded vCard. Line is the line of its BEGIN:VCARD.
+type Card struct {
+	Line       int
+	Version    string
+	Properties []Property
+}
+
+// Get returns the properties with the given name.
+func (card Card) Get(name string) []Property {
+	properties := []Property{}
+	for _, property := range card.Properties {
+		if property.Name == name {
+			properties = append(properties, property)
+		}
+	}
+	return properties
+}
+
+// Text returns the unescaped value of a property.
+func (property Property) Text() string {
+	return unescape(property.Value)
+}
+
+// UID returns the UID of a card, empty when it has none.
+func (card Card) UID() string {
+	if uids := card.Get("UID"); len(uids) > 0 {
+		return strings.TrimSpace(uids[0].Text())
+	}
+	return ""
+}
+
+// hasType reports whether a TYPE parameter lists the type, case-insensitively.
+func (property Property) hasType(name string) bool {
+	for _, value := range property.Params["TYPE"] {
+		if strings.EqualFold(value, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// preference returns the preference of a property, 1 being the most
+// preferred and 0 meaning none was given.
+func (property Property) preference() int {
+	if values := property.Params["PREF"]; len(values) > 0 {
+		if pref, err := strconv.Atoi(values[0]); err == nil && pref > 0 {
+			return pref
+		}
+	}
+	if property.hasType("pref") {
+		return 1
+	}
+	return 0
+}
+
+// unescape reverses escape.
+func unescape(value string) string {
+	var builder strings.Builder
+	escaped := false
+	for _, r := range value {
+		switch {
+		case escaped && (r == 'n' || r == 'N'):
+			builder.WriteRune('\n')
+		case escaped:
+			builder.WriteRune(r)
+		case r == '\\':
+			escaped = true
+			continue
+		default:
+			builder.WriteRune(r)
+		}
+		escaped = false
+	}
+	return builder.String()
+}
+
+// split splits an escaped value on unescaped separators and unescapes the parts.
+func split(value string, separator rune) []string {
+	parts := []string{}
+	start, escaped := 0, false
+	for i, r := range value {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == separator:
+			parts = append(parts, unescape(value[start:i]))
+			start = i + 1
+		}
+	}
+	return append(parts, unescape(value[start:]))
+}
+
+// parseLine parses an unfolded content line of the form
+// [group.]name *(;param[=value[,value]]):value.
+func parseLine(line string) (Property, error) {
+	property := Property{Params: map[string][]string{}}
+
+	quoted := false
+	colon := -1
+	for i, r := range line {
+		if r == '"' {
+			quoted = !quoted
+		}
+		if r == ':' && !quoted {
+			colon = i
+			break
+		}
+	}
+	if colon < 0 {
+		return property, fmt.Errorf("missing ':' in '%s'", line)
+	}
+	property.Value = line[colon+1:]
+
+	fields := []string{}
+	start := 0
+	quoted = false
+	head := line[:colon]
+	for i, r := range head {
+		if r == '"' {
+			quoted = !quoted
+		}
+		if r == ';' && !quoted {
+			fields = append(fields, head[start:i])
+			start = i + 1
+		}
+	}
+	fields = append(fields, head[start:])
+
+	name := strings.ToUpper(fields[0])
+	if dot := strings.LastIndex(name, "."); dot >= 0 {
+		name = name[dot+1:]
+	}
+	if name == "" {
+		return property, fmt.Errorf("missing property name in '%s'", line)
+	}
+	property.Name = name
+
+	for _, param := range fields[1:] {
+		key, value, found := strings.Cut(param, "=")
+		if !found {
+			// vCard 2.1 style bare types, e.g. TEL;CELL:...
+			key, value = "TYPE", param
+		}
+		key = strings.ToUpper(key)
+		for _, item := range strings.Split(value, ",") {
+			property.Params[key] = append(property.Params[key], strings.Trim(item, `"`))
+		}
+	}
+
+	return property, nil
+}
+
+// Decode reads all vCards of a file. Folded lines are joined and content
+// outside BEGIN:VCARD and END:VCARD is ignored.
+func Decode(r io.Reader) ([]Card, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	type logicalLine struct {
+		number int
+		text   string
+	}
+	lines := []logicalLine{}
+	for number := 1; scanner.Scan(); number++ {
+		text := strings.TrimRight(scanner.Text(), "\r")
+		if number == 1 {
+			text = strings.TrimPrefix(text, "\ufeff")
+		}
+		if len(lines) > 0 && (strings.HasPrefix(text, " ") || strings.HasPrefix(text, "\t")) {
+			lines[len(lines)-1].text += text[1:]
+			continue
+		}
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		lines = append(lines, logicalLine{number: number, text: text})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading vCard: %v", err)
+	}
+
+	cards := []Card{}
+	var card *Card
+	for _, line := range lines {
+		property, err := parseLine(line.text)
+		if err != nil {
+			if card == nil {
+				continue
+			}
+			return nil, fmt.Errorf("line %d: %v", line.number, err)
+		}
+
+		switch {
+		case property.Name == "BEGIN" && strings.EqualFold(property.Value, "VCARD"):
+			if card != nil {
+				return nil, fmt.Errorf("line %d: vCard starting on line %d is not terminated", line.number, card.Line)
+			}
+			card = &Card{Line: line.number}
+		case card == nil:
+		case property.Name == "END" && strings.EqualFold(property.Value, "VCARD"):
+			cards = append(cards, *card)
+			card = nil
+		case property.Name == "VERSION":
+			card.Version = property.Value
+		default:
+			card.Properties = append(card.Properties, property)
+		}
+	}
+	if card != nil {
+		return nil, fmt.Errorf("vCard starting on line %d is not terminated", card.Line)
+	}
+
+	return cards, nil
+}
+
+// methodType maps the TYPE parameter of a property to a contact method type.
+func methodType(property Property) string {
+	for _, name := range []string{"cell", "home", "work"} {
+		if property.hasType(name) {
+			if name == "cell" {
+				return "mobile"
+			}
+			return name
+		}
+	}
+	return "other"
+}
+
+// methods turns TEL, EMAIL or ADR properties into contact methods, the most
+// preferred one becoming primary.
+func methods(properties []Property, value func(Property) (string, *storage.PostalAddress)) []storage.ContactMethodInput {
+	sort.SliceStable(properties, func(i, j int) bool {
+		a, b := properties[i].preference(), properties[j].preference()
+		return a != 0 && (b == 0 || a < b)
+	})
+
+	list := []storage.ContactMethodInput{}
+	for _, property := range properties {
+		text, components := value(property)
+		if strings.TrimSpace(text) == "" && components == nil {
+			continue
+		}
+		list = append(list, storage.ContactMethodInput{
+			Type:       methodType(property),
+			Value:      strings.TrimSpace(text),
+			Primary:    len(list) == 0 && property.preference() != 0,
+			Components: components,
+		})
+	}
+	return list
+}
+
+// ToImportRecord maps a card to an import record: FN (or N) to the name, TEL,
+// EMAIL and ADR to contact methods, the first CATEGORIES value to the
+// category and TITLE to the job title.
+func ToImportRecord(card Card) storage.ImportRecord {
+	record := storage.ImportRecord{Line: card.Line}
+
+	if names := card.Get("FN"); len(names) > 0 {
+		record.Name = unescape(names[0].Value)
+	}
+	if names := card.Get("N"); strings.TrimSpace(record.Name) == "" && len(names) > 0 {
+		parts := split(names[0].Value, ';')
+		words := []string{}
+		for _, i := range []int{3, 1, 2, 0, 4} {
+			if i < len(parts) && strings.TrimSpace(parts[i]) != "" {
+				words = append(words, strings.TrimSpace(parts[i]))
+			}
+		}
+		record.Name = strings.Join(words, " ")
+	}
+
+	text := func(property Property) (string, *storage.PostalAddress) {
+		return strings.TrimPrefix(unescape(property.Value), "tel:"), nil
+	}
+	record.Phones = methods(card.Get("TEL"), text)
+	record.Emails = methods(card.Get("EMAIL"), text)
+	record.Addresses = methods(card.Get("ADR"), func(property Property) (string, *storage.PostalAddress) {
+		parts := split(property.Value, ';')
+		for len(parts) < 7 {
+			parts = append(parts, "")
+		}
+		address := storage.PostalAddress{
+			Street:     strings.TrimSpace(strings.Join(strings.Fields(parts[1]+" "+parts[2]), " ")),
+			City:       strings.TrimSpace(parts[3]),
+			Region:     strings.TrimSpace(parts[4]),
+			PostalCode: strings.TrimSpace(parts[5]),
+			Country:    strings.TrimSpace(parts[6]),
+		}
+		if address == (storage.PostalAddress{}) {
+			return "", nil
+		}
+		return "", &address
+	})
+
+	if categories := card.Get("CATEGORIES"); len(categories) > 0 {
+		record.Category = strings.TrimSpace(split(categories[0].Value, ',')[0])
+	}
+	if titles := card.Get("TITLE"); len(titles) > 0 {
+		record.JobTitle = unescape(titles[0].Value)
+	}
+
+	return record
+}
@@ -0,0 +1,156 @@
+// Package vcard encodes contacts as vCard 3.0 or 4.0 (RFC 2426, RFC 6350)
+// and decodes vCards into import records.
+package vcard
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/utah1280/backend-internship-2024/internal/storage"
+)
+
+const (
+	Version3 = "3.0"
+	Version4 = "4.0"
+)
+
+// ContentType is the MIME type of vCard files.
+const ContentType = "text/vcard; charset=utf-8"
+
+// maxLineLength is the length in octets after which content lines are folded.
+const maxLineLength = 75
+
+// methodTypes maps contact method types to vCard TYPE values. Mobile only
+// exists for phones and other has no vCard equivalent.
+var methodTypes = map[string]string{
+	"work":   "work",
+	"home":   "home",
+	"mobile": "cell",
+}
+
+// UID returns the vCard UID of a contact.
+func UID(id int) string {
+	return "contact-" + strconv.Itoa(id)
+}
+
+// escape escapes a text value; structured values are escaped per component.
+func escape(value string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, ",", `\,`, ";", `\;`, "\r\n", `\n`, "\n", `\n`)
+	return replacer.Replace(value)
+}
+
+// writeLine writes a content line folded at maxLineLength octets without
+// splitting UTF-8 sequences.
+func writeLine(w io.Writer, line string) error {
+	var builder strings.Builder
+	length := 0
+	for _, r := range line {
+		size := len(string(r))
+		if length+size > maxLineLength {
+			builder.WriteString("\r\n ")
+			length = 1
+		}
+		builder.WriteRune(r)
+		length += size
+	}
+	builder.WriteString("\r\n")
+	_, err := io.WriteString(w, builder.String())
+	return err
+}
+
+// typeParams returns the parameters of a phone, email or address.
+func typeParams(version, kind string, method storage.ContactMethod) string {
+	types := []string{}
+	if kind == "EMAIL" && version == Version3 {
+		types = append(types, "internet")
+	}
+	if name, ok := methodTypes[method.Type]; ok && (name != "cell" || kind == "TEL") {
+		types = append(types, name)
+	}
+
+	params := ""
+	if version == Version3 {
+		if method.Primary {
+			types = append(types, "pref")
+		}
+		if len(types) > 0 {
+			params = ";TYPE=" + strings.ToUpper(strings.Join(types, ","))
+		}
+		return params
+	}
+
+	if len(types) > 0 {
+		params = ";TYPE=" + strings.Join(types, ",")
+	}
+	if method.Primary {
+		params += ";PREF=1"
+	}
+	return params
+}
+
+// splitName splits a full name into family and given names, the family name
+// being the last word.
+func splitName(name string) (string, string) {
+	words := strings.Fields(name)
+	if len(words) < 2 {
+		return "", strings.Join(words, " ")
+	}
+	return words[len(words)-1], strings.Join(words[:len(words)-1], " ")
+}
+
+// Encode writes a contact as a vCard of the given version.
+func Encode(w io.Writer, contact storage.Contact_, version string) error {
+	return EncodeWithUID(w, contact, UID(contact.Id), version)
+}
+
+// EncodeWithUID writes a contact as a vCard with the given UID, used for
+// contacts whose UID was chosen by a CardDAV client.
+func EncodeWithUID(w io.Writer, contact storage.Contact_, uid, version string) error {
+	if version != Version3 && version != Version4 {
+		return fmt.Errorf("invalid vCard version '%s', expected %s or %s", version, Version3, Version4)
+	}
+
+	family, given := splitName(contact.Name)
+	lines := []string{
+		"BEGIN:VCARD",
+		"VERSION:" + version,
+		"UID:" + escape(uid),
+		"FN:" + escape(contact.Name),
+		"N:" + escape(family) + ";" + escape(given) + ";;;",
+	}
+	if contact.Organization != nil && *contact.Organization != "" {
+		lines = append(lines, "ORG:"+escape(*contact.Organization))
+	}
+	if contact.JobTitle != "" {
+		lines = append(lines, "TITLE:"+escape(contact.JobTitle))
+	}
+	for _, phone := range contact.Phones {
+		lines = append(lines, "TEL"+typeParams(version, "TEL", phone)+":"+escape(phone.Value))
+	}
+	for _, email := range contact.Emails {
+		lines = append(lines, "EMAIL"+typeParams(version, "EMAIL", email)+":"+escape(email.Value))
+	}
+	for _, address := range contact.Addresses {
+		components := storage.ParseAddress(address.Value)
+		if address.Components != nil {
+			components = *address.Components
+		}
+		street := strings.TrimSpace(components.Street + " " + components.Building)
+		value := strings.Join([]string{"", "", escape(street), escape(components.City), escape(components.Region),
+			escape(components.PostalCode), escape(components.Country)}, ";")
+		lines = append(lines, "ADR"+typeParams(version, "ADR", address)+":"+value)
+	}
+	if contact.Category != "" {
+		lines = append(lines, "CATEGORIES:"+escape(contact.Category))
+	}
+	lines = append(lines, "END:VCARD")
+
+	for _, line := range lines {
+		if err := writeLine(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
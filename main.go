@@ -2,8 +2,16 @@ package main
 
 import (
 	"github.com/utah1280/backend-internship-2024/database/postgres"
+	"github.com/utah1280/backend-internship-2024/internal/geocode"
+	"github.com/utah1280/backend-internship-2024/internal/handlers/activity"
+	"github.com/utah1280/backend-internship-2024/internal/handlers/apiv2"
+	"github.com/utah1280/backend-internship-2024/internal/handlers/carddav"
 	"github.com/utah1280/backend-internship-2024/internal/handlers/category"
 	"github.com/utah1280/backend-internship-2024/internal/handlers/contact"
+	"github.com/utah1280/backend-internship-2024/internal/handlers/organization"
+	"github.com/utah1280/backend-internship-2024/internal/handlers/task"
+	"github.com/utah1280/backend-internship-2024/internal/idempotency"
+	"github.com/utah1280/backend-internship-2024/internal/reminder"
 	"github.com/utah1280/backend-internship-2024/internal/server"
 	"github.com/utah1280/backend-internship-2024/internal/storage"
 	"go.uber.org/fx"
@@ -13,11 +21,24 @@ func main() {
 	fx.New(
 		fx.Provide(
 			postgres.NewPostgresConnection,
+			geocode.NewGazetteer,
 			storage.NewCategoryStorage,
 			storage.NewContactStorage,
+			storage.NewOrganizationStorage,
+			storage.NewActivityStorage,
+			storage.NewTaskStorage,
+			storage.NewIdempotencyStorage,
+			storage.NewSavedSearchStorage,
+			reminder.NewNotifier,
+			idempotency.NewMiddleware,
 			category.NewCategoryHandler,
 			contact.NewContactHandler,
+			organization.NewOrganizationHandler,
+			activity.NewActivityHandler,
+			task.NewTaskHandler,
+			carddav.NewCardDAVHandler,
+			apiv2.NewAPIHandler,
 		),
-		fx.Invoke(server.NewFiberServer),
+		fx.Invoke(server.NewFiberServer, reminder.NewScheduler),
 	).Run()
 }